@@ -8,17 +8,29 @@ package cartridge
 
 import (
 	"errors"
+	"io"
+	"sync"
 
 	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/atomyze-foundation/cartridge/enrollment"
+	"github.com/atomyze-foundation/cartridge/expiry"
 	"github.com/atomyze-foundation/cartridge/manager"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
 )
 
 // Connector holds all necessary data (endpoints, certs) for connecting to HLF network.
 type Connector struct {
-	manager       manager.Manager
-	provider      ConnectProvider
-	cryptoStorage cryptocache.CryptoCache
+	manager         manager.Manager
+	provider        ConnectProvider
+	cryptoStorage   cryptocache.CryptoCache
+	cryptoSuiteOpts []CryptoSuiteOption
+	mu              sync.Mutex
+	identityConfig  msp.IdentityConfig
+	endpointConfig  fab.EndpointConfig
+	identities      *manager.IdentityRegistry
+	identitiesOnce  sync.Once
 }
 
 // NewConnector creates Connector instance.
@@ -26,21 +38,114 @@ func NewConnector(manager manager.Manager, provider ConnectProvider) *Connector
 	return &Connector{manager: manager, provider: provider, cryptoStorage: manager.Cache()}
 }
 
+// WithCryptoSuiteOptions sets options of the crypto suite, e.g. WithSignTimeout
+func (c *Connector) WithCryptoSuiteOptions(opts ...CryptoSuiteOption) {
+	c.cryptoSuiteOpts = opts
+}
+
 // Opts creates options array for subsequent pass to the fabsdk.New constructor.
+// The configs are created by the first successful call and shared by later ones.
 func (c *Connector) Opts() ([]fabsdk.Option, error) {
 	if c.cryptoStorage != nil {
 		if c.provider == nil {
 			return nil, errors.New("connect provider is empty")
 		}
-		identityConfig, err := c.provider.IdentityConfig(c.cryptoStorage)
+		identityConfig, endpointConfig, err := c.loadConfigs()
 		if err != nil {
 			return nil, err
 		}
-		endpointConfig, err := c.provider.EndpointConfig(c.cryptoStorage)
-		if err != nil {
-			return nil, err
+		return []fabsdk.Option{fabsdk.WithCorePkg(NewCartridgeProviderFactory(c.manager, c.cryptoSuiteOpts...)), fabsdk.WithIdentityConfig(identityConfig), fabsdk.WithEndpointConfig(endpointConfig)}, nil
+	}
+	return []fabsdk.Option{fabsdk.WithCorePkg(NewCartridgeProviderFactory(c.manager, c.cryptoSuiteOpts...))}, nil
+}
+
+// loadConfigs creates the identity and endpoint configs unless they are created already
+func (c *Connector) loadConfigs() (msp.IdentityConfig, fab.EndpointConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.identityConfig != nil {
+		return c.identityConfig, c.endpointConfig, nil
+	}
+
+	identityConfig, err := c.provider.IdentityConfig(c.cryptoStorage)
+	if err != nil {
+		return nil, nil, err
+	}
+	endpointConfig, err := c.provider.EndpointConfig(c.cryptoStorage)
+	if err != nil {
+		if closer, ok := identityConfig.(io.Closer); ok {
+			_ = closer.Close()
 		}
-		return []fabsdk.Option{fabsdk.WithCorePkg(NewCartridgeProviderFactory(c.manager)), fabsdk.WithIdentityConfig(identityConfig), fabsdk.WithEndpointConfig(endpointConfig)}, nil
+		return nil, nil, err
+	}
+	c.identityConfig, c.endpointConfig = identityConfig, endpointConfig
+	return identityConfig, endpointConfig, nil
+}
+
+// configs returns the configs created by Opts
+func (c *Connector) configs() (msp.IdentityConfig, fab.EndpointConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.identityConfig, c.endpointConfig
+}
+
+// ExpiryInspector returns the inspector of certificates of the manager cache and of the configs created by Opts
+func (c *Connector) ExpiryInspector(opts ...expiry.Option) *expiry.Inspector {
+	inspectorOpts := []expiry.Option{expiry.WithCache(c.manager.Cache())}
+	if identityConfig, endpointConfig := c.configs(); identityConfig != nil {
+		inspectorOpts = append(inspectorOpts, expiry.WithIdentityConfig(identityConfig), expiry.WithEndpointConfig(endpointConfig))
+	}
+	return expiry.NewInspector(append(inspectorOpts, opts...)...)
+}
+
+// Enroller returns the enroller with CA caID of the identity config created by Opts
+func (c *Connector) Enroller(caID string, opts ...enrollment.Option) (*enrollment.Enroller, error) {
+	if identityConfig, _ := c.configs(); identityConfig != nil {
+		return enrollment.NewEnroller(identityConfig, caID, c.manager, opts...)
+	}
+	return nil, errors.New("identity config is not created, call Opts first")
+}
+
+// Identities returns the registry of signing identities available to the manager
+func (c *Connector) Identities() *manager.IdentityRegistry {
+	c.identitiesOnce.Do(func() {
+		c.identities = manager.NewIdentityRegistry(c.manager)
+	})
+	return c.identities
+}
+
+// WithIdentity returns the option of fabsdk contexts signing as identity name,
+// a certificate name or an enrollment ID, e.g. sdk.ChannelContext("mychannel", opt)
+func (c *Connector) WithIdentity(name string) (fabsdk.ContextOption, error) {
+	identity, err := c.Identities().Identity(name)
+	if err != nil {
+		return nil, err
+	}
+	return fabsdk.WithIdentity(identity), nil
+}
+
+// Close stops change tracking of the configs created by Opts and of the identities, and closes the manager.
+// The SDK created with the options must be closed first.
+func (c *Connector) Close() error {
+	c.mu.Lock()
+	configs := []interface{}{c.identityConfig, c.endpointConfig}
+	c.identityConfig, c.endpointConfig = nil, nil
+	c.mu.Unlock()
+
+	var closeErr error
+	for _, config := range configs {
+		if closer, ok := config.(io.Closer); ok {
+			if err := closer.Close(); err != nil && closeErr == nil {
+				closeErr = err
+			}
+		}
+	}
+	if c.identities != nil {
+		c.identities.Close()
+	}
+
+	if err := c.manager.Close(); err != nil {
+		return err
 	}
-	return []fabsdk.Option{fabsdk.WithCorePkg(NewCartridgeProviderFactory(c.manager))}, nil
+	return closeErr
 }
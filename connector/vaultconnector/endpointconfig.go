@@ -15,6 +15,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/atomyze-foundation/cartridge/cryptocache"
@@ -130,8 +131,9 @@ var (
 
 // EndpointConfigFromBackend returns endpoint config implementation for given backend
 func EndpointConfigFromBackend(cache cryptocache.CryptoCache, channelConfigProvider func(name string) *fab.ChannelEndpointConfig, channelPeersProvider func(channel string) []fab.ChannelPeer, coreBackend ...core.ConfigBackend) (fab.EndpointConfig, error) {
+	recorder := newRecordingCache(cache)
 	config := &EndpointConfig{
-		cache:                 cache,
+		cache:                 recorder,
 		backend:               lookup.New(coreBackend...),
 		channelConfigProvider: channelConfigProvider,
 		channelPeersProvider:  channelPeersProvider,
@@ -141,6 +143,9 @@ func EndpointConfigFromBackend(cache cryptocache.CryptoCache, channelConfigProvi
 		return nil, errors.WithMessage(err, "network configuration load failed")
 	}
 
+	config.cache = cache
+	config.watchCrypto(recorder.keys)
+
 	// print deprecated warning
 	detectDeprecatedNetworkConfig(config)
 
@@ -169,6 +174,10 @@ type EndpointConfig struct {
 	cache                    cryptocache.CryptoCache
 	channelConfigProvider    func(name string) *fab.ChannelEndpointConfig
 	channelPeersProvider     func(channel string) []fab.ChannelPeer
+	mu                       sync.RWMutex // guards the configuration replaced on reload
+	reloadMu                 sync.Mutex
+	usedCrypto               map[string]struct{}
+	unsubscribe              func()
 }
 
 // endpointConfigEntity contains endpoint config elements needed by endpointconfig
@@ -188,33 +197,45 @@ func (c *EndpointConfig) Timeout(tType fab.TimeoutType) time.Duration {
 
 // OrderersConfig returns a list of defined orderers
 func (c *EndpointConfig) OrderersConfig() []fab.OrdererConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.ordererConfigs
 }
 
 // OrdererConfig returns the requested orderer
 func (c *EndpointConfig) OrdererConfig(nameOrURL string) (*fab.OrdererConfig, bool, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.tryMatchingOrdererConfig(nameOrURL, true)
 }
 
 // PeersConfig Retrieves the fabric peers for the specified org from the
 // config file provided
 func (c *EndpointConfig) PeersConfig(org string) ([]fab.PeerConfig, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	peerConfigs, ok := c.peerConfigsByOrg[strings.ToLower(org)]
 	return peerConfigs, ok
 }
 
 // PeerConfig Retrieves a specific peer from the configuration by name or url
 func (c *EndpointConfig) PeerConfig(nameOrURL string) (*fab.PeerConfig, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.tryMatchingPeerConfig(nameOrURL, true)
 }
 
 // NetworkConfig returns the network configuration defined in the config file
 func (c *EndpointConfig) NetworkConfig() *fab.NetworkConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.networkConfig
 }
 
 // NetworkPeers returns the network peers configuration, all the peers from all the orgs in config.
 func (c *EndpointConfig) NetworkPeers() []fab.NetworkPeer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.networkPeers
 }
 
@@ -251,6 +272,8 @@ func (c *EndpointConfig) ChannelConfig(name string) *fab.ChannelEndpointConfig {
 	if c.channelConfigProvider != nil {
 		return c.channelConfigProvider(name)
 	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	// get the mapped channel Name
 	mappedChannelName := c.mappedChannelName(c.networkConfig, name)
 	if mappedChannelName == defaultEntity {
@@ -270,6 +293,8 @@ func (c *EndpointConfig) ChannelPeers(name string) []fab.ChannelPeer {
 	if c.channelPeersProvider != nil {
 		return c.channelPeersProvider(name)
 	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
 	// get mapped channel name
 	mappedChannelName := c.mappedChannelName(c.networkConfig, name)
@@ -280,6 +305,8 @@ func (c *EndpointConfig) ChannelPeers(name string) []fab.ChannelPeer {
 
 // ChannelOrderers returns a list of channel orderers
 func (c *EndpointConfig) ChannelOrderers(name string) []fab.OrdererConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	// get mapped channel name
 	mappedChannelName := c.mappedChannelName(c.networkConfig, name)
 
@@ -295,6 +322,8 @@ func (c *EndpointConfig) TLSCACertPool() commtls.CertPool {
 
 // TLSClientCerts loads the client's certs for mutual TLS
 func (c *EndpointConfig) TLSClientCerts() []tls.Certificate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.tlsClientCerts
 }
 
@@ -1530,7 +1559,7 @@ func (c *EndpointConfig) tryMatchingOrdererConfig(ordererSearchKey string, searc
 
 	if searchByURL {
 		// lookup by URL
-		for _, ordererCfg := range c.OrderersConfig() {
+		for _, ordererCfg := range c.ordererConfigs {
 			if strings.EqualFold(ordererCfg.URL, ordererSearchKey) {
 				return &fab.OrdererConfig{
 					URL:         ordererCfg.URL,
@@ -1697,10 +1726,10 @@ func (c *EndpointConfig) loadTLSCerts() ([]*x509.Certificate, error) {
 	return certs, errs.ToError()
 }
 
-// ResetNetworkConfig clears network config cache
+// ResetNetworkConfig loads the network config again
 func (c *EndpointConfig) ResetNetworkConfig() error {
-	c.networkConfig = nil
-	return c.loadEndpointConfiguration()
+	_, err := c.reload()
+	return err
 }
 
 // PeerMSPID returns msp that peer belongs to
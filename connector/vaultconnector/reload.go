@@ -0,0 +1,116 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package vaultconnector
+
+import (
+	"sync"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+)
+
+// recordingCache records keys of crypto read from the cache
+type recordingCache struct {
+	cryptocache.CryptoCache
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newRecordingCache(cache cryptocache.CryptoCache) *recordingCache {
+	return &recordingCache{CryptoCache: cache, keys: make(map[string]struct{})}
+}
+
+// GetCrypto retrieves crypto from the underlying cache and records its key
+func (r *recordingCache) GetCrypto(key string) ([]byte, error) {
+	r.mu.Lock()
+	r.keys[key] = struct{}{}
+	r.mu.Unlock()
+	return r.CryptoCache.GetCrypto(key)
+}
+
+// watchCrypto makes the config reload TLS certificates when crypto in usedCrypto changes in the cache.
+// Caches not reporting changes are not watched.
+func (c *EndpointConfig) watchCrypto(usedCrypto map[string]struct{}) {
+	notifier, ok := c.cache.(cryptocache.Notifier)
+	if !ok {
+		return
+	}
+	c.usedCrypto = usedCrypto
+	c.unsubscribe = notifier.Subscribe(func(event cryptocache.Event) {
+		if _, ok := c.usedCrypto[event.Key]; ok {
+			c.reloadTLS(event.Key)
+		}
+	})
+}
+
+// Close stops reloading of TLS certificates
+func (c *EndpointConfig) Close() error {
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+		c.unsubscribe = nil
+	}
+	return nil
+}
+
+// reloadTLS loads the configuration again, replaces TLS CA certificates of peers and orderers and
+// the TLS client certificates and adds new TLS CA certificates to the cert pool.
+// Established connections are not affected.
+func (c *EndpointConfig) reloadTLS(changedKey string) {
+	fresh, err := c.reload()
+	if err != nil {
+		// a certificate and its key change one after another, the pair is consistent after both events
+		logger.Warnf("failed to reload TLS configuration after %s changed: %s", changedKey, err)
+		return
+	}
+
+	certs, err := fresh.loadTLSCerts()
+	if err != nil {
+		logger.Warnf("could not cache TLS certs: %s", err)
+	}
+	c.tlsCertPool.Add(certs...)
+	if _, err = c.tlsCertPool.Get(); err != nil {
+		logger.Warnf("cert pool load failed: %s", err)
+	}
+
+	logger.Infof("TLS configuration reloaded after %s changed", changedKey)
+}
+
+// reload loads the configuration from the backend and the cache again and replaces the current one,
+// the cert pool is kept as clients may hold it
+func (c *EndpointConfig) reload() (*EndpointConfig, error) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	fresh := &EndpointConfig{
+		cache:                 c.cache,
+		backend:               c.backend,
+		channelConfigProvider: c.channelConfigProvider,
+		channelPeersProvider:  c.channelPeersProvider,
+	}
+	if err := fresh.loadEndpointConfiguration(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.networkConfig = fresh.networkConfig
+	c.entityMatchers = fresh.entityMatchers
+	c.peerConfigsByOrg = fresh.peerConfigsByOrg
+	c.networkPeers = fresh.networkPeers
+	c.ordererConfigs = fresh.ordererConfigs
+	c.channelPeersByChannel = fresh.channelPeersByChannel
+	c.channelOrderersByChannel = fresh.channelOrderersByChannel
+	c.tlsClientCerts = fresh.tlsClientCerts
+	c.peerMatchers = fresh.peerMatchers
+	c.ordererMatchers = fresh.ordererMatchers
+	c.channelMatchers = fresh.channelMatchers
+	c.defaultPeerConfig = fresh.defaultPeerConfig
+	c.defaultOrdererConfig = fresh.defaultOrdererConfig
+	c.defaultChannelPolicies = fresh.defaultChannelPolicies
+	c.defaultChannel = fresh.defaultChannel
+
+	return fresh, nil
+}
@@ -0,0 +1,261 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package vaultconnector
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+)
+
+const (
+	testPeer          = "peer0.org1.example.com"
+	testOrderer       = "orderer.example.com"
+	testPeerTLSCA     = "tlsca.org1.example.com-cert.pem"
+	testOrdererTLSCA  = "tlsca.example.com-cert.pem"
+	testClientTLSCert = "User1@org1.example.com/tls/client.crt"
+	testClientTLSKey  = "User1@org1.example.com/tls/client.key"
+)
+
+const testNetworkConfig = `
+version: 1.0.0
+client:
+  organization: org1
+  tlsCerts:
+    client:
+      key:
+        path: User1@org1.example.com/tls/client.key
+      cert:
+        path: User1@org1.example.com/tls/client.crt
+organizations:
+  org1:
+    mspid: Org1MSP
+    peers:
+      - peer0.org1.example.com
+orderers:
+  orderer.example.com:
+    url: grpcs://localhost:7050
+    tlsCACerts:
+      path: /crypto/tlscacerts/tlsca.example.com-cert.pem
+peers:
+  peer0.org1.example.com:
+    url: grpcs://localhost:7051
+    tlsCACerts:
+      path: /crypto/tlscacerts/tlsca.org1.example.com-cert.pem
+channels:
+  mychannel:
+    peers:
+      peer0.org1.example.com: {}
+`
+
+// newTestCert returns a new self-signed PEM certificate with common name cn and its PEM private key
+func newTestCert(t *testing.T, cn string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// newTestEndpointConfig returns the endpoint config of testNetworkConfig with TLS crypto in a new cache
+func newTestEndpointConfig(t *testing.T) (*EndpointConfig, *cryptocache.MemCache) {
+	t.Helper()
+
+	cache := cryptocache.NewMemCache()
+	for _, name := range []string{testPeerTLSCA, testOrdererTLSCA} {
+		cert, _ := newTestCert(t, name)
+		setCrypto(t, cache, name, cert)
+	}
+	cert, key := newTestCert(t, "User1@org1.example.com")
+	setCrypto(t, cache, testClientTLSCert, cert)
+	setCrypto(t, cache, testClientTLSKey, key)
+
+	backends, err := config.FromRaw([]byte(testNetworkConfig), "yaml")()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpointConfig, err := EndpointConfigFromBackend(cache, nil, nil, backends...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := endpointConfig.(*EndpointConfig)
+	t.Cleanup(func() { _ = c.Close() })
+	return c, cache
+}
+
+func setCrypto(t *testing.T, cache cryptocache.CryptoCache, key string, value []byte) {
+	t.Helper()
+
+	if err := cache.SetCrypto(key, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkTLSCACert checks that peer and orderer configs hold TLS CA certificates peerCA and ordererCA
+func checkTLSCACert(t *testing.T, c *EndpointConfig, peerCA, ordererCA []byte) {
+	t.Helper()
+
+	isCert := func(crt *x509.Certificate, want []byte) bool {
+		return crt != nil && bytes.Equal(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}), want)
+	}
+
+	peer, ok := c.PeerConfig(testPeer)
+	if !ok || !isCert(peer.TLSCACert, peerCA) {
+		t.Error("peer config holds another TLS CA certificate")
+	}
+	if peers, _ := c.PeersConfig("org1"); len(peers) != 1 || !isCert(peers[0].TLSCACert, peerCA) {
+		t.Error("peers of org1 hold another TLS CA certificate")
+	}
+	if !isCert(c.NetworkConfig().Peers[testPeer].TLSCACert, peerCA) {
+		t.Error("network config holds another TLS CA certificate of the peer")
+	}
+	if peers := c.ChannelPeers("mychannel"); len(peers) != 1 || !isCert(peers[0].TLSCACert, peerCA) {
+		t.Error("channel peers hold another TLS CA certificate")
+	}
+
+	orderer, ok, _ := c.OrdererConfig(testOrderer)
+	if !ok || !isCert(orderer.TLSCACert, ordererCA) {
+		t.Error("orderer config holds another TLS CA certificate")
+	}
+	if orderers := c.OrderersConfig(); len(orderers) != 1 || !isCert(orderers[0].TLSCACert, ordererCA) {
+		t.Error("orderers hold another TLS CA certificate")
+	}
+	if !isCert(c.NetworkConfig().Orderers[testOrderer].TLSCACert, ordererCA) {
+		t.Error("network config holds another TLS CA certificate of the orderer")
+	}
+
+	pool, err := c.TLSCACertPool().Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ca := range [][]byte{peerCA, ordererCA} {
+		block, _ := pem.Decode(ca)
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = crt.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			t.Errorf("TLS CA certificate %s is not in the cert pool: %s", crt.Subject.CommonName, err)
+		}
+	}
+}
+
+func TestEndpointConfigReloadTLSCACerts(t *testing.T) {
+	c, cache := newTestEndpointConfig(t)
+	peerCA, _ := cache.GetCrypto(testPeerTLSCA)
+	ordererCA, _ := cache.GetCrypto(testOrdererTLSCA)
+	checkTLSCACert(t, c, peerCA, ordererCA)
+
+	// e.g. refresh of the manager read rotated TLS CA certificates
+	peerCA, _ = newTestCert(t, testPeerTLSCA)
+	setCrypto(t, cache, testPeerTLSCA, peerCA)
+	ordererCA, _ = newTestCert(t, testOrdererTLSCA)
+	setCrypto(t, cache, testOrdererTLSCA, ordererCA)
+	checkTLSCACert(t, c, peerCA, ordererCA)
+
+	if err := c.ResetNetworkConfig(); err != nil {
+		t.Fatal(err)
+	}
+	checkTLSCACert(t, c, peerCA, ordererCA)
+}
+
+func TestEndpointConfigHotSwapClientCert(t *testing.T) {
+	c, cache := newTestEndpointConfig(t)
+	old := c.TLSClientCerts()
+	if len(old) != 1 || len(old[0].Certificate) == 0 {
+		t.Fatalf("%d TLS client certificates loaded", len(old))
+	}
+
+	// the certificate is kept until its key arrives
+	cert, key := newTestCert(t, "User1@org1.example.com")
+	setCrypto(t, cache, testClientTLSCert, cert)
+	if got := c.TLSClientCerts(); !bytes.Equal(got[0].Certificate[0], old[0].Certificate[0]) {
+		t.Error("TLS client certificate swapped without its key")
+	}
+	setCrypto(t, cache, testClientTLSKey, key)
+
+	block, _ := pem.Decode(cert)
+	got := c.TLSClientCerts()
+	if len(got) != 1 || !bytes.Equal(got[0].Certificate[0], block.Bytes) {
+		t.Fatal("TLS client certificate is not swapped")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cert, key = newTestCert(t, "User1@org1.example.com")
+	setCrypto(t, cache, testClientTLSCert, cert)
+	setCrypto(t, cache, testClientTLSKey, key)
+	if again := c.TLSClientCerts(); !bytes.Equal(again[0].Certificate[0], block.Bytes) {
+		t.Error("TLS client certificate swapped after Close")
+	}
+}
+
+func TestEndpointConfigConcurrentReload(t *testing.T) {
+	c, cache := newTestEndpointConfig(t)
+
+	certs := make([][]byte, 10)
+	for i := range certs {
+		certs[i], _ = newTestCert(t, testPeerTLSCA)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, cert := range certs {
+			_ = cache.SetCrypto(testPeerTLSCA, cert)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if peer, ok := c.PeerConfig(testPeer); !ok || peer.TLSCACert == nil {
+			t.Fatal("peer config without TLS CA certificate during reload")
+		}
+		_ = c.ChannelPeers("mychannel")
+		_ = c.TLSClientCerts()
+		_ = c.ChannelConfig("mychannel")
+	}
+}
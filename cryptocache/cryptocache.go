@@ -6,8 +6,56 @@ SPDX-License-Identifier: [Default license](LICENSE)
 
 package cryptocache
 
+import "context"
+
 // CryptoCache is used for storing/retrieving crypto (certs and keys).
 type CryptoCache interface {
 	GetCrypto(key string) ([]byte, error)
 	SetCrypto(key string, value []byte) error
 }
+
+// Event describes a change of crypto in the cache.
+type Event struct {
+	Key   string
+	Value []byte
+}
+
+// ContextGetter is implemented by caches loading crypto from a backend on access.
+type ContextGetter interface {
+	// GetCryptoContext is GetCrypto giving up the load when ctx is done
+	GetCryptoContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetCryptoContext retrieves crypto from cache with loading bounded by ctx if cache supports it.
+func GetCryptoContext(ctx context.Context, cache CryptoCache, key string) ([]byte, error) {
+	if getter, ok := cache.(ContextGetter); ok {
+		return getter.GetCryptoContext(ctx, key)
+	}
+	return cache.GetCrypto(key)
+}
+
+// Notifier is implemented by caches reporting changes of crypto.
+type Notifier interface {
+	// Subscribe calls handler every time crypto is added or changed, the returned function cancels the subscription.
+	// Handlers are called synchronously and must not block.
+	Subscribe(handler func(Event)) (unsubscribe func())
+}
+
+// Lister is implemented by caches able to enumerate stored crypto.
+type Lister interface {
+	Keys() []string
+}
+
+// Clearer is implemented by caches able to wipe stored crypto.
+type Clearer interface {
+	// Clear overwrites stored crypto with zeros and removes it, subscribers are not notified.
+	// Only the copies held by the cache are overwritten, never slices passed to SetCrypto or returned by GetCrypto.
+	Clear()
+}
+
+// Deleter is implemented by caches able to remove single crypto.
+type Deleter interface {
+	// DeleteCrypto overwrites crypto stored under key with zeros and removes it, subscribers are not notified.
+	// Only the copy held by the cache is overwritten, never slices passed to SetCrypto or returned by GetCrypto.
+	DeleteCrypto(key string)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package cryptocache
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Loader loads crypto for key from the backend, giving up when ctx is done.
+type Loader func(ctx context.Context, key string) ([]byte, error)
+
+// LazyCache is a CryptoCache that loads missing crypto from the backend on first access.
+type LazyCache struct {
+	cache   CryptoCache
+	load    Loader
+	keys    func() []string
+	loadsMu sync.Mutex
+	loads   map[string]*loadCall
+}
+
+// loadCall is a load of crypto in flight, callers missing the same key wait for done
+type loadCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// NewLazyCache creates LazyCache on top of cache, misses are loaded with load and saved to cache.
+// keys lists names of crypto in the backend, loaded or not.
+func NewLazyCache(cache CryptoCache, load Loader, keys func() []string) *LazyCache {
+	return &LazyCache{cache: cache, load: load, keys: keys, loads: make(map[string]*loadCall)}
+}
+
+// GetCrypto retrieves crypto from the cache, loading it from the backend if it is missing.
+func (l *LazyCache) GetCrypto(key string) ([]byte, error) {
+	return l.GetCryptoContext(context.Background(), key)
+}
+
+// GetCryptoContext is GetCrypto with the load bounded by ctx.
+// Concurrent misses of the same key share one load, misses of different keys load in parallel.
+func (l *LazyCache) GetCryptoContext(ctx context.Context, key string) ([]byte, error) {
+	for {
+		if value, err := l.cache.GetCrypto(key); err == nil {
+			return value, nil
+		}
+
+		l.loadsMu.Lock()
+		if call, ok := l.loads[key]; ok {
+			l.loadsMu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// a load given up by its caller is started again by this one
+			if isContextError(call.err) && ctx.Err() == nil {
+				continue
+			}
+			// the loaded value is returned to the first caller, others get copies they may wipe independently
+			return clone(call.value), call.err
+		}
+		call := &loadCall{done: make(chan struct{})}
+		l.loads[key] = call
+		l.loadsMu.Unlock()
+
+		call.value, call.err = l.loadCrypto(ctx, key)
+
+		l.loadsMu.Lock()
+		delete(l.loads, key)
+		l.loadsMu.Unlock()
+		close(call.done)
+
+		return call.value, call.err
+	}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// loadCrypto loads crypto for key from the backend and saves it to the cache
+func (l *LazyCache) loadCrypto(ctx context.Context, key string) ([]byte, error) {
+	// crypto may have been loaded by a call finished after the first lookup
+	if value, err := l.cache.GetCrypto(key); err == nil {
+		return value, nil
+	}
+
+	value, err := l.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.cache.SetCrypto(key, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// SetCrypto saves crypto to the cache.
+func (l *LazyCache) SetCrypto(key string, value []byte) error {
+	return l.cache.SetCrypto(key, value)
+}
+
+// Clear wipes crypto loaded so far.
+func (l *LazyCache) Clear() {
+	if clearer, ok := l.cache.(Clearer); ok {
+		clearer.Clear()
+	}
+}
+
+// DeleteCrypto removes loaded crypto stored under key, it is loaded again on next access if the backend has it.
+func (l *LazyCache) DeleteCrypto(key string) {
+	if deleter, ok := l.cache.(Deleter); ok {
+		deleter.DeleteCrypto(key)
+	}
+}
+
+// Keys returns keys of the crypto in the backend and of the crypto saved to the cache, loaded or not.
+func (l *LazyCache) Keys() []string {
+	loaded := l.LoadedKeys()
+	if l.keys == nil {
+		return loaded
+	}
+
+	keys := l.keys()
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for _, key := range loaded {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// LoadedKeys returns keys of the crypto loaded or saved so far.
+func (l *LazyCache) LoadedKeys() []string {
+	if lister, ok := l.cache.(Lister); ok {
+		return lister.Keys()
+	}
+	return nil
+}
+
+// Subscribe calls handler every time crypto is loaded or changed.
+func (l *LazyCache) Subscribe(handler func(Event)) (unsubscribe func()) {
+	if notifier, ok := l.cache.(Notifier); ok {
+		return notifier.Subscribe(handler)
+	}
+	return func() {}
+}
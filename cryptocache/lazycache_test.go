@@ -0,0 +1,125 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package cryptocache
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLazyCacheSharedLoad(t *testing.T) {
+	const callers = 8
+
+	var loads int32
+	release := make(chan struct{})
+	cache := NewLazyCache(NewMemCache(), func(_ context.Context, key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return []byte("value of " + key), nil
+	}, nil)
+
+	var wg sync.WaitGroup
+	values := make([][]byte, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, err := cache.GetCrypto("key")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			values[i] = value
+		}(i)
+	}
+	// let callers queue up behind the first load
+	for atomic.LoadInt32(&loads) == 0 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("loaded %d times, want 1", n)
+	}
+	// callers wipe their slices independently
+	zero(values[0])
+	for i, value := range values[1:] {
+		if string(value) != "value of key" {
+			t.Errorf("value of caller %d = %q", i+1, value)
+		}
+	}
+	cache.DeleteCrypto("key")
+	if value, _ := cache.GetCrypto("key"); string(value) != "value of key" {
+		t.Errorf("value loaded again = %q", value)
+	}
+}
+
+func TestLazyCacheContext(t *testing.T) {
+	var loads int32
+	cache := NewLazyCache(NewMemCache(), func(ctx context.Context, key string) ([]byte, error) {
+		if atomic.AddInt32(&loads, 1) == 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []byte("value of " + key), nil
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cache.GetCryptoContext(ctx, "key")
+		errs <- err
+	}()
+	for atomic.LoadInt32(&loads) == 0 {
+		runtime.Gosched()
+	}
+
+	// a caller without deadline is not failed by the load given up by another caller
+	values := make(chan []byte, 1)
+	go func() {
+		value, _ := cache.GetCrypto("key")
+		values <- value
+	}()
+	cancel()
+
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	if value := <-values; string(value) != "value of key" {
+		t.Errorf("value = %q", value)
+	}
+}
+
+func TestLazyCacheKeys(t *testing.T) {
+	cache := NewLazyCache(NewMemCache(), func(_ context.Context, key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}, func() []string { return []string{"a", "b"} })
+
+	if _, err := cache.GetCrypto("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetCrypto("c", []byte("stored")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := cache.Keys()
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Errorf("Keys = %v, want crypto of the backend and stored crypto", keys)
+	}
+	loaded := cache.LoadedKeys()
+	sort.Strings(loaded)
+	if !reflect.DeepEqual(loaded, []string{"a", "c"}) {
+		t.Errorf("LoadedKeys = %v", loaded)
+	}
+}
@@ -7,19 +7,25 @@ SPDX-License-Identifier: [Default license](LICENSE)
 package cryptocache
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 )
 
 // MemCache is an in-memory implementation of the CryptoCache interface.
+// It holds copies of crypto, slices passed to SetCrypto and returned by GetCrypto are owned by the caller.
 type MemCache struct {
 	crypto map[string][]byte // crypto stores mapping <keyname string : cryptovalue interface{}>
 	sync.RWMutex
+
+	handlers  map[int]func(Event)
+	nextID    int
+	handlerMu sync.RWMutex
 }
 
 // NewMemCache creates MemCache instance and returns pointer to it.
 func NewMemCache() *MemCache {
-	return &MemCache{crypto: make(map[string][]byte)}
+	return &MemCache{crypto: make(map[string][]byte), handlers: make(map[int]func(Event))}
 }
 
 // GetCrypto retrieves crypto from in-memory storage.
@@ -30,13 +36,100 @@ func (m *MemCache) GetCrypto(key string) ([]byte, error) {
 	if !ok {
 		return nil, fmt.Errorf("no crypto for key %s", key)
 	}
-	return value, nil
+	return clone(value), nil
 }
 
-// SetCrypto saves crypto to the in-memory storage.
+// SetCrypto saves crypto to the in-memory storage and notifies subscribers if it has changed.
 func (m *MemCache) SetCrypto(key string, value []byte) error {
 	m.Lock()
-	m.crypto[key] = value
+	old, ok := m.crypto[key]
+	changed := !ok || !bytes.Equal(old, value)
+	if changed {
+		m.crypto[key] = clone(value)
+		if ok {
+			zero(old)
+		}
+	}
 	m.Unlock()
+
+	if changed {
+		m.notify(Event{Key: key, Value: clone(value)})
+	}
 	return nil
 }
+
+// Clear overwrites all crypto in the in-memory storage with zeros and removes it.
+// Copies returned by GetCrypto and passed to subscribers are not affected.
+func (m *MemCache) Clear() {
+	m.Lock()
+	for key, value := range m.crypto {
+		zero(value)
+		delete(m.crypto, key)
+	}
+	m.Unlock()
+}
+
+// DeleteCrypto overwrites crypto stored under key with zeros and removes it from the in-memory storage.
+// Copies returned by GetCrypto and passed to subscribers are not affected.
+func (m *MemCache) DeleteCrypto(key string) {
+	m.Lock()
+	if value, ok := m.crypto[key]; ok {
+		zero(value)
+		delete(m.crypto, key)
+	}
+	m.Unlock()
+}
+
+// Keys returns keys of all crypto in the in-memory storage.
+func (m *MemCache) Keys() []string {
+	m.RLock()
+	keys := make([]string, 0, len(m.crypto))
+	for key := range m.crypto {
+		keys = append(keys, key)
+	}
+	m.RUnlock()
+	return keys
+}
+
+// Subscribe calls handler every time crypto is added or changed.
+func (m *MemCache) Subscribe(handler func(Event)) (unsubscribe func()) {
+	m.handlerMu.Lock()
+	id := m.nextID
+	m.nextID++
+	m.handlers[id] = handler
+	m.handlerMu.Unlock()
+
+	return func() {
+		m.handlerMu.Lock()
+		delete(m.handlers, id)
+		m.handlerMu.Unlock()
+	}
+}
+
+func (m *MemCache) notify(event Event) {
+	m.handlerMu.RLock()
+	handlers := make([]func(Event), 0, len(m.handlers))
+	for _, handler := range m.handlers {
+		handlers = append(handlers, handler)
+	}
+	m.handlerMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// clone returns a copy of value not shared with the caller
+func clone(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(value)), value...)
+}
+
+// zero overwrites value with zeros
+func zero(value []byte) {
+	for i := range value {
+		value[i] = 0
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package cryptocache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMemCacheCopies(t *testing.T) {
+	cache := NewMemCache()
+	var events [][]byte
+	defer cache.Subscribe(func(event Event) { events = append(events, event.Value) })()
+
+	value := []byte("private key")
+	if err := cache.SetCrypto("key_sk", value); err != nil {
+		t.Fatal(err)
+	}
+	// the caller reuses its buffer
+	value[0] = 'P'
+	got, err := cache.GetCrypto("key_sk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "private key" {
+		t.Fatalf("GetCrypto = %q after the caller changed its slice", got)
+	}
+	got[0] = 'P'
+	if again, _ := cache.GetCrypto("key_sk"); string(again) != "private key" {
+		t.Fatalf("GetCrypto = %q after the caller changed the returned slice", again)
+	}
+
+	returned, _ := cache.GetCrypto("key_sk")
+	cache.DeleteCrypto("key_sk")
+	if _, err = cache.GetCrypto("key_sk"); err == nil {
+		t.Error("crypto is not deleted")
+	}
+	for name, b := range map[string][]byte{"passed to SetCrypto": value, "returned by GetCrypto": returned, "passed to subscribers": events[0]} {
+		if bytes.Equal(b, make([]byte, len(b))) {
+			t.Errorf("slice %s is wiped by DeleteCrypto", name)
+		}
+	}
+}
+
+func TestMemCacheClear(t *testing.T) {
+	cache := NewMemCache()
+	value := []byte("certificate")
+	if err := cache.SetCrypto("cert.pem", value); err != nil {
+		t.Fatal(err)
+	}
+	returned, _ := cache.GetCrypto("cert.pem")
+
+	cache.Clear()
+	if len(cache.Keys()) != 0 {
+		t.Errorf("keys %v left after Clear", cache.Keys())
+	}
+	if string(value) != "certificate" || string(returned) != "certificate" {
+		t.Errorf("slices of the caller are wiped by Clear: %q, %q", value, returned)
+	}
+}
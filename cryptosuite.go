@@ -7,6 +7,7 @@ SPDX-License-Identifier: [Default license](LICENSE)
 package cartridge
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -17,21 +18,40 @@ import (
 	"fmt"
 	"hash"
 	"sync"
+	"time"
 
 	"github.com/atomyze-foundation/cartridge/manager"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
 	"github.com/hyperledger/fabric/bccsp/utils"
 )
 
+// DefaultSignTimeout bounds signing through the Manager, so calls of fabric-sdk fail fast when the backend is unreachable
+const DefaultSignTimeout = 30 * time.Second
+
+// CryptoSuiteOption configures CryptoSuite
+type CryptoSuiteOption func(c *CryptoSuite)
+
+// WithSignTimeout sets the timeout of signing through the Manager, zero or negative timeout disables it
+func WithSignTimeout(timeout time.Duration) CryptoSuiteOption {
+	return func(c *CryptoSuite) {
+		c.signTimeout = timeout
+	}
+}
+
 // NewCartridgeCryptoSuite returns cryptosuite adaptor for Signer
-func NewCartridgeCryptoSuite(manager manager.Manager) core.CryptoSuite {
-	return &CryptoSuite{manager: manager, crypto: &Crypto{crypto: make(map[string]core.Key)}}
+func NewCartridgeCryptoSuite(manager manager.Manager, opts ...CryptoSuiteOption) core.CryptoSuite {
+	suite := &CryptoSuite{manager: manager, crypto: &Crypto{crypto: make(map[string]core.Key)}, signTimeout: DefaultSignTimeout}
+	for _, opt := range opts {
+		opt(suite)
+	}
+	return suite
 }
 
 // CryptoSuite provides a wrapper of Signer
 type CryptoSuite struct {
-	manager manager.Manager
-	crypto  *Crypto
+	manager     manager.Manager
+	crypto      *Crypto
+	signTimeout time.Duration
 }
 
 // Crypto stores mapping <keyname string : cryptovalue core.Key>
@@ -65,10 +85,7 @@ func (c *CryptoSuite) KeyGen(_ core.KeyGenOpts) (k core.Key, err error) {
 	if err != nil {
 		return nil, err
 	}
-	return &manager.CartridgeKey{
-		PrivKey: privateKey,
-		PubKey:  &privateKey.PublicKey,
-	}, nil
+	return manager.NewCartridgeKey(privateKey)
 }
 
 // KeyImport imports new key to CryptoSuite key store
@@ -128,16 +145,13 @@ func (c *CryptoSuite) GetHash(_ core.HashOpts) (h hash.Hash, err error) {
 func (c *CryptoSuite) Sign(k core.Key, digest []byte, _ core.SignerOpts) (signature []byte, err error) {
 	switch key := k.(type) {
 	case *manager.CartridgeKey:
-		sig, err := c.manager.Sign(digest, key.PrivKey, key.PubKey)
-		if err != nil {
-			return nil, err
-		}
-		sigLowS, err := utils.SignatureToLowS(key.PubKey, sig)
-		if err != nil {
-			return nil, err
+		ctx := context.Background()
+		if c.signTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.signTimeout)
+			defer cancel()
 		}
-		signature = sigLowS
-		return signature, err
+		return c.manager.SignContext(ctx, digest, key)
 	default:
 		return nil, errors.New("invalid key type")
 	}
@@ -0,0 +1,428 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package enrollment
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/manager"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	enrollPath   = "/api/v1/enroll"
+	reenrollPath = "/api/v1/reenroll"
+
+	defaultTimeout = 30 * time.Second
+)
+
+// AttributeRequest requests a Fabric CA attribute to be put into the enrollment certificate
+type AttributeRequest struct {
+	Name     string `json:"name"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// Request is an enrollment request
+type Request struct {
+	EnrollmentID string
+	Secret       string
+	// CertName is the name the certificate is stored under, <EnrollmentID>-cert.pem by default
+	CertName string
+	// Profile is the signing profile of the CA, the default profile if empty
+	Profile  string
+	AttrReqs []AttributeRequest
+}
+
+// Option configures Enroller
+type Option func(e *Enroller) error
+
+// WithHTTPClient sets the client used to reach the CA instead of the client built from the CA TLS config
+func WithHTTPClient(client *http.Client) Option {
+	return func(e *Enroller) error {
+		e.client = client
+		return nil
+	}
+}
+
+// Enroller enrolls identities with Fabric CA and writes their certificates and private keys
+// to the backend of the manager, so that they are picked up like any other crypto of the backend.
+type Enroller struct {
+	manager manager.Manager
+	caName  string
+	url     string
+	client  *http.Client
+
+	registrar msp.EnrollCredentials
+}
+
+// NewEnroller returns Enroller for CA caID of identityConfig, the CA URL, name, TLS certificates and registrar
+// are taken from the certificateAuthorities section of the SDK config.
+// Crypto is written to the backend if m implements manager.Storer, otherwise only to the cache of m.
+func NewEnroller(identityConfig msp.IdentityConfig, caID string, m manager.Manager, opts ...Option) (*Enroller, error) {
+	caConfig, ok := identityConfig.CAConfig(caID)
+	if !ok {
+		return nil, fmt.Errorf("CA %s is not configured", caID)
+	}
+
+	enroller := &Enroller{
+		manager:   m,
+		caName:    caConfig.CAName,
+		url:       strings.TrimSuffix(caConfig.URL, "/"),
+		registrar: caConfig.Registrar,
+	}
+	for _, opt := range opts {
+		if err := opt(enroller); err != nil {
+			return nil, err
+		}
+	}
+
+	if enroller.client == nil {
+		client, err := newHTTPClient(caConfig)
+		if err != nil {
+			return nil, err
+		}
+		enroller.client = client
+	}
+
+	if _, ok = m.(manager.Storer); !ok {
+		logrus.Warnf("manager %T cannot store crypto, enrolled crypto is kept in the cache only and may be lost on refresh", m)
+	}
+
+	return enroller, nil
+}
+
+// newHTTPClient returns a client trusting TLS CA certificates of caConfig and using its TLS client certificate
+func newHTTPClient(caConfig *msp.CAConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(caConfig.TLSCAServerCerts) != 0 {
+		pool := x509.NewCertPool()
+		for _, cert := range caConfig.TLSCAServerCerts {
+			if !pool.AppendCertsFromPEM(cert) {
+				return nil, fmt.Errorf("failed to parse TLS CA certificate of CA %s", caConfig.ID)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(caConfig.TLSCAClientCert) != 0 && len(caConfig.TLSCAClientKey) != 0 {
+		cert, err := tls.X509KeyPair(caConfig.TLSCAClientCert, caConfig.TLSCAClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate of CA %s: %w", caConfig.ID, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout:   defaultTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// Enroll enrolls req.EnrollmentID with a new private key, stores the key under <ski>_sk and then
+// the certificate under req.CertName. The name of the stored certificate is returned.
+func (e *Enroller) Enroll(ctx context.Context, req Request) (string, error) {
+	certName := req.CertName
+	if certName == "" {
+		certName = req.EnrollmentID + "-cert.pem"
+	}
+
+	key, csr, err := newCSR(req.EnrollmentID)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(enrollmentRequest{
+		CSR:      string(csr),
+		CAName:   e.caName,
+		Profile:  req.Profile,
+		AttrReqs: req.AttrReqs,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	cert, err := e.post(ctx, enrollPath, body, func(r *http.Request) error {
+		r.SetBasicAuth(req.EnrollmentID, req.Secret)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to enroll %s: %w", req.EnrollmentID, err)
+	}
+
+	if err = e.store(ctx, certName, key, cert); err != nil {
+		return "", err
+	}
+	logrus.Infof("enrolled %s to %s", req.EnrollmentID, certName)
+
+	return certName, nil
+}
+
+// EnrollRegistrar enrolls the registrar of the CA config, the certificate is stored under <enrollID>-cert.pem
+func (e *Enroller) EnrollRegistrar(ctx context.Context) (string, error) {
+	if e.registrar.EnrollID == "" {
+		return "", errors.New("registrar is not configured")
+	}
+	return e.Enroll(ctx, Request{EnrollmentID: e.registrar.EnrollID, Secret: e.registrar.EnrollSecret})
+}
+
+// Reenroll re-enrolls the identity with certificate certName with a new private key, the request is
+// authenticated by the current certificate and key. The new key and then the new certificate are stored,
+// signing identities of the manager watching the certificate switch to them once both are in the cache.
+// The new key is generated locally, so identities signing with keys held in Vault transit, KMS or an HSM
+// cannot be re-enrolled this way.
+func (e *Enroller) Reenroll(ctx context.Context, certName string) error {
+	// the registry resolves enrollment IDs as well, only certificate names are accepted to store under them
+	if _, err := e.certificate(certName); err != nil {
+		return err
+	}
+
+	registry := manager.NewIdentityRegistry(e.manager)
+	defer registry.Close()
+
+	identity, err := registry.Identity(certName)
+	if err != nil {
+		return err
+	}
+	current := identity.EnrollmentCertificate()
+	crt, err := manager.DecodeCertificate(current)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate %s: %w", certName, err)
+	}
+
+	key, csr, err := newCSR(crt.Subject.CommonName)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(enrollmentRequest{CSR: string(csr), CAName: e.caName})
+	if err != nil {
+		return err
+	}
+
+	cert, err := e.post(ctx, reenrollPath, body, func(r *http.Request) error {
+		token, err := authToken(identity, current, r.Method, r.URL.RequestURI(), body)
+		if err != nil {
+			return err
+		}
+		r.Header.Set("Authorization", token)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reenroll %s: %w", certName, err)
+	}
+
+	if err = e.store(ctx, certName, key, cert); err != nil {
+		return err
+	}
+	logrus.Infof("reenrolled %s", certName)
+
+	return nil
+}
+
+// AutoReenroll checks certificate certName every interval and re-enrolls it when it expires within before,
+// until ctx is done. Failures are logged and retried on the next check.
+// It returns an error at once if interval is not positive.
+func (e *Enroller) AutoReenroll(ctx context.Context, certName string, before, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid reenrollment check interval %s", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.reenrollIfExpiring(ctx, certName, before); err != nil {
+			logrus.Errorf("failed to reenroll %s: %s", certName, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Enroller) reenrollIfExpiring(ctx context.Context, certName string, before time.Duration) error {
+	crt, err := e.certificate(certName)
+	if err != nil {
+		return err
+	}
+	if time.Until(crt.NotAfter) > before {
+		return nil
+	}
+
+	logrus.Infof("certificate %s expires at %s, reenrolling", certName, crt.NotAfter.Format(time.RFC3339))
+	return e.Reenroll(ctx, certName)
+}
+
+// certificate returns certificate certName of the cache
+func (e *Enroller) certificate(certName string) (*x509.Certificate, error) {
+	cert, err := e.manager.Cache().GetCrypto(certName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find certificate %s: %w", certName, err)
+	}
+	crt, err := manager.DecodeCertificate(cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %w", certName, err)
+	}
+	return crt, nil
+}
+
+// store writes PEM private key of cert under <ski>_sk first, so that the certificate is never
+// in the backend without its key, then cert under certName
+func (e *Enroller) store(ctx context.Context, certName string, key *ecdsa.PrivateKey, cert []byte) error {
+	crt, err := manager.DecodeCertificate(cert)
+	if err != nil {
+		return fmt.Errorf("CA returned invalid certificate: %w", err)
+	}
+	if !key.PublicKey.Equal(crt.PublicKey) {
+		return errors.New("CA returned certificate for another key")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if err = e.put(ctx, keyName(key), keyPEM); err != nil {
+		return fmt.Errorf("failed to store private key of %s: %w", certName, err)
+	}
+	if err = e.put(ctx, certName, cert); err != nil {
+		return fmt.Errorf("failed to store certificate %s: %w", certName, err)
+	}
+
+	return nil
+}
+
+// put writes value to the backend of the manager if it can store crypto, to its cache otherwise
+func (e *Enroller) put(ctx context.Context, name string, value []byte) error {
+	if storer, ok := e.manager.(manager.Storer); ok {
+		return storer.Store(ctx, name, value)
+	}
+	return e.manager.Cache().SetCrypto(name, value)
+}
+
+// post sends body to the CA endpoint path with authorization set by auth and returns the issued PEM certificate
+func (e *Enroller) post(ctx context.Context, path string, body []byte, auth func(r *http.Request) error) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err = auth(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result caResponse
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("unexpected response from CA with status %s: %w", resp.Status, err)
+	}
+	if !result.Success {
+		messages := make([]string, 0, len(result.Errors))
+		for _, caErr := range result.Errors {
+			messages = append(messages, fmt.Sprintf("%d: %s", caErr.Code, caErr.Message))
+		}
+		return nil, fmt.Errorf("CA responded with status %s: %s", resp.Status, strings.Join(messages, "; "))
+	}
+
+	cert, err := base64.StdEncoding.DecodeString(result.Result.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificate returned by CA: %w", err)
+	}
+	return cert, nil
+}
+
+// enrollmentRequest is the body of Fabric CA enroll and reenroll requests
+type enrollmentRequest struct {
+	CSR      string             `json:"certificate_request"`
+	CAName   string             `json:"caname,omitempty"`
+	Profile  string             `json:"profile,omitempty"`
+	AttrReqs []AttributeRequest `json:"attr_reqs,omitempty"`
+}
+
+// caResponse is the body of Fabric CA responses
+type caResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		Cert string `json:"Cert"`
+	} `json:"result"`
+	Errors []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// newCSR generates a P-256 private key and a PEM certificate request for it with common name cn
+func newCSR(cn string) (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: cn},
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// authToken returns the Fabric CA token authenticating a request by the identity with PEM certificate cert:
+// base64 certificate and base64 signature of method.b64(uri).b64(body).b64(cert) separated by a dot
+func authToken(identity manager.CartridgeSigningIdentity, cert []byte, method, uri string, body []byte) (string, error) {
+	b64cert := base64.StdEncoding.EncodeToString(cert)
+	payload := method + "." +
+		base64.StdEncoding.EncodeToString([]byte(uri)) + "." +
+		base64.StdEncoding.EncodeToString(body) + "." +
+		b64cert
+
+	sig, err := identity.Sign([]byte(payload))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign authorization token: %w", err)
+	}
+
+	return b64cert + "." + base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// keyName returns the name private key is stored under, <ski>_sk like cryptogen names keys
+func keyName(key *ecdsa.PrivateKey) string {
+	ski := (&manager.CartridgeKey{PubKey: &key.PublicKey}).SKI()
+	return hex.EncodeToString(ski) + "_sk"
+}
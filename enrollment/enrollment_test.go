@@ -0,0 +1,374 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package enrollment
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/manager"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+)
+
+const (
+	testCAName   = "ca-org1"
+	testCertName = "User1@org1.example.com-cert.pem"
+)
+
+// testCA is a stub of Fabric CA enroll and reenroll endpoints issuing certificates for CSRs
+type testCA struct {
+	t   *testing.T
+	crt *x509.Certificate
+	key *ecdsa.PrivateKey
+	pem []byte
+
+	// authorize checks the request, a non-empty result is returned as a Fabric CA error
+	authorize func(r *http.Request, body []byte) string
+
+	mu       sync.Mutex
+	path     string
+	request  enrollmentRequest
+	issuedCN string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca.org1.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{t: t, crt: crt, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM certificate with common name cn for pub
+func (ca *testCA) issue(cn string, pub *ecdsa.PublicKey) []byte {
+	ca.t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.crt, pub, ca.key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func (ca *testCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req enrollmentRequest
+	if err = json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ca.mu.Lock()
+	ca.path, ca.request = r.URL.Path, req
+	ca.mu.Unlock()
+
+	if ca.authorize != nil {
+		if message := ca.authorize(r, body); message != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = fmt.Fprintf(w, `{"success":false,"result":null,"errors":[{"code":20,"message":%q}],"messages":[]}`, message)
+			return
+		}
+	}
+
+	block, _ := pem.Decode([]byte(req.CSR))
+	if block == nil {
+		http.Error(w, "invalid certificate request", http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pub, ok := csr.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		http.Error(w, "unexpected key type", http.StatusBadRequest)
+		return
+	}
+
+	ca.mu.Lock()
+	ca.issuedCN = csr.Subject.CommonName
+	ca.mu.Unlock()
+
+	cert := ca.issue(csr.Subject.CommonName, pub)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"result":   map[string]string{"Cert": base64.StdEncoding.EncodeToString(cert)},
+		"errors":   []interface{}{},
+		"messages": []interface{}{},
+	})
+}
+
+// testIdentityConfig serves the config of one CA
+type testIdentityConfig struct {
+	msp.IdentityConfig
+	caConfig *msp.CAConfig
+}
+
+func (c *testIdentityConfig) CAConfig(caID string) (*msp.CAConfig, bool) {
+	if caID != c.caConfig.ID {
+		return nil, false
+	}
+	return c.caConfig, true
+}
+
+// storeRecorder records names of crypto stored through the manager
+type storeRecorder struct {
+	*manager.FileManager
+
+	mu     sync.Mutex
+	stored []string
+}
+
+func (r *storeRecorder) Store(ctx context.Context, name string, value []byte) error {
+	r.mu.Lock()
+	r.stored = append(r.stored, name)
+	r.mu.Unlock()
+	return r.FileManager.Store(ctx, name, value)
+}
+
+// newTestEnroller returns Enroller for ca and the manager it stores to, the manager signs as User1
+// enrolled with ca before
+func newTestEnroller(t *testing.T, ca *testCA) (*Enroller, *storeRecorder) {
+	t.Helper()
+
+	server := httptest.NewServer(ca)
+	t.Cleanup(server.Close)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"cacerts/ca.org1.example.com-cert.pem": ca.pem,
+		"signcerts/" + testCertName:            ca.issue("User1@org1.example.com", &key.PublicKey),
+		"keystore/priv_sk":                     pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	} {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(filePath), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filePath, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fm, err := manager.NewFileManager("Org1MSP", testCertName, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fm.Close() })
+	recorder := &storeRecorder{FileManager: fm}
+
+	identityConfig := &testIdentityConfig{caConfig: &msp.CAConfig{ID: "ca.org1.example.com", URL: server.URL + "/", CAName: testCAName}}
+	enroller, err := NewEnroller(identityConfig, "ca.org1.example.com", recorder, WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enroller, recorder
+}
+
+// checkStored checks that the key of the certificate certName was stored before it and both are cached
+func checkStored(t *testing.T, recorder *storeRecorder, certName string) *x509.Certificate {
+	t.Helper()
+
+	cert, err := recorder.Cache().GetCrypto(certName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := manager.DecodeCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ski := (&manager.CartridgeKey{PubKey: crt.PublicKey.(*ecdsa.PublicKey)}).SKI()
+	keyName := fmt.Sprintf("%x_sk", ski)
+
+	if got := strings.Join(recorder.stored, ","); got != keyName+","+certName {
+		t.Errorf("stored %s, want %s,%s", got, keyName, certName)
+	}
+	if _, err = recorder.Cache().GetCrypto(keyName); err != nil {
+		t.Errorf("private key of %s is not cached: %s", certName, err)
+	}
+	return crt
+}
+
+func TestEnroll(t *testing.T) {
+	ca := newTestCA(t)
+	ca.authorize = func(r *http.Request, _ []byte) string {
+		if user, secret, ok := r.BasicAuth(); !ok || user != "User2@org1.example.com" || secret != "secret" {
+			return "Authentication failure"
+		}
+		return ""
+	}
+	enroller, recorder := newTestEnroller(t, ca)
+
+	certName, err := enroller.Enroll(context.Background(), Request{
+		EnrollmentID: "User2@org1.example.com",
+		Secret:       "secret",
+		Profile:      "tls",
+		AttrReqs:     []AttributeRequest{{Name: "hf.Type"}, {Name: "role", Optional: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certName != "User2@org1.example.com-cert.pem" {
+		t.Errorf("certificate name = %s", certName)
+	}
+
+	if ca.path != enrollPath {
+		t.Errorf("path = %s, want %s", ca.path, enrollPath)
+	}
+	if ca.request.CAName != testCAName || ca.request.Profile != "tls" {
+		t.Errorf("caname = %q, profile = %q", ca.request.CAName, ca.request.Profile)
+	}
+	if len(ca.request.AttrReqs) != 2 || ca.request.AttrReqs[0] != (AttributeRequest{Name: "hf.Type"}) ||
+		ca.request.AttrReqs[1] != (AttributeRequest{Name: "role", Optional: true}) {
+		t.Errorf("attr_reqs = %v", ca.request.AttrReqs)
+	}
+	if ca.issuedCN != "User2@org1.example.com" {
+		t.Errorf("CSR common name = %s", ca.issuedCN)
+	}
+
+	checkStored(t, recorder, certName)
+}
+
+func TestReenroll(t *testing.T) {
+	ca := newTestCA(t)
+	enroller, recorder := newTestEnroller(t, ca)
+	current := recorder.SigningIdentity().EnrollmentCertificate()
+	ca.authorize = func(r *http.Request, body []byte) string {
+		// <b64 cert>.<b64 signature of method.b64(uri).b64(body).b64(cert)>
+		parts := strings.Split(r.Header.Get("Authorization"), ".")
+		if len(parts) != 2 {
+			return "Invalid token in authorization header"
+		}
+		cert, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil || !bytes.Equal(cert, current) {
+			return "Invalid certificate in authorization header"
+		}
+		sig, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "Invalid signature in authorization header"
+		}
+		crt, err := manager.DecodeCertificate(cert)
+		if err != nil {
+			return err.Error()
+		}
+		payload := r.Method + "." +
+			base64.StdEncoding.EncodeToString([]byte(r.URL.RequestURI())) + "." +
+			base64.StdEncoding.EncodeToString(body) + "." + parts[0]
+		digest := sha256.Sum256([]byte(payload))
+		if !ecdsa.VerifyASN1(crt.PublicKey.(*ecdsa.PublicKey), digest[:], sig) {
+			return "Failed to verify the token"
+		}
+		return ""
+	}
+
+	if err := enroller.Reenroll(context.Background(), testCertName); err != nil {
+		t.Fatal(err)
+	}
+
+	if ca.path != reenrollPath {
+		t.Errorf("path = %s, want %s", ca.path, reenrollPath)
+	}
+	if ca.request.CAName != testCAName {
+		t.Errorf("caname = %q", ca.request.CAName)
+	}
+	if ca.issuedCN != "User1@org1.example.com" {
+		t.Errorf("CSR common name = %s", ca.issuedCN)
+	}
+
+	crt := checkStored(t, recorder, testCertName)
+	// the signing identity switches to the new certificate and key
+	reloaded, err := manager.DecodeCertificate(recorder.SigningIdentity().EnrollmentCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Equal(crt) {
+		t.Error("signing identity is not switched to the new certificate")
+	}
+	msg := []byte("message")
+	sig, err := recorder.SigningIdentity().Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(msg)
+	if !ecdsa.VerifyASN1(crt.PublicKey.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Error("signing identity does not sign with the new key")
+	}
+}
+
+func TestEnrollCAError(t *testing.T) {
+	ca := newTestCA(t)
+	ca.authorize = func(*http.Request, []byte) string { return "Authentication failure" }
+	enroller, recorder := newTestEnroller(t, ca)
+
+	_, err := enroller.Enroll(context.Background(), Request{EnrollmentID: "User2@org1.example.com", Secret: "wrong"})
+	if err == nil || !strings.Contains(err.Error(), "20: Authentication failure") || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("err = %v, want CA error 20 with status 401", err)
+	}
+	if len(recorder.stored) != 0 {
+		t.Errorf("stored %v after failed enrollment", recorder.stored)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package expiry
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultThreshold is the time before expiry certificates are reported as expiring
+const DefaultThreshold = 30 * 24 * time.Hour
+
+// Certificate is an inspected certificate
+type Certificate struct {
+	Subject      string
+	SerialNumber string
+	NotAfter     time.Time
+	// DaysLeft is the number of whole days to expiry, negative for expired certificates
+	DaysLeft int
+	// Expiring is true if the certificate expires within the threshold or has expired
+	Expiring bool
+	// Sources describe where the certificate was found, e.g. cache:User1@org1.example.com-cert.pem
+	// or peer:peer0.org1.example.com:7051 TLS CA
+	Sources []string
+}
+
+// Option configures Inspector
+type Option func(i *Inspector)
+
+// WithThreshold sets the time before expiry certificates are reported as expiring, DefaultThreshold by default
+func WithThreshold(threshold time.Duration) Option {
+	return func(i *Inspector) {
+		i.threshold = threshold
+	}
+}
+
+// WithHandler sets the handler called with every inspected certificate, e.g. to alert or to export metrics
+func WithHandler(handler func(cert Certificate)) Option {
+	return func(i *Inspector) {
+		i.handler = handler
+	}
+}
+
+// WithCache makes Inspector inspect certificates in cache, private keys are skipped
+func WithCache(cache cryptocache.CryptoCache) Option {
+	return func(i *Inspector) {
+		i.cache = cache
+	}
+}
+
+// WithEndpointConfig makes Inspector inspect TLS client certificates, TLS CA certificates of peers and orderers
+// and user certificates of organizations of config
+func WithEndpointConfig(config fab.EndpointConfig) Option {
+	return func(i *Inspector) {
+		i.endpointConfig = config
+	}
+}
+
+// WithIdentityConfig makes Inspector inspect the client TLS certificate and certificates of CAs of config.
+// CAs are taken from organizations of the endpoint config.
+func WithIdentityConfig(config msp.IdentityConfig) Option {
+	return func(i *Inspector) {
+		i.identityConfig = config
+	}
+}
+
+// Inspector reports days to expiry of certificates of the cache and the SDK configs.
+// Expiring certificates are logged as warnings, expired ones as errors.
+type Inspector struct {
+	threshold      time.Duration
+	handler        func(cert Certificate)
+	cache          cryptocache.CryptoCache
+	endpointConfig fab.EndpointConfig
+	identityConfig msp.IdentityConfig
+}
+
+// NewInspector returns Inspector configured by opts
+func NewInspector(opts ...Option) *Inspector {
+	inspector := &Inspector{threshold: DefaultThreshold}
+	for _, opt := range opts {
+		opt(inspector)
+	}
+	return inspector
+}
+
+// Inspect inspects all certificates once and returns them ordered by expiry
+func (i *Inspector) Inspect() []Certificate {
+	now := time.Now()
+	c := &collector{certs: make(map[string]*Certificate)}
+
+	if i.cache != nil {
+		c.addCache(i.cache)
+	}
+	if i.endpointConfig != nil {
+		c.addEndpointConfig(i.endpointConfig)
+	}
+	if i.identityConfig != nil {
+		c.addIdentityConfig(i.identityConfig, i.caIDs())
+	}
+
+	result := make([]Certificate, 0, len(c.certs))
+	for _, cert := range c.certs {
+		left := cert.NotAfter.Sub(now)
+		cert.DaysLeft = int(left / (24 * time.Hour))
+		if left < 0 && cert.DaysLeft == 0 {
+			cert.DaysLeft = -1
+		}
+		cert.Expiring = left < i.threshold
+		result = append(result, *cert)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].NotAfter.Before(result[b].NotAfter)
+	})
+
+	for _, cert := range result {
+		i.report(cert)
+	}
+
+	return result
+}
+
+// Run inspects all certificates every interval until ctx is done.
+// It returns an error at once if interval is not positive.
+func (i *Inspector) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid inspection interval %s", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		i.Inspect()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (i *Inspector) report(cert Certificate) {
+	sources := strings.Join(cert.Sources, ", ")
+	switch {
+	case cert.DaysLeft < 0:
+		logrus.Errorf("certificate %s (%s) expired at %s", cert.Subject, sources, cert.NotAfter.Format(time.RFC3339))
+	case cert.Expiring:
+		logrus.Warnf("certificate %s (%s) expires in %d days at %s", cert.Subject, sources, cert.DaysLeft, cert.NotAfter.Format(time.RFC3339))
+	}
+
+	if i.handler != nil {
+		i.handler(cert)
+	}
+}
+
+// caIDs returns IDs of CAs of organizations of the endpoint config
+func (i *Inspector) caIDs() []string {
+	if i.endpointConfig == nil || i.endpointConfig.NetworkConfig() == nil {
+		return nil
+	}
+	var ids []string
+	for _, org := range i.endpointConfig.NetworkConfig().Organizations {
+		ids = append(ids, org.CertificateAuthorities...)
+	}
+	return ids
+}
+
+// collector gathers certificates, a certificate found in several places is reported once with all sources
+type collector struct {
+	certs map[string]*Certificate
+}
+
+func (c *collector) add(cert *x509.Certificate, source string) {
+	if cert == nil {
+		return
+	}
+	key := string(cert.Raw)
+	if existing, ok := c.certs[key]; ok {
+		existing.Sources = append(existing.Sources, source)
+		return
+	}
+	c.certs[key] = &Certificate{
+		Subject:      cert.Subject.String(),
+		SerialNumber: cert.SerialNumber.String(),
+		NotAfter:     cert.NotAfter,
+		Sources:      []string{source},
+	}
+}
+
+// addPEM adds all certificates of PEM data
+func (c *collector) addPEM(data []byte, source string) {
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			logrus.Warnf("failed to parse certificate of %s: %s", source, err)
+			continue
+		}
+		c.add(cert, source)
+	}
+}
+
+func (c *collector) addCache(cache cryptocache.CryptoCache) {
+	lister, ok := cache.(cryptocache.Lister)
+	if !ok {
+		return
+	}
+	for _, key := range lister.Keys() {
+		if strings.HasSuffix(key, "_sk") {
+			continue
+		}
+		data, err := cache.GetCrypto(key)
+		if err != nil {
+			continue
+		}
+		c.addPEM(data, "cache:"+key)
+	}
+}
+
+func (c *collector) addEndpointConfig(config fab.EndpointConfig) {
+	for n, cert := range config.TLSClientCerts() {
+		if len(cert.Certificate) == 0 {
+			continue
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			continue
+		}
+		c.add(leaf, fmt.Sprintf("TLS client certificate %d", n))
+	}
+
+	for _, peer := range config.NetworkPeers() {
+		c.add(peer.TLSCACert, fmt.Sprintf("peer:%s TLS CA", peer.URL))
+	}
+	for _, orderer := range config.OrderersConfig() {
+		c.add(orderer.TLSCACert, fmt.Sprintf("orderer:%s TLS CA", orderer.URL))
+	}
+
+	if config.NetworkConfig() == nil {
+		return
+	}
+	for orgName, org := range config.NetworkConfig().Organizations {
+		for userName, user := range org.Users {
+			c.addPEM(user.Cert, fmt.Sprintf("user:%s/%s", orgName, userName))
+		}
+	}
+}
+
+func (c *collector) addIdentityConfig(config msp.IdentityConfig, caIDs []string) {
+	if client := config.Client(); client != nil {
+		c.addPEM(client.TLSCert, "client TLS certificate")
+	}
+
+	for _, caID := range caIDs {
+		caConfig, ok := config.CAConfig(caID)
+		if !ok {
+			continue
+		}
+		for _, cert := range caConfig.TLSCAServerCerts {
+			c.addPEM(cert, fmt.Sprintf("ca:%s TLS CA", caID))
+		}
+		c.addPEM(caConfig.TLSCAClientCert, fmt.Sprintf("ca:%s TLS client certificate", caID))
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package expiry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+const day = 24 * time.Hour
+
+// newTestCert returns a self-signed PEM certificate with common name cn expiring at notAfter
+func newTestCert(t *testing.T, cn string, notAfter time.Time) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notAfter.Add(-365 * day),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// newTestCache returns a cache with an expired certificate, certificates expiring in 10 and 40 days
+// and a private key name, which is skipped
+func newTestCache(t *testing.T) cryptocache.CryptoCache {
+	t.Helper()
+
+	now := time.Now()
+	expiring := newTestCert(t, "expiring", now.Add(10*day+time.Hour))
+	crypto := map[string][]byte{
+		"expired-cert.pem":  newTestCert(t, "expired", now.Add(-time.Hour)),
+		"expiring-cert.pem": expiring,
+		"ca-cert.pem":       expiring,
+		"valid-cert.pem":    newTestCert(t, "valid", now.Add(40*day+time.Hour)),
+		"0123_sk":           []byte("-----BEGIN CERTIFICATE-----\nnot a key\n-----END CERTIFICATE-----\n"),
+	}
+	cache := cryptocache.NewMemCache()
+	for key, value := range crypto {
+		if err := cache.SetCrypto(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cache
+}
+
+// logged returns messages of entries of hook at level
+func logged(hook *test.Hook, level logrus.Level) []string {
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == level {
+			messages = append(messages, entry.Message)
+		}
+	}
+	return messages
+}
+
+func TestInspectorThreshold(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	var handled []string
+	certs := NewInspector(
+		WithCache(newTestCache(t)),
+		WithHandler(func(cert Certificate) { handled = append(handled, cert.Subject) }),
+	).Inspect()
+
+	type result struct {
+		subject  string
+		daysLeft int
+		expiring bool
+		sources  int
+	}
+	var got []result
+	for _, cert := range certs {
+		got = append(got, result{subject: cert.Subject, daysLeft: cert.DaysLeft, expiring: cert.Expiring, sources: len(cert.Sources)})
+	}
+	want := []result{
+		{subject: "CN=expired", daysLeft: -1, expiring: true, sources: 1},
+		// the certificate cached under two names is reported once
+		{subject: "CN=expiring", daysLeft: 10, expiring: true, sources: 2},
+		{subject: "CN=valid", daysLeft: 40, expiring: false, sources: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("inspected %+v, want %+v", got, want)
+	}
+	if wantHandled := []string{"CN=expired", "CN=expiring", "CN=valid"}; !reflect.DeepEqual(handled, wantHandled) {
+		t.Errorf("handled %v, want %v", handled, wantHandled)
+	}
+
+	if errors := logged(hook, logrus.ErrorLevel); len(errors) != 1 {
+		t.Errorf("errors %v, want one of the expired certificate", errors)
+	}
+	if warnings := logged(hook, logrus.WarnLevel); len(warnings) != 1 {
+		t.Errorf("warnings %v, want one of the expiring certificate", warnings)
+	}
+
+	// with a shorter threshold only the expired certificate is reported
+	hook.Reset()
+	certs = NewInspector(WithCache(newTestCache(t)), WithThreshold(5*day)).Inspect()
+	for _, cert := range certs {
+		if cert.Expiring != (cert.DaysLeft < 0) {
+			t.Errorf("certificate %s expiring in %d days reported as expiring %t", cert.Subject, cert.DaysLeft, cert.Expiring)
+		}
+	}
+	if warnings := logged(hook, logrus.WarnLevel); len(warnings) != 0 {
+		t.Errorf("warnings %v with a threshold of 5 days", warnings)
+	}
+	if errors := logged(hook, logrus.ErrorLevel); len(errors) != 1 {
+		t.Errorf("errors %v, want one of the expired certificate", errors)
+	}
+}
+
+func TestInspectorRun(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := NewInspector().Run(context.Background(), interval); err == nil {
+			t.Errorf("interval %s accepted", interval)
+		}
+	}
+
+	var (
+		mu          sync.Mutex
+		inspections int
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inspector := NewInspector(
+		WithCache(newTestCache(t)),
+		WithHandler(func(cert Certificate) {
+			if cert.Subject != "CN=valid" {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			inspections++
+			if inspections == 3 {
+				cancel()
+			}
+		}),
+	)
+
+	done := make(chan error, 1)
+	go func() { done <- inspector.Run(ctx, 10*time.Millisecond) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run does not inspect every interval")
+	}
+
+	// certificates are inspected at once, not after the first interval
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	inspected := make(chan struct{}, 10)
+	inspector = NewInspector(
+		WithCache(newTestCache(t)),
+		WithHandler(func(Certificate) { inspected <- struct{}{} }),
+	)
+	go func() { done <- inspector.Run(ctx, time.Hour) }()
+	select {
+	case <-inspected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("certificates are not inspected at start")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
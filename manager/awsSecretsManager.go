@@ -0,0 +1,426 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/sirupsen/logrus"
+)
+
+// errSecretNotFound is returned by readSecret for secrets deleted or without a current version
+var errSecretNotFound = errors.New("secret not found")
+
+// AWSOption is a function that configures an AWSSecretsManager
+type AWSOption func(m *AWSSecretsManager) error
+
+// WithAWSConfig replaces the configuration loaded from the environment, shared config files
+// and the instance, task or pod IAM role
+func WithAWSConfig(cfg aws.Config) AWSOption {
+	return func(m *AWSSecretsManager) error {
+		m.awsConfig = &cfg
+		return nil
+	}
+}
+
+// WithAWSRole makes AWSSecretsManager assume IAM role roleARN with the loaded credentials
+func WithAWSRole(roleARN string) AWSOption {
+	return func(m *AWSSecretsManager) error {
+		if roleARN == "" {
+			return errors.New("role ARN must not be empty")
+		}
+		m.roleARN = roleARN
+		return nil
+	}
+}
+
+// WithAWSEndpoint sends requests to url instead of the regional endpoint, e.g. to a local stand-in server
+func WithAWSEndpoint(url string) AWSOption {
+	return func(m *AWSSecretsManager) error {
+		m.endpoint = url
+		return nil
+	}
+}
+
+// WithAWSNamePrefix makes AWSSecretsManager load only secrets with names starting with prefix
+func WithAWSNamePrefix(prefix string) AWSOption {
+	return func(m *AWSSecretsManager) error {
+		m.namePrefix = prefix
+		return nil
+	}
+}
+
+// WithAWSTag makes AWSSecretsManager load only secrets tagged with key and value
+func WithAWSTag(key, value string) AWSOption {
+	return func(m *AWSSecretsManager) error {
+		if key == "" {
+			return errors.New("tag key must not be empty")
+		}
+		m.tagKey = key
+		m.tagValue = value
+		return nil
+	}
+}
+
+// WithAWSFetchConfig configures concurrency and retries of crypto download
+func WithAWSFetchConfig(cfg FetchConfig) AWSOption {
+	return func(m *AWSSecretsManager) error {
+		m.fetchConfig = cfg
+		return nil
+	}
+}
+
+// AWSSecretsManager handles AWS Secrets Manager operations
+type AWSSecretsManager struct {
+	validationConfig
+
+	client          *secretsmanager.Client
+	awsConfig       *aws.Config
+	roleARN         string
+	endpoint        string
+	namePrefix      string
+	tagKey          string
+	tagValue        string
+	memcache        cryptocache.CryptoCache
+	signingIdentity *VaultSigningIdentity
+	unwatch         func()
+	fetchConfig     FetchConfig
+	closeOnce       sync.Once
+	index           map[string]string // crypto names to IDs of the secrets they were read from
+	names           map[string]string // crypto names to names of the secrets they were read from
+	indexMu         sync.RWMutex
+}
+
+// NewAWSSecretsManager gets new instance of AWSSecretsManager
+// Secret names are decoded the same way as by SecretManager. Credentials are taken from the environment,
+// shared config files or the IAM role of the instance, task or pod unless configured by options.
+func NewAWSSecretsManager(mspID, region, userCert string, opts ...AWSOption) (*AWSSecretsManager, error) {
+	return NewAWSSecretsManagerContext(context.Background(), mspID, region, userCert, opts...)
+}
+
+// NewAWSSecretsManagerContext is NewAWSSecretsManager with ctx bounding download of crypto
+func NewAWSSecretsManagerContext(ctx context.Context, mspID, region, userCert string, opts ...AWSOption) (*AWSSecretsManager, error) {
+	manager := &AWSSecretsManager{memcache: cryptocache.NewMemCache()}
+	for _, opt := range opts {
+		if err := opt(manager); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg, err := manager.loadAWSConfig(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	var clientOpts []func(*secretsmanager.Options)
+	if manager.endpoint != "" {
+		clientOpts = append(clientOpts, secretsmanager.WithEndpointResolver(secretsmanager.EndpointResolverFromURL(manager.endpoint)))
+	}
+	manager.client = secretsmanager.NewFromConfig(cfg, clientOpts...)
+
+	t := time.Now()
+	if err = manager.pullAWSCrypto(ctx); err != nil {
+		return nil, err
+	}
+	logrus.Infof("loading of cryptomaterials took %.2f seconds", time.Since(t).Seconds())
+
+	manager.signingIdentity, err = NewVaultSigningIdentity(mspID, userCert, manager)
+	if err != nil {
+		return nil, err
+	}
+
+	if notifier, ok := manager.memcache.(cryptocache.Notifier); ok {
+		manager.unwatch = manager.signingIdentity.Watch(notifier)
+	}
+
+	return manager, nil
+}
+
+// loadAWSConfig returns the configuration set by WithAWSConfig or loads the default one
+func (m *AWSSecretsManager) loadAWSConfig(ctx context.Context, region string) (aws.Config, error) {
+	var cfg aws.Config
+	if m.awsConfig != nil {
+		cfg = m.awsConfig.Copy()
+	} else {
+		var err error
+		cfg, err = config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+	}
+	if region != "" {
+		cfg.Region = region
+	}
+
+	if m.roleARN != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), m.roleARN))
+	}
+
+	return cfg, nil
+}
+
+// pullAWSCrypto reads all secrets matching the filters and puts them to the cache
+func (m *AWSSecretsManager) pullAWSCrypto(ctx context.Context) error {
+	f := newFetcher(ctx, m.fetchConfig, isTransientAWSError)
+	err := m.listSecrets(ctx, func(secret types.SecretListEntry) {
+		f.Go(func(ctx context.Context) error {
+			return m.pullSecret(ctx, aws.ToString(secret.ARN), aws.ToString(secret.Name))
+		})
+	})
+	if err != nil {
+		f.fail(err)
+	}
+
+	return f.Wait()
+}
+
+// listSecrets calls visit for every secret matching the filters, all pages are listed
+func (m *AWSSecretsManager) listSecrets(ctx context.Context, visit func(secret types.SecretListEntry)) error {
+	input := &secretsmanager.ListSecretsInput{}
+	if m.namePrefix != "" {
+		input.Filters = append(input.Filters, types.Filter{Key: types.FilterNameStringTypeName, Values: []string{m.namePrefix}})
+	}
+	if m.tagKey != "" {
+		input.Filters = append(input.Filters, types.Filter{Key: types.FilterNameStringTypeTagKey, Values: []string{m.tagKey}})
+	}
+
+	paginator := secretsmanager.NewListSecretsPaginator(m.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list secrets: %w", err)
+		}
+
+		for _, secret := range page.SecretList {
+			if m.tagKey != "" && !hasTag(secret.Tags, m.tagKey, m.tagValue) {
+				continue
+			}
+			visit(secret)
+		}
+	}
+
+	return nil
+}
+
+// hasTag reports whether tags contain key with value, the name prefix and tag key are filtered by AWS
+func hasTag(tags []types.Tag, key, value string) bool {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key && aws.ToString(tag.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// pullSecret reads the current version of secret secretID and puts it to the cache under the decoded secretName
+func (m *AWSSecretsManager) pullSecret(ctx context.Context, secretID, secretName string) error {
+	data, err := m.readSecret(ctx, secretID)
+	if errors.Is(err, errSecretNotFound) {
+		// the secret was deleted after it was listed
+		return nil
+	}
+	if isAccessDenied(err) {
+		// the filter may match secrets of other applications the credentials do not grant access to
+		logrus.Warnf("secret %s is skipped: %s", secretName, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	name := secretCryptoName(decodeSecretName(secretName))
+	m.indexSecret(name, secretID, secretName)
+
+	return m.memcache.SetCrypto(name, data)
+}
+
+// Store puts value as the current version of the secret crypto with name was read from and puts it to the cache.
+// For new crypto a secret named by the name prefix and encodeSecretName is created with the filter tag.
+func (m *AWSSecretsManager) Store(ctx context.Context, name string, value []byte) error {
+	m.indexMu.RLock()
+	secretID, ok := m.index[name]
+	secretName := m.names[name]
+	m.indexMu.RUnlock()
+
+	created := false
+	if !ok {
+		secretName = m.namePrefix + encodeSecretName(secretStoreName(name))
+		if err := checkStoredName(name, secretName, func(secretName string) string {
+			return secretCryptoName(decodeSecretName(secretName))
+		}); err != nil {
+			return err
+		}
+
+		input := &secretsmanager.CreateSecretInput{Name: aws.String(secretName), SecretBinary: value}
+		if m.tagKey != "" {
+			input.Tags = []types.Tag{{Key: aws.String(m.tagKey), Value: aws.String(m.tagValue)}}
+		}
+		output, err := m.client.CreateSecret(ctx, input)
+		var existsErr *types.ResourceExistsException
+		switch {
+		case err == nil:
+			secretID, created = aws.ToString(output.ARN), true
+		case errors.As(err, &existsErr):
+			// the secret was created after crypto was read
+			secretID = secretName
+		default:
+			return fmt.Errorf("failed to create secret %s: %w", secretName, err)
+		}
+	}
+
+	if !created {
+		_, err := m.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{SecretId: aws.String(secretID), SecretBinary: value})
+		if err != nil {
+			return fmt.Errorf("failed to store %s to %s: %w", name, secretID, err)
+		}
+	}
+
+	m.indexSecret(name, secretID, secretName)
+
+	return m.memcache.SetCrypto(name, value)
+}
+
+// indexSecret records that crypto with name is stored in secret secretID named secretName
+func (m *AWSSecretsManager) indexSecret(name, secretID, secretName string) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+	if m.index == nil {
+		m.index = make(map[string]string)
+		m.names = make(map[string]string)
+	}
+	if old, ok := m.names[name]; ok && keepPath(decodeSecretName(old), decodeSecretName(secretName)) {
+		return
+	}
+	m.index[name] = secretID
+	m.names[name] = secretName
+}
+
+// backendPath returns the decoded name of the secret of crypto with name
+func (m *AWSSecretsManager) backendPath(name string) (string, bool) {
+	m.indexMu.RLock()
+	defer m.indexMu.RUnlock()
+	secretName, ok := m.names[name]
+	return decodeSecretName(secretName), ok
+}
+
+// Delete deletes the secret of crypto with name without a recovery window, so that the name can be stored
+// again at once, and removes the crypto from the cache
+func (m *AWSSecretsManager) Delete(ctx context.Context, name string) error {
+	m.indexMu.RLock()
+	secretID, ok := m.index[name]
+	m.indexMu.RUnlock()
+	if !ok {
+		secretID = m.namePrefix + encodeSecretName(secretStoreName(name))
+	}
+
+	_, err := m.client.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
+		SecretId:                   aws.String(secretID),
+		ForceDeleteWithoutRecovery: aws.Bool(true),
+	})
+	var notFound *types.ResourceNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return fmt.Errorf("failed to delete %s at %s: %w", name, secretID, err)
+	}
+
+	m.indexMu.Lock()
+	delete(m.index, name)
+	delete(m.names, name)
+	m.indexMu.Unlock()
+
+	deleteCached(m.memcache, name)
+	return nil
+}
+
+// readSecret reads the current version of secret secretID, errSecretNotFound is returned
+// if the secret has no current version
+func (m *AWSSecretsManager) readSecret(ctx context.Context, secretID string) ([]byte, error) {
+	output, err := m.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("%w: %s", errSecretNotFound, secretID)
+		}
+		return nil, err
+	}
+
+	if output.SecretBinary != nil {
+		return output.SecretBinary, nil
+	}
+	return []byte(aws.ToString(output.SecretString)), nil
+}
+
+// isAccessDenied reports whether err is AccessDeniedException
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException"
+}
+
+// isTransientAWSError reports whether a failed AWS request may succeed on retry
+func isTransientAWSError(err error) bool {
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() >= http.StatusInternalServerError || respErr.HTTPStatusCode() == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Sign signs digest with the Signer of key
+func (m *AWSSecretsManager) Sign(digest []byte, key *CartridgeKey) ([]byte, error) {
+	return m.SignContext(context.Background(), digest, key)
+}
+
+// SignContext is Sign with cancellation by ctx
+func (m *AWSSecretsManager) SignContext(ctx context.Context, digest []byte, key *CartridgeKey) ([]byte, error) {
+	return signWithKey(ctx, digest, key)
+}
+
+// Verify verifies signature against digest using ecdsaPublicKey
+func (m *AWSSecretsManager) Verify(digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return m.VerifyContext(context.Background(), digest, signature, ecdsaPublicKey)
+}
+
+// VerifyContext is Verify with cancellation by ctx
+func (m *AWSSecretsManager) VerifyContext(ctx context.Context, digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return verifyECDSA(ctx, digest, signature, ecdsaPublicKey)
+}
+
+// SigningIdentity returns signing identity
+func (m *AWSSecretsManager) SigningIdentity() CartridgeSigningIdentity {
+	return m.signingIdentity
+}
+
+// Cache returns cache
+func (m *AWSSecretsManager) Cache() cryptocache.CryptoCache {
+	return m.memcache
+}
+
+// Close wipes cached crypto and the private key of the signing identity.
+// The manager must not be used after Close.
+func (m *AWSSecretsManager) Close() error {
+	m.closeOnce.Do(func() {
+		if m.unwatch != nil {
+			m.unwatch()
+		}
+		if clearer, ok := m.memcache.(cryptocache.Clearer); ok {
+			clearer.Clear()
+		}
+		if m.signingIdentity != nil {
+			m.signingIdentity.wipe()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,211 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+const awsStubPageSize = 2
+
+// awsStubSecret is a secret served by awsStub
+type awsStubSecret struct {
+	name  string
+	tags  map[string]string
+	value []byte
+	// gone makes GetSecretValue fail with ResourceNotFoundException, as for a secret deleted after listing
+	gone bool
+	// errType makes GetSecretValue fail with an error of the type, e.g. AccessDeniedException
+	errType string
+}
+
+// awsStub serves ListSecrets and GetSecretValue of the AWS Secrets Manager JSON protocol
+type awsStub struct {
+	secrets []awsStubSecret
+
+	mu        sync.Mutex
+	listCalls int
+	filters   map[string][]string
+}
+
+func (s *awsStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Filters []struct {
+			Key    string
+			Values []string
+		}
+		NextToken string
+		SecretID  string `json:"SecretId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch r.Header.Get("X-Amz-Target") {
+	case "secretsmanager.ListSecrets":
+		filters := make(map[string][]string)
+		for _, filter := range body.Filters {
+			filters[filter.Key] = filter.Values
+		}
+		s.mu.Lock()
+		s.listCalls++
+		s.filters = filters
+		s.mu.Unlock()
+
+		var matching []map[string]interface{}
+		for _, secret := range s.secrets {
+			if prefix := filters["name"]; len(prefix) != 0 && !strings.HasPrefix(secret.name, prefix[0]) {
+				continue
+			}
+			if tagKey := filters["tag-key"]; len(tagKey) != 0 {
+				if _, ok := secret.tags[tagKey[0]]; !ok {
+					continue
+				}
+			}
+			var tags []map[string]string
+			for key, value := range secret.tags {
+				tags = append(tags, map[string]string{"Key": key, "Value": value})
+			}
+			matching = append(matching, map[string]interface{}{"ARN": awsStubARN(secret.name), "Name": secret.name, "Tags": tags})
+		}
+
+		start, _ := strconv.Atoi(body.NextToken)
+		end := start + awsStubPageSize
+		resp := map[string]interface{}{}
+		if end < len(matching) {
+			resp["NextToken"] = strconv.Itoa(end)
+		} else {
+			end = len(matching)
+		}
+		resp["SecretList"] = matching[start:end]
+		_ = json.NewEncoder(w).Encode(resp)
+
+	case "secretsmanager.GetSecretValue":
+		for _, secret := range s.secrets {
+			if awsStubARN(secret.name) != body.SecretID {
+				continue
+			}
+			switch {
+			case secret.gone:
+				awsStubError(w, "ResourceNotFoundException")
+			case secret.errType != "":
+				awsStubError(w, secret.errType)
+			default:
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"ARN": body.SecretID, "Name": secret.name, "SecretBinary": secret.value})
+			}
+			return
+		}
+		awsStubError(w, "ResourceNotFoundException")
+
+	default:
+		http.Error(w, "unexpected operation", http.StatusBadRequest)
+	}
+}
+
+func awsStubARN(name string) string {
+	return "arn:aws:secretsmanager:us-east-1:000000000000:secret:" + name
+}
+
+func awsStubError(w http.ResponseWriter, errType string) {
+	w.Header().Set("X-Amzn-Errortype", errType)
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"__type": errType, "message": errType})
+}
+
+// newAWSStubManager starts stub with the certificate and key of User1 and returns a manager reading from it
+func newAWSStubManager(t *testing.T, stub *awsStub, opts ...AWSOption) (*AWSSecretsManager, error) {
+	t.Helper()
+
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	}
+	return NewAWSSecretsManager("Org1MSP", "", "User1@org1.example.com-cert.pem",
+		append([]AWSOption{WithAWSConfig(cfg), WithAWSEndpoint(server.URL)}, opts...)...)
+}
+
+func TestAWSSecretsManagerFilters(t *testing.T) {
+	certPEM, _, key := newTestCert(t, "User1@org1.example.com", false, nil, nil)
+	prod := map[string]string{"env": "prod"}
+	stub := &awsStub{secrets: []awsStubSecret{
+		{name: "prod/User1____org1__example__com-cert__pem", tags: prod, value: certPEM},
+		{name: "prod/" + privateKeyName(&key.PublicKey), tags: prod, value: newTestKeyPEM(t, key)},
+		{name: "prod/dev__pem", tags: map[string]string{"env": "dev"}, value: []byte("dev")},
+		{name: "prod/untagged__pem", value: []byte("untagged")},
+		{name: "staging/staging__pem", tags: prod, value: []byte("staging")},
+		{name: "prod/gone__pem", tags: prod, gone: true},
+		{name: "prod/last__pem", tags: prod, value: []byte("last")},
+	}}
+
+	m, err := newAWSStubManager(t, stub, WithAWSNamePrefix("prod/"), WithAWSTag("env", "prod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if got := stub.filters["name"]; len(got) != 1 || got[0] != "prod/" {
+		t.Errorf("name filter = %v, want [prod/]", got)
+	}
+	if got := stub.filters["tag-key"]; len(got) != 1 || got[0] != "env" {
+		t.Errorf("tag-key filter = %v, want [env]", got)
+	}
+	// 5 secrets under prod/ with tag env are listed in pages of 2
+	if stub.listCalls != 3 {
+		t.Errorf("ListSecrets called %d times, want 3", stub.listCalls)
+	}
+
+	for _, name := range []string{"User1@org1.example.com-cert.pem", privateKeyName(&key.PublicKey), "last.pem"} {
+		if _, err = m.Cache().GetCrypto(name); err != nil {
+			t.Errorf("crypto %s is not cached: %s", name, err)
+		}
+	}
+	for _, name := range []string{"dev.pem", "untagged.pem", "staging.pem", "gone.pem"} {
+		if _, err = m.Cache().GetCrypto(name); err == nil {
+			t.Errorf("crypto %s is cached", name)
+		}
+	}
+
+	if m.SigningIdentity().Identifier().MSPID != "Org1MSP" {
+		t.Errorf("signing identity MSP ID = %s", m.SigningIdentity().Identifier().MSPID)
+	}
+}
+
+func TestAWSSecretsManagerReadError(t *testing.T) {
+	certPEM, _, key := newTestCert(t, "User1@org1.example.com", false, nil, nil)
+	stub := &awsStub{secrets: []awsStubSecret{
+		{name: "User1____org1__example__com-cert__pem", value: certPEM},
+		{name: privateKeyName(&key.PublicKey), value: newTestKeyPEM(t, key)},
+		{name: "denied__pem", errType: "AccessDeniedException"},
+	}}
+
+	// secrets the manager may not read are skipped
+	m, err := newAWSStubManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Cache().GetCrypto("denied.pem"); err == nil {
+		t.Error("denied secret is cached")
+	}
+
+	stub.secrets[2].errType = "DecryptionFailure"
+	_, err = newAWSStubManager(t, stub)
+	if err == nil || !strings.Contains(err.Error(), "DecryptionFailure") {
+		t.Fatalf("err = %v, want DecryptionFailure", err)
+	}
+	if errors.Is(err, errSecretNotFound) {
+		t.Fatalf("err = %v, is errSecretNotFound", err)
+	}
+}
@@ -0,0 +1,446 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azsecrets"
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/sirupsen/logrus"
+)
+
+// AzureOption is a function that configures an AzureKeyVaultManager
+type AzureOption func(m *AzureKeyVaultManager) error
+
+// WithAzureCredential sets the credential used to access Key Vault instead of the default credential chain
+// (environment, workload identity, managed identity, Azure CLI)
+func WithAzureCredential(credential azcore.TokenCredential) AzureOption {
+	return func(m *AzureKeyVaultManager) error {
+		if credential == nil {
+			return errors.New("azure credential must not be nil")
+		}
+		m.credential = credential
+		return nil
+	}
+}
+
+// WithAzureKeySigning makes AzureKeyVaultManager sign digests with the Key Vault key keyName (ES256),
+// so the private key is never exported. Empty keyVersion means the latest version.
+// The key must be a P-256 EC key matching the public key of the user certificate.
+// Private keys stored in secrets under <ski>_sk are not read into the cache in this mode.
+func WithAzureKeySigning(keyName, keyVersion string) AzureOption {
+	return func(m *AzureKeyVaultManager) error {
+		if keyName == "" {
+			return errors.New("key name must not be empty")
+		}
+		m.keyName = keyName
+		m.keyVersion = keyVersion
+		return nil
+	}
+}
+
+// WithAzureClientOptions sets options of the Key Vault clients, e.g. retries, a proxy transport or a sovereign cloud
+func WithAzureClientOptions(options azcore.ClientOptions) AzureOption {
+	return func(m *AzureKeyVaultManager) error {
+		m.clientOptions = options
+		return nil
+	}
+}
+
+// WithAzureFetchConfig configures concurrency and retries of crypto download
+func WithAzureFetchConfig(cfg FetchConfig) AzureOption {
+	return func(m *AzureKeyVaultManager) error {
+		m.fetchConfig = cfg
+		return nil
+	}
+}
+
+// AzureKeyVaultManager handles Azure Key Vault operations
+type AzureKeyVaultManager struct {
+	validationConfig
+
+	secrets         *azsecrets.Client
+	keys            *azkeys.Client
+	credential      azcore.TokenCredential
+	clientOptions   azcore.ClientOptions
+	keyName         string
+	keyVersion      string
+	keyVersions     map[string]string // SKIs of checked public keys to versions of the key holding them
+	keyMu           sync.RWMutex
+	memcache        cryptocache.CryptoCache
+	signingIdentity *VaultSigningIdentity
+	unwatch         func()
+	fetchConfig     FetchConfig
+	closeOnce       sync.Once
+	paths           map[string]string // crypto names to decoded names of the secrets they were read from
+	pathsMu         sync.RWMutex
+}
+
+// NewAzureKeyVaultManager gets new instance of AzureKeyVaultManager
+// vaultURL is the Key Vault URL, e.g. https://org1.vault.azure.net. Key Vault secret names allow only
+// alphanumerics and dashes, so every other character of a crypto name, including the dash, is stored
+// as a dash followed by its two hex digits, e.g. User1@org1.example.com-cert.pem is stored as
+// User1-40org1-2eexample-2ecom-2dcert-2epem.
+func NewAzureKeyVaultManager(mspID, vaultURL, userCert string, opts ...AzureOption) (*AzureKeyVaultManager, error) {
+	return NewAzureKeyVaultManagerContext(context.Background(), mspID, vaultURL, userCert, opts...)
+}
+
+// NewAzureKeyVaultManagerContext is NewAzureKeyVaultManager with ctx bounding download of crypto
+func NewAzureKeyVaultManagerContext(ctx context.Context, mspID, vaultURL, userCert string, opts ...AzureOption) (*AzureKeyVaultManager, error) {
+	manager := &AzureKeyVaultManager{memcache: cryptocache.NewMemCache()}
+	for _, opt := range opts {
+		if err := opt(manager); err != nil {
+			return nil, err
+		}
+	}
+
+	if manager.credential == nil {
+		credential, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create azure credential: %w", err)
+		}
+		manager.credential = credential
+	}
+
+	var err error
+	manager.secrets, err = azsecrets.NewClient(vaultURL, manager.credential, &azsecrets.ClientOptions{ClientOptions: manager.clientOptions})
+	if err != nil {
+		return nil, err
+	}
+	if manager.keyName != "" {
+		manager.keys, err = azkeys.NewClient(vaultURL, manager.credential, &azkeys.ClientOptions{ClientOptions: manager.clientOptions})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t := time.Now()
+	if err = manager.pullAzureCrypto(ctx); err != nil {
+		return nil, err
+	}
+	logrus.Infof("loading of cryptomaterials took %.2f seconds", time.Since(t).Seconds())
+
+	if manager.keyName != "" {
+		manager.signingIdentity, err = NewVaultSigningIdentityFromCertContext(ctx, mspID, userCert, manager)
+		if err == nil {
+			err = manager.checkAzureKey(ctx, manager.signingIdentity.Key.PubKey)
+		}
+	} else {
+		manager.signingIdentity, err = NewVaultSigningIdentityContext(ctx, mspID, userCert, manager)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if notifier, ok := manager.memcache.(cryptocache.Notifier); ok {
+		manager.unwatch = manager.signingIdentity.Watch(notifier)
+	}
+
+	return manager, nil
+}
+
+// pullAzureCrypto reads all enabled secrets of the vault and puts them to the cache
+func (m *AzureKeyVaultManager) pullAzureCrypto(ctx context.Context) error {
+	f := newFetcher(ctx, m.fetchConfig, isTransientAzureError)
+
+	pager := m.secrets.NewListSecretsPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			f.fail(fmt.Errorf("failed to list secrets: %w", err))
+			break
+		}
+
+		for _, secret := range page.Value {
+			if secret.ID == nil || (secret.Attributes != nil && secret.Attributes.Enabled != nil && !*secret.Attributes.Enabled) {
+				continue
+			}
+			secretName := secret.ID.Name()
+			f.Go(func(ctx context.Context) error {
+				return m.pullSecret(ctx, secretName)
+			})
+		}
+	}
+
+	return f.Wait()
+}
+
+// pullSecret reads the latest version of secret secretName and puts it to the cache
+func (m *AzureKeyVaultManager) pullSecret(ctx context.Context, secretName string) error {
+	name, err := decodeAzureSecretName(secretName)
+	if err != nil {
+		logrus.Warnf("skipping secret %s: %s", secretName, err)
+		return nil
+	}
+	cryptoName := secretCryptoName(name)
+	if m.skipCrypto(cryptoName) {
+		return nil
+	}
+
+	secret, err := m.secrets.GetSecret(ctx, secretName, "", nil)
+	if err != nil {
+		return err
+	}
+	if secret.Value == nil {
+		return nil
+	}
+
+	m.pathsMu.Lock()
+	if m.paths == nil {
+		m.paths = make(map[string]string)
+	}
+	if old, ok := m.paths[cryptoName]; !ok || !keepPath(old, name) {
+		m.paths[cryptoName] = name
+	}
+	m.pathsMu.Unlock()
+
+	return m.memcache.SetCrypto(cryptoName, []byte(*secret.Value))
+}
+
+// skipCrypto reports whether crypto with name is not read from Key Vault,
+// private keys are not read with key signing enabled
+func (m *AzureKeyVaultManager) skipCrypto(name string) bool {
+	return m.keyName != "" && strings.HasSuffix(name, "_sk")
+}
+
+// backendPath returns the decoded name of the secret of crypto with name
+func (m *AzureKeyVaultManager) backendPath(name string) (string, bool) {
+	m.pathsMu.RLock()
+	defer m.pathsMu.RUnlock()
+	secretName, ok := m.paths[name]
+	return secretName, ok
+}
+
+// Store sets value as a new version of the secret crypto with name was read from and puts it to the cache.
+// New crypto is stored to the secret with the escaped name.
+func (m *AzureKeyVaultManager) Store(ctx context.Context, name string, value []byte) error {
+	decoded := m.secretPath(name)
+	secretName := encodeAzureSecretName(decoded)
+	if err := checkStoredName(name, secretName, func(secretName string) string {
+		decoded, _ := decodeAzureSecretName(secretName)
+		return secretCryptoName(decoded)
+	}); err != nil {
+		return err
+	}
+
+	secretValue := string(value)
+	if _, err := m.secrets.SetSecret(ctx, secretName, azsecrets.SetSecretParameters{Value: &secretValue}, nil); err != nil {
+		return fmt.Errorf("failed to store %s to %s: %w", name, secretName, err)
+	}
+
+	m.pathsMu.Lock()
+	if m.paths == nil {
+		m.paths = make(map[string]string)
+	}
+	m.paths[name] = decoded
+	m.pathsMu.Unlock()
+
+	return m.memcache.SetCrypto(name, value)
+}
+
+// secretPath returns the decoded name of the secret crypto with name was read from or is stored to
+func (m *AzureKeyVaultManager) secretPath(name string) string {
+	if decoded, ok := m.backendPath(name); ok {
+		return decoded
+	}
+	return secretStoreName(name)
+}
+
+// Delete deletes the secret of crypto with name and removes the crypto from the cache.
+// With soft-delete enabled on the vault the name cannot be stored again until the deleted secret is purged.
+func (m *AzureKeyVaultManager) Delete(ctx context.Context, name string) error {
+	secretName := encodeAzureSecretName(m.secretPath(name))
+	_, err := m.secrets.DeleteSecret(ctx, secretName, nil)
+	var respErr *azcore.ResponseError
+	if err != nil && !(errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound) {
+		return fmt.Errorf("failed to delete %s at %s: %w", name, secretName, err)
+	}
+
+	m.pathsMu.Lock()
+	delete(m.paths, name)
+	m.pathsMu.Unlock()
+
+	deleteCached(m.memcache, name)
+	return nil
+}
+
+// encodeAzureSecretName escapes characters of name other than letters and digits as a dash followed
+// by two hex digits, the only other character Key Vault secret names allow is the dash
+func encodeAzureSecretName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "-%02x", c)
+	}
+	return b.String()
+}
+
+// decodeAzureSecretName decodes secretName with characters other than letters and digits
+// escaped as a dash followed by two hex digits
+func decodeAzureSecretName(secretName string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(secretName); i++ {
+		if secretName[i] != '-' {
+			b.WriteByte(secretName[i])
+			continue
+		}
+		if i+2 >= len(secretName) {
+			return "", fmt.Errorf("truncated escape at position %d", i)
+		}
+		c, err := hex.DecodeString(secretName[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape at position %d: %w", i, err)
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// isTransientAzureError reports whether a failed Key Vault request may succeed on retry
+func isTransientAzureError(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= http.StatusInternalServerError || respErr.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// checkAzureKey verifies that the signing key is a P-256 EC key matching ecdsaPublicKey
+// and pins signing with ecdsaPublicKey to the version of the key read
+func (m *AzureKeyVaultManager) checkAzureKey(ctx context.Context, ecdsaPublicKey *ecdsa.PublicKey) error {
+	key, err := m.keys.GetKey(ctx, m.keyName, m.keyVersion, nil)
+	if err != nil {
+		return err
+	}
+	if key.Key == nil || key.Key.Kty == nil || key.Key.Crv == nil {
+		return fmt.Errorf("failed to read key %s", m.keyName)
+	}
+	if (*key.Key.Kty != azkeys.JSONWebKeyTypeEC && *key.Key.Kty != azkeys.JSONWebKeyTypeECHSM) || *key.Key.Crv != azkeys.JSONWebKeyCurveNameP256 {
+		return fmt.Errorf("key %s is %s %s, expecting EC P-256", m.keyName, *key.Key.Kty, *key.Key.Crv)
+	}
+
+	azurePublicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(key.Key.X),
+		Y:     new(big.Int).SetBytes(key.Key.Y),
+	}
+	if !azurePublicKey.Equal(ecdsaPublicKey) {
+		return fmt.Errorf("key %s does not match the certificate public key", m.keyName)
+	}
+
+	// with the latest version configured the key may be rotated later, signing stays with the version read now
+	version := m.keyVersion
+	if key.Key.KID != nil && key.Key.KID.Version() != "" {
+		version = key.Key.KID.Version()
+	}
+	m.keyMu.Lock()
+	if m.keyVersions == nil {
+		m.keyVersions = make(map[string]string)
+	}
+	m.keyVersions[hexSKI(ecdsaPublicKey)] = version
+	m.keyMu.Unlock()
+
+	return nil
+}
+
+// checkRemoteKey checks that the Key Vault key holds ecdsaPublicKey
+func (m *AzureKeyVaultManager) checkRemoteKey(ctx context.Context, ecdsaPublicKey *ecdsa.PublicKey) error {
+	if m.keyName == "" {
+		return errors.New("key signing is not enabled")
+	}
+	return m.checkAzureKey(ctx, ecdsaPublicKey)
+}
+
+// azureSign signs the SHA-256 digest with the version of the Key Vault key checked to hold ecdsaPublicKey
+// and returns a low-S DER signature
+func (m *AzureKeyVaultManager) azureSign(ctx context.Context, digest []byte, ecdsaPublicKey *ecdsa.PublicKey) ([]byte, error) {
+	if ecdsaPublicKey == nil {
+		return nil, errors.New("public key of the key to sign with is nil")
+	}
+	m.keyMu.RLock()
+	version, ok := m.keyVersions[hexSKI(ecdsaPublicKey)]
+	m.keyMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("key %s is not held by Key Vault key %s", hexSKI(ecdsaPublicKey), m.keyName)
+	}
+
+	algorithm := azkeys.JSONWebKeySignatureAlgorithmES256
+	resp, err := m.keys.Sign(ctx, m.keyName, version, azkeys.SignParameters{Algorithm: &algorithm, Value: digest}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// JWS signatures are raw r||s
+	return rawToLowSDER(ecdsaPublicKey, resp.Result)
+}
+
+// Sign signs the digest with the Signer of key. With key signing enabled keys without a Signer,
+// such as the key of the signing identity, are signed by Key Vault with the version of the key
+// checked to hold them, other keys without a Signer are rejected.
+func (m *AzureKeyVaultManager) Sign(digest []byte, key *CartridgeKey) ([]byte, error) {
+	return m.SignContext(context.Background(), digest, key)
+}
+
+// SignContext is Sign with cancellation by ctx
+func (m *AzureKeyVaultManager) SignContext(ctx context.Context, digest []byte, key *CartridgeKey) ([]byte, error) {
+	if m.keyName != "" && key != nil && key.Signer == nil {
+		return m.azureSign(ctx, digest, key.PubKey)
+	}
+	return signWithKey(ctx, digest, key)
+}
+
+// Verify verifies signature against digest using ecdsaPublicKey
+func (m *AzureKeyVaultManager) Verify(digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return m.VerifyContext(context.Background(), digest, signature, ecdsaPublicKey)
+}
+
+// VerifyContext is Verify with cancellation by ctx
+func (m *AzureKeyVaultManager) VerifyContext(ctx context.Context, digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return verifyECDSA(ctx, digest, signature, ecdsaPublicKey)
+}
+
+// SigningIdentity returns signing identity
+func (m *AzureKeyVaultManager) SigningIdentity() CartridgeSigningIdentity {
+	return m.signingIdentity
+}
+
+// Cache returns cache
+func (m *AzureKeyVaultManager) Cache() cryptocache.CryptoCache {
+	return m.memcache
+}
+
+// Close wipes cached crypto and the private key of the signing identity.
+// The manager must not be used after Close.
+func (m *AzureKeyVaultManager) Close() error {
+	m.closeOnce.Do(func() {
+		if m.unwatch != nil {
+			m.unwatch()
+		}
+		if clearer, ok := m.memcache.(cryptocache.Clearer); ok {
+			clearer.Clear()
+		}
+		if m.signingIdentity != nil {
+			m.signingIdentity.wipe()
+		}
+	})
+	return nil
+}
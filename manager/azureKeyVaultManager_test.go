@@ -0,0 +1,399 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const testAzureVaultURL = "https://org1.vault.azure.net"
+
+// azureStub serves secrets and keys of the Key Vault REST API
+type azureStub struct {
+	mu           sync.Mutex
+	secrets      map[string]string // by secret name
+	keys         []*ecdsa.PrivateKey
+	reads        []string // names of secrets read
+	signVersions []string
+}
+
+func newAzureStub() *azureStub {
+	return &azureStub{secrets: make(map[string]string)}
+}
+
+// put sets secret of crypto name to value
+func (s *azureStub) put(name string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[encodeAzureSecretName(name)] = string(value)
+}
+
+// putIdentity puts a certificate named certName issued for key, if key is nil
+// the certificate is issued for a new key put under <ski>_sk as well
+func (s *azureStub) putIdentity(t *testing.T, certName string, key *ecdsa.PrivateKey) *ecdsa.PrivateKey {
+	t.Helper()
+
+	cert, issued := newTestUserCert(t, certName, key)
+	s.put(certName, cert)
+	if key == nil {
+		s.put(privateKeyName(&issued.PublicKey), newTestKeyPEM(t, issued))
+	}
+	return issued
+}
+
+// rotateKey adds a new version of the key and returns its private key
+func (s *azureStub) rotateKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	return key
+}
+
+// secret returns the value of the secret named secretName
+func (s *azureStub) secret(secretName string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.secrets[secretName]
+	return value, ok
+}
+
+func (s *azureStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the first request of a client is the authentication challenge
+	if r.Header.Get("Authorization") == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/00000000-0000-0000-0000-000000000000", resource="https://vault.azure.net"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var body map[string]string
+	if r.Body != nil && r.ContentLength != 0 {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case segments[0] == "secrets" && len(segments) == 1:
+		var value []map[string]interface{}
+		for name := range s.secrets {
+			value = append(value, map[string]interface{}{"id": testAzureVaultURL + "/secrets/" + name, "attributes": map[string]bool{"enabled": true}})
+		}
+		azureStubJSON(w, map[string]interface{}{"value": value})
+
+	case segments[0] == "secrets":
+		name := segments[1]
+		switch r.Method {
+		case http.MethodGet:
+			value, ok := s.secrets[name]
+			if !ok {
+				azureStubError(w, http.StatusNotFound, "SecretNotFound")
+				return
+			}
+			s.reads = append(s.reads, name)
+			azureStubJSON(w, map[string]interface{}{"id": testAzureVaultURL + "/secrets/" + name + "/1", "value": value})
+		case http.MethodPut:
+			s.secrets[name] = body["value"]
+			azureStubJSON(w, map[string]interface{}{"id": testAzureVaultURL + "/secrets/" + name + "/2", "value": body["value"]})
+		case http.MethodDelete:
+			if _, ok := s.secrets[name]; !ok {
+				azureStubError(w, http.StatusNotFound, "SecretNotFound")
+				return
+			}
+			delete(s.secrets, name)
+			azureStubJSON(w, map[string]interface{}{"id": testAzureVaultURL + "/secrets/" + name})
+		}
+
+	case segments[0] == "keys" && len(segments) >= 3:
+		version := len(s.keys)
+		if segments[2] != "" {
+			version, _ = strconv.Atoi(strings.TrimPrefix(segments[2], "v"))
+		}
+		if version < 1 || version > len(s.keys) {
+			azureStubError(w, http.StatusNotFound, "KeyNotFound")
+			return
+		}
+		key := s.keys[version-1]
+		kid := testAzureVaultURL + "/keys/" + segments[1] + "/v" + strconv.Itoa(version)
+
+		if len(segments) == 4 && segments[3] == "sign" {
+			digest, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(body["value"], "="))
+			if err != nil {
+				azureStubError(w, http.StatusBadRequest, "BadParameter")
+				return
+			}
+			r, sig, err := ecdsa.Sign(rand.Reader, key, digest)
+			if err != nil {
+				azureStubError(w, http.StatusInternalServerError, "InternalError")
+				return
+			}
+			s.signVersions = append(s.signVersions, segments[2])
+			raw := append(r.FillBytes(make([]byte, 32)), sig.FillBytes(make([]byte, 32))...)
+			azureStubJSON(w, map[string]interface{}{"kid": kid, "value": base64.RawURLEncoding.EncodeToString(raw)})
+			return
+		}
+
+		azureStubJSON(w, map[string]interface{}{"key": map[string]interface{}{
+			"kid": kid,
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}})
+
+	default:
+		azureStubError(w, http.StatusBadRequest, "BadParameter")
+	}
+}
+
+func azureStubJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func azureStubError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"code": code, "message": code}})
+}
+
+// azureStubTransport sends requests of the Key Vault clients to a handler
+type azureStubTransport struct {
+	handler http.Handler
+}
+
+func (tr azureStubTransport) Do(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	tr.handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// azureStubCredential issues tokens without a tenant
+type azureStubCredential struct{}
+
+func (azureStubCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func newAzureStubManager(t *testing.T, stub *azureStub, opts ...AzureOption) (*AzureKeyVaultManager, error) {
+	t.Helper()
+
+	opts = append([]AzureOption{
+		WithAzureCredential(azureStubCredential{}),
+		WithAzureClientOptions(azcore.ClientOptions{Transport: azureStubTransport{handler: stub}}),
+	}, opts...)
+	m, err := NewAzureKeyVaultManager("Org1MSP", testAzureVaultURL, testVaultCert, opts...)
+	if err == nil {
+		t.Cleanup(func() { _ = m.Close() })
+	}
+	return m, err
+}
+
+func TestAzureKeyVaultManager(t *testing.T) {
+	stub := newAzureStub()
+	key := stub.putIdentity(t, testVaultCert, nil)
+	admin, _, _ := newTestCert(t, "Admin@org1.example.com", false, nil, nil)
+	stub.put("org1/msp/admincerts/Admin@org1.example.com-cert.pem", admin)
+
+	m, err := newAzureStubManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &key.PublicKey)
+	if _, err = m.Cache().GetCrypto("Admin@org1.example.com-cert.pem"); err != nil {
+		t.Error(err)
+	}
+
+	// crypto read before is written back to its secret, new crypto to the secret with the escaped name
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{name: "Admin@org1.example.com-cert.pem", secret: "org1/msp/admincerts/Admin@org1.example.com-cert.pem"},
+		{name: "ca.org3.example.com-cert.pem", secret: "ca.org3.example.com-cert.pem"},
+		{name: "User1@org1.example.com/tls/client.crt", secret: "/User1@org1.example.com/tls/client.crt"},
+	}
+	for _, test := range tests {
+		if err = m.Store(ctx, test.name, []byte(test.name)); err != nil {
+			t.Fatal(err)
+		}
+		if value, _ := stub.secret(encodeAzureSecretName(test.secret)); value != test.name {
+			t.Errorf("%s stored to secret %s as %q", test.name, test.secret, value)
+		}
+		if secretPath, _ := m.backendPath(test.name); secretPath != test.secret {
+			t.Errorf("%s indexed as read from %s, want %s", test.name, secretPath, test.secret)
+		}
+	}
+
+	for _, test := range tests {
+		if err = m.Delete(ctx, test.name); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := stub.secret(encodeAzureSecretName(test.secret)); ok {
+			t.Errorf("secret %s of %s is not deleted", test.secret, test.name)
+		}
+	}
+}
+
+func TestAzureSecretNames(t *testing.T) {
+	for _, name := range []string{"User1@org1.example.com-cert.pem", "/User1@org1.example.com/tls/client.key", "abc_sk"} {
+		secretName := encodeAzureSecretName(name)
+		for _, c := range secretName {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				t.Errorf("secret name %s of %s has character %q", secretName, name, c)
+			}
+		}
+		if decoded, err := decodeAzureSecretName(secretName); err != nil || decoded != name {
+			t.Errorf("secret name %s decoded as %s, %v", secretName, decoded, err)
+		}
+	}
+
+	for _, secretName := range []string{"a-2", "a-zz"} {
+		if _, err := decodeAzureSecretName(secretName); err == nil {
+			t.Errorf("invalid secret name %s decoded", secretName)
+		}
+	}
+}
+
+func TestAzureKeySigning(t *testing.T) {
+	stub := newAzureStub()
+	key := stub.rotateKey(t)
+	stub.putIdentity(t, testVaultCert, key)
+	// a private key left in a secret is not read in key signing mode
+	stub.put(privateKeyName(&key.PublicKey), newTestKeyPEM(t, key))
+
+	m, err := newAzureStubManager(t, stub, WithAzureKeySigning("key", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Cache().GetCrypto(privateKeyName(&key.PublicKey)); err == nil {
+		t.Error("private key is cached")
+	}
+	stub.mu.Lock()
+	for _, name := range stub.reads {
+		if name == encodeAzureSecretName(privateKeyName(&key.PublicKey)) {
+			t.Error("private key is read from Key Vault")
+		}
+	}
+	stub.mu.Unlock()
+	checkSigned(t, m, &key.PublicKey)
+
+	// the certificate still holds version 1 after rotation of the key
+	stub.rotateKey(t)
+	checkSigned(t, m, &key.PublicKey)
+
+	// keys not held by the Key Vault key are rejected, keys with a Signer are signed locally
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	if _, err = m.Sign(digest[:], &CartridgeKey{PubKey: &other.PublicKey}); err == nil || !strings.Contains(err.Error(), "not held by Key Vault key") {
+		t.Errorf("err = %v, want rejection of a key not held by the Key Vault key", err)
+	}
+	signature, err := m.Sign(digest[:], &CartridgeKey{PubKey: &other.PublicKey, Signer: other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&other.PublicKey, digest[:], signature) {
+		t.Error("signature of a local key does not verify")
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.signVersions) != 2 {
+		t.Fatalf("%d sign requests, want 2", len(stub.signVersions))
+	}
+	for i, version := range stub.signVersions {
+		if version != "v1" {
+			t.Errorf("signature %d made with key version %s, want v1", i, version)
+		}
+	}
+}
+
+func TestAzureKeySigningReload(t *testing.T) {
+	stub := newAzureStub()
+	key := stub.rotateKey(t)
+	stub.putIdentity(t, testVaultCert, key)
+
+	m, err := newAzureStubManager(t, stub, WithAzureKeySigning("key", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// a certificate issued for a key Key Vault does not hold is not taken
+	foreign, _ := newTestUserCert(t, testVaultCert, nil)
+	if err = m.Store(ctx, testVaultCert, foreign); err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &key.PublicKey)
+
+	// the certificate of a new version of the key is
+	rotated := stub.rotateKey(t)
+	cert, _ := newTestUserCert(t, testVaultCert, rotated)
+	if err = m.Store(ctx, testVaultCert, cert); err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &rotated.PublicKey)
+}
+
+func TestAzureKeyMismatch(t *testing.T) {
+	stub := newAzureStub()
+	stub.rotateKey(t)
+	stub.putIdentity(t, testVaultCert, nil)
+
+	_, err := newAzureStubManager(t, stub, WithAzureKeySigning("key", ""))
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("err = %v, want key mismatch", err)
+	}
+}
+
+func TestAzureRawSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// JWS signatures may have high S
+	highS := new(big.Int).Sub(key.Curve.Params().N, s)
+	signature, err := rawToLowSDER(&key.PublicKey, append(r.FillBytes(make([]byte, 32)), highS.FillBytes(make([]byte, 32))...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("signature does not verify")
+	}
+	if err = verifyECDSA(context.Background(), digest[:], signature, &key.PublicKey); err != nil {
+		t.Error(err)
+	}
+}
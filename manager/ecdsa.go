@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric/bccsp/utils"
+)
+
+// verifyECDSA verifies low-S DER signature against digest using ecdsaPublicKey
+func verifyECDSA(ctx context.Context, digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r, s, err := utils.UnmarshalECDSASignature(signature)
+	if err != nil {
+		return fmt.Errorf("failed unmashalling signature [%w]", err)
+	}
+
+	lowS, err := utils.IsLowS(ecdsaPublicKey, s)
+	if err != nil {
+		return err
+	}
+
+	if !lowS {
+		return fmt.Errorf("invalid S. Must be smaller than half the order [%s][%s]", s, utils.GetCurveHalfOrdersAt(ecdsaPublicKey.Curve))
+	}
+
+	if ok := ecdsa.Verify(ecdsaPublicKey, digest, r, s); ok {
+		return nil
+	}
+
+	return errors.New("invalid signature")
+}
+
+// rawToLowSDER converts a raw r||s signature, as returned by JWS and PKCS#11 signers, to a low-S DER signature
+func rawToLowSDER(ecdsaPublicKey *ecdsa.PublicKey, raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("invalid raw signature length %d", len(raw))
+	}
+	r := new(big.Int).SetBytes(raw[:len(raw)/2])
+	s := new(big.Int).SetBytes(raw[len(raw)/2:])
+
+	s, err := utils.ToLowS(ecdsaPublicKey, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.MarshalECDSASignature(r, s)
+}
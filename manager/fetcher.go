@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFetchConcurrency = 8
+	defaultFetchRetries     = 3
+	defaultFetchBackoff     = 200 * time.Millisecond
+)
+
+// FetchConfig configures download of crypto from the backend. Zero fields take default values.
+type FetchConfig struct {
+	// Concurrency is the maximum number of parallel requests, 8 by default
+	Concurrency int
+	// Retries is the number of retries of a request failed with a transient error,
+	// 3 by default, negative value disables retries
+	Retries int
+	// Backoff is the delay before the first retry, doubled on every next retry, 200ms by default
+	Backoff time.Duration
+}
+
+func (c FetchConfig) withDefaults() FetchConfig {
+	if c.Concurrency <= 0 {
+		c.Concurrency = defaultFetchConcurrency
+	}
+	if c.Retries == 0 {
+		c.Retries = defaultFetchRetries
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = defaultFetchBackoff
+	}
+	return c
+}
+
+// fetcher runs download jobs on at most Concurrency worker goroutines, jobs wait in a queue for a free worker.
+// The first failed job cancels the others.
+type fetcher struct {
+	cfg       FetchConfig
+	retryable func(error) bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	queue     []func(ctx context.Context) error
+	workers   int
+	wg        sync.WaitGroup
+	errOnce   sync.Once
+	err       error
+}
+
+// newFetcher creates fetcher, retryable reports whether a failed job should be retried
+func newFetcher(ctx context.Context, cfg FetchConfig, retryable func(error) bool) *fetcher {
+	cfg = cfg.withDefaults()
+	ctx, cancel := context.WithCancel(ctx)
+	return &fetcher{
+		cfg:       cfg,
+		retryable: retryable,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Go queues job without blocking and starts a worker if fewer than Concurrency are running.
+// Jobs may schedule other jobs but must not fail after doing so, as failed jobs are retried.
+func (f *fetcher) Go(job func(ctx context.Context) error) {
+	f.wg.Add(1)
+
+	f.mu.Lock()
+	f.queue = append(f.queue, job)
+	start := f.workers < f.cfg.Concurrency
+	if start {
+		f.workers++
+	}
+	f.mu.Unlock()
+
+	if start {
+		go f.work()
+	}
+}
+
+// work runs queued jobs until the queue is empty
+func (f *fetcher) work() {
+	for {
+		f.mu.Lock()
+		if len(f.queue) == 0 {
+			f.workers--
+			f.mu.Unlock()
+			return
+		}
+		job := f.queue[0]
+		f.queue[0] = nil
+		f.queue = f.queue[1:]
+		f.mu.Unlock()
+
+		if err := f.ctx.Err(); err != nil {
+			f.fail(err)
+		} else if err = f.run(job); err != nil {
+			f.fail(err)
+		}
+		f.wg.Done()
+	}
+}
+
+// Wait waits for all jobs and returns the first error
+func (f *fetcher) Wait() error {
+	f.wg.Wait()
+	f.cancel()
+	return f.err
+}
+
+func (f *fetcher) run(job func(ctx context.Context) error) error {
+	backoff := f.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		err := job(f.ctx)
+		if err == nil || f.ctx.Err() != nil || attempt >= f.cfg.Retries || !f.retryable(err) {
+			return err
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (f *fetcher) fail(err error) {
+	f.errOnce.Do(func() {
+		f.err = err
+		f.cancel()
+	})
+}
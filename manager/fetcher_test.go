@@ -0,0 +1,207 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errPermanent = errors.New("permanent")
+)
+
+func isTestTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestFetcherRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		failures int
+		err      error
+		attempts int32
+		wantErr  error
+	}{
+		{name: "recovers", retries: 3, failures: 2, err: errTransient, attempts: 3},
+		{name: "exhausted", retries: 2, failures: 5, err: errTransient, attempts: 3, wantErr: errTransient},
+		{name: "permanent", retries: 3, failures: 5, err: errPermanent, attempts: 1, wantErr: errPermanent},
+		{name: "disabled", retries: -1, failures: 5, err: errTransient, attempts: 1, wantErr: errTransient},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts int32
+			f := newFetcher(context.Background(), FetchConfig{Retries: test.retries, Backoff: time.Millisecond}, isTestTransient)
+			f.Go(func(ctx context.Context) error {
+				if atomic.AddInt32(&attempts, 1) <= int32(test.failures) {
+					return test.err
+				}
+				return nil
+			})
+			if err := f.Wait(); !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Errorf("err = %v, want %v", err, test.wantErr)
+			}
+			if attempts != test.attempts {
+				t.Errorf("%d attempts, want %d", attempts, test.attempts)
+			}
+		})
+	}
+}
+
+func TestFetcherConcurrency(t *testing.T) {
+	const jobs = 20
+
+	var running, maxRunning, done int32
+	f := newFetcher(context.Background(), FetchConfig{Concurrency: 3}, isTestTransient)
+	var job func(depth int) func(ctx context.Context) error
+	job = func(depth int) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&done, 1)
+			// jobs schedule other jobs as listing of directories does
+			if depth == 0 {
+				for i := 0; i < jobs-1; i++ {
+					f.Go(job(depth + 1))
+				}
+			}
+			return nil
+		}
+	}
+	f.Go(job(0))
+	if err := f.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if done != jobs {
+		t.Errorf("%d jobs done, want %d", done, jobs)
+	}
+	if maxRunning > 3 {
+		t.Errorf("%d jobs ran in parallel, want at most 3", maxRunning)
+	}
+}
+
+func TestFetcherFailureCancels(t *testing.T) {
+	f := newFetcher(context.Background(), FetchConfig{Concurrency: 2}, isTestTransient)
+	var completed int32
+	f.Go(func(ctx context.Context) error {
+		return errPermanent
+	})
+	// jobs are either canceled while running or not started at all
+	for i := 0; i < 4; i++ {
+		f.Go(func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				atomic.AddInt32(&completed, 1)
+				return nil
+			}
+		})
+	}
+
+	if err := f.Wait(); !errors.Is(err, errPermanent) {
+		t.Errorf("err = %v, want the first failure", err)
+	}
+	if completed != 0 {
+		t.Errorf("%d jobs completed after the failure", completed)
+	}
+}
+
+func TestFetcherContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := newFetcher(ctx, FetchConfig{Retries: 5, Backoff: time.Hour}, isTestTransient)
+	f.Go(func(ctx context.Context) error {
+		cancel()
+		return errTransient
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- f.Wait() }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errTransient) {
+			t.Errorf("err = %v, want the last failure", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry backoff is not interrupted by the caller context")
+	}
+}
+
+func TestVaultLazyLoadRetries(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+	// TLS crypto is not read by validation of the signing identity at construction
+	for i := 0; i < 3; i++ {
+		stub.put("org1/User1@org1.example.com/tls/ca"+strconv.Itoa(i)+".crt", []byte("ca"))
+	}
+
+	m, err := newStubVaultManager(t, stub, WithLazyLoading(), WithFetchConfig(FetchConfig{Retries: 1, Backoff: time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a transient failure is retried by the fetcher only, not by the Vault client as well
+	stub.fail("kv/data/org1/User1@org1.example.com/tls/ca0.crt", 1)
+	if value, err := m.Cache().GetCrypto("User1@org1.example.com/tls/ca0.crt"); err != nil || string(value) != "ca" {
+		t.Errorf("GetCrypto = %q, %v", value, err)
+	}
+	if n := stub.callCount("kv/data/org1/User1@org1.example.com/tls/ca0.crt"); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+
+	stub.fail("kv/data/org1/User1@org1.example.com/tls/ca1.crt", 5)
+	if _, err = m.Cache().GetCrypto("User1@org1.example.com/tls/ca1.crt"); err == nil {
+		t.Error("crypto loaded after retries are exhausted")
+	}
+	if n := stub.callCount("kv/data/org1/User1@org1.example.com/tls/ca1.crt"); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = cryptocache.GetCryptoContext(ctx, m.Cache(), "User1@org1.example.com/tls/ca2.crt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want the load bounded by the caller context", err)
+	}
+}
+
+func TestVaultLazyLoadConcurrent(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+	stub.put("org1/User1@org1.example.com/tls/ca.crt", []byte("ca"))
+
+	m, err := newStubVaultManager(t, stub, WithLazyLoading())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := m.Cache().GetCrypto("User1@org1.example.com/tls/ca.crt"); err != nil || string(value) != "ca" {
+				t.Errorf("GetCrypto = %q, %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := stub.callCount("kv/data/org1/User1@org1.example.com/tls/ca.crt"); n != 1 {
+		t.Errorf("%d requests for concurrent misses, want 1", n)
+	}
+}
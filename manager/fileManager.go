@@ -0,0 +1,237 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/sirupsen/logrus"
+)
+
+const keystoreDir = "keystore"
+
+// cryptoExtensions are extensions of files loaded outside of keystore directories
+var cryptoExtensions = map[string]bool{".pem": true, ".crt": true, ".key": true}
+
+// FileOption is a function that configures a FileManager
+type FileOption func(fm *FileManager) error
+
+// FileManager handles crypto stored on the local filesystem, e.g. for development and air-gapped deployments
+type FileManager struct {
+	validationConfig
+
+	memcache        cryptocache.CryptoCache
+	signingIdentity *VaultSigningIdentity
+	unwatch         func()
+	closeOnce       sync.Once
+	cryptoPath      string
+	paths           map[string]string // crypto names to the files they were read from
+	pathsMu         sync.RWMutex
+}
+
+// NewFileManager gets new instance of FileManager
+// cryptoPath is a Fabric MSP directory (signcerts, keystore, cacerts, tlscacerts, tls) or a cryptogen
+// output tree. Crypto is cached under the same names as by VaultManager: private keys from keystore
+// directories as <ski>_sk, files of tls directories as <parent>/tls/<name>, other files by their names.
+func NewFileManager(mspID, userCert, cryptoPath string, opts ...FileOption) (*FileManager, error) {
+	manager := &FileManager{
+		memcache:   cryptocache.NewMemCache(),
+		cryptoPath: cryptoPath,
+		paths:      make(map[string]string),
+	}
+	for _, opt := range opts {
+		if err := opt(manager); err != nil {
+			return nil, err
+		}
+	}
+
+	t := time.Now()
+	if err := manager.loadCrypto(cryptoPath); err != nil {
+		return nil, err
+	}
+	logrus.Infof("loading of cryptomaterials took %.2f seconds", time.Since(t).Seconds())
+
+	var err error
+	manager.signingIdentity, err = NewVaultSigningIdentity(mspID, userCert, manager)
+	if err != nil {
+		return nil, err
+	}
+
+	if notifier, ok := manager.memcache.(cryptocache.Notifier); ok {
+		manager.unwatch = manager.signingIdentity.Watch(notifier)
+	}
+
+	return manager, nil
+}
+
+// loadCrypto puts crypto files under cryptoPath to the cache
+func (fm *FileManager) loadCrypto(cryptoPath string) error {
+	return filepath.WalkDir(cryptoPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		slashPath := filepath.ToSlash(filePath)
+		inKeystore, ok := isCryptoFile(slashPath)
+		if !ok {
+			return nil
+		}
+
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+
+		name := fileCryptoName(slashPath, inKeystore, data)
+		fm.pathsMu.Lock()
+		if old, ok := fm.paths[name]; !ok || !keepPath(filepath.ToSlash(old), slashPath) {
+			fm.paths[name] = filePath
+		}
+		fm.pathsMu.Unlock()
+
+		return fm.memcache.SetCrypto(name, data)
+	})
+}
+
+// Store writes value to the file crypto with name was read from and puts it to the cache.
+// New private keys are written to the keystore directory under cryptoPath, other new crypto
+// under cryptoPath, TLS crypto named like user/tls/name to cryptoPath/user/tls/name.
+func (fm *FileManager) Store(_ context.Context, name string, value []byte) error {
+	fm.pathsMu.RLock()
+	filePath, ok := fm.paths[name]
+	fm.pathsMu.RUnlock()
+	if !ok {
+		filePath = filepath.Join(fm.cryptoPath, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "_sk") {
+			filePath = filepath.Join(fm.cryptoPath, keystoreDir, name)
+		}
+	}
+
+	if err := checkStoredName(name, filePath, func(filePath string) string {
+		slashPath := filepath.ToSlash(filePath)
+		inKeystore, ok := isCryptoFile(slashPath)
+		if !ok {
+			return ""
+		}
+		return fileCryptoName(slashPath, inKeystore, value)
+	}); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o700); err != nil {
+		return fmt.Errorf("failed to store %s: %w", name, err)
+	}
+	if err := os.WriteFile(filePath, value, 0o600); err != nil {
+		return fmt.Errorf("failed to store %s: %w", name, err)
+	}
+
+	fm.pathsMu.Lock()
+	fm.paths[name] = filePath
+	fm.pathsMu.Unlock()
+
+	return fm.memcache.SetCrypto(name, value)
+}
+
+// backendPath returns the file crypto with name was read from
+func (fm *FileManager) backendPath(name string) (string, bool) {
+	fm.pathsMu.RLock()
+	defer fm.pathsMu.RUnlock()
+	filePath, ok := fm.paths[name]
+	return filepath.ToSlash(filePath), ok
+}
+
+// Delete removes the file crypto with name was read from and removes the crypto from the cache
+func (fm *FileManager) Delete(_ context.Context, name string) error {
+	fm.pathsMu.Lock()
+	filePath, ok := fm.paths[name]
+	delete(fm.paths, name)
+	fm.pathsMu.Unlock()
+
+	if ok {
+		if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+	}
+
+	deleteCached(fm.memcache, name)
+	return nil
+}
+
+// isCryptoFile reports whether the file at slash separated filePath is loaded and whether it is in a keystore directory
+func isCryptoFile(filePath string) (inKeystore bool, ok bool) {
+	inKeystore = path.Base(path.Dir(filePath)) == keystoreDir
+	return inKeystore, inKeystore || cryptoExtensions[path.Ext(filePath)]
+}
+
+// fileCryptoName returns the name under which the file at slash separated filePath is cached
+func fileCryptoName(filePath string, inKeystore bool, data []byte) string {
+	if inKeystore {
+		// cryptogen names keys priv_sk, the name is derived from the key itself
+		if key, err := parsePrivateKey(data); err == nil {
+			return privateKeyName(&key.PublicKey)
+		}
+	}
+	if strings.Contains(filePath, "/tls/") {
+		return cryptoName(filePath, path.Base(filePath))
+	}
+	return path.Base(filePath)
+}
+
+// Sign signs digest with the Signer of key
+func (fm *FileManager) Sign(digest []byte, key *CartridgeKey) ([]byte, error) {
+	return fm.SignContext(context.Background(), digest, key)
+}
+
+// SignContext is Sign with cancellation by ctx
+func (fm *FileManager) SignContext(ctx context.Context, digest []byte, key *CartridgeKey) ([]byte, error) {
+	return signWithKey(ctx, digest, key)
+}
+
+// Verify verifies signature against digest using ecdsaPublicKey
+func (fm *FileManager) Verify(digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return fm.VerifyContext(context.Background(), digest, signature, ecdsaPublicKey)
+}
+
+// VerifyContext is Verify with cancellation by ctx
+func (fm *FileManager) VerifyContext(ctx context.Context, digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return verifyECDSA(ctx, digest, signature, ecdsaPublicKey)
+}
+
+// SigningIdentity returns signing identity
+func (fm *FileManager) SigningIdentity() CartridgeSigningIdentity {
+	return fm.signingIdentity
+}
+
+// Cache returns cache
+func (fm *FileManager) Cache() cryptocache.CryptoCache {
+	return fm.memcache
+}
+
+// Close wipes cached crypto and the private key of the signing identity.
+// The manager must not be used after Close.
+func (fm *FileManager) Close() error {
+	fm.closeOnce.Do(func() {
+		if fm.unwatch != nil {
+			fm.unwatch()
+		}
+		if clearer, ok := fm.memcache.(cryptocache.Clearer); ok {
+			clearer.Clear()
+		}
+		if fm.signingIdentity != nil {
+			fm.signingIdentity.wipe()
+		}
+	})
+	return nil
+}
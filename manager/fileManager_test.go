@@ -0,0 +1,193 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+)
+
+const testUserDir = "org1.example.com/users/User1@org1.example.com/"
+
+func TestFileManagerCryptogenLayout(t *testing.T) {
+	org1 := newTestCA(t, "ca.org1.example.com", nil)
+	tlsca := newTestCA(t, "tlsca.org1.example.com", nil)
+	cert, _, key := newTestCert(t, "User1@org1.example.com", false, org1.crt, org1.key)
+	tlsCert, _, tlsKey := newTestCert(t, "User1@org1.example.com", false, tlsca.crt, tlsca.key)
+	serverCert, _, _ := newTestCert(t, "peer0.org1.example.com", false, tlsca.crt, tlsca.key)
+
+	dir := t.TempDir()
+	testMSP(t, dir, map[string][]byte{
+		"org1.example.com/ca/ca.org1.example.com-cert.pem":             org1.pem,
+		"org1.example.com/ca/priv_sk":                                  newTestKeyPEM(t, org1.key),
+		"org1.example.com/tlsca/tlsca.org1.example.com-cert.pem":       tlsca.pem,
+		"org1.example.com/msp/cacerts/ca.org1.example.com-cert.pem":    org1.pem,
+		testUserMSP + "signcerts/User1@org1.example.com-cert.pem":      cert,
+		testUserMSP + "keystore/priv_sk":                               newTestKeyPEM(t, key),
+		testUserMSP + "cacerts/ca.org1.example.com-cert.pem":           org1.pem,
+		testUserMSP + "tlscacerts/tlsca.org1.example.com-cert.pem":     tlsca.pem,
+		testUserMSP + "config.yaml":                                    []byte("NodeOUs:\n  Enable: true\n"),
+		testUserDir + "tls/ca.crt":                                     tlsca.pem,
+		testUserDir + "tls/client.crt":                                 tlsCert,
+		testUserDir + "tls/client.key":                                 newTestKeyPEM(t, tlsKey),
+		"org1.example.com/peers/peer0.org1.example.com/tls/server.crt": serverCert,
+	})
+
+	m, err := NewFileManager("Org1MSP", "User1@org1.example.com-cert.pem", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	checkSigned(t, m, &key.PublicKey)
+
+	// keys outside of keystore directories and files of other types are not loaded
+	want := sorted(
+		"User1@org1.example.com-cert.pem",
+		privateKeyName(&key.PublicKey),
+		"ca.org1.example.com-cert.pem",
+		"tlsca.org1.example.com-cert.pem",
+		"User1@org1.example.com/tls/ca.crt",
+		"User1@org1.example.com/tls/client.crt",
+		"User1@org1.example.com/tls/client.key",
+		"peer0.org1.example.com/tls/server.crt",
+	)
+	if keys := sorted(m.Cache().(cryptocache.Lister).Keys()...); !reflect.DeepEqual(keys, want) {
+		t.Errorf("cached %v, want %v", keys, want)
+	}
+
+	// the CA certificate read from several directories keeps the path in an MSP CA directory
+	if caPath, _ := m.backendPath("ca.org1.example.com-cert.pem"); dirName(caPath) != "cacerts" {
+		t.Errorf("CA certificate indexed as read from %s", caPath)
+	}
+}
+
+func TestFileManagerMSPLayout(t *testing.T) {
+	// fabric-ca-client names files cert.pem and <ski>_sk
+	ca := newTestCA(t, "ca.org1.example.com", nil)
+	cert, _, key := newTestCert(t, "User1", false, ca.crt, ca.key)
+	dir := t.TempDir()
+	testMSP(t, dir, map[string][]byte{
+		"signcerts/cert.pem":           cert,
+		"keystore/0123456789abcdef_sk": newTestKeyPEM(t, key),
+		"keystore/ignored":             []byte("not a key"),
+		"cacerts/localhost-7054.pem":   ca.pem,
+		"IssuerPublicKey":              []byte("idemix"),
+	})
+
+	m, err := NewFileManager("Org1MSP", "cert.pem", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	checkSigned(t, m, &key.PublicKey)
+
+	// the private key is cached under the SKI of the key rather than the file name
+	if _, err = m.Cache().GetCrypto(privateKeyName(&key.PublicKey)); err != nil {
+		t.Error(err)
+	}
+	if _, err = m.Cache().GetCrypto("0123456789abcdef_sk"); err == nil {
+		t.Error("private key cached under its file name")
+	}
+	// keystore files which are not keys are cached by their names
+	if _, err = m.Cache().GetCrypto("ignored"); err != nil {
+		t.Error(err)
+	}
+	if _, err = m.Cache().GetCrypto("IssuerPublicKey"); err == nil {
+		t.Error("file without a crypto extension is cached")
+	}
+}
+
+func TestFileManagerMissingIdentity(t *testing.T) {
+	ca := newTestCA(t, "ca.org1.example.com", nil)
+	cert, _, _ := newTestCert(t, "User1", false, ca.crt, ca.key)
+	dir := t.TempDir()
+	testMSP(t, dir, map[string][]byte{"signcerts/cert.pem": cert})
+
+	if _, err := NewFileManager("Org1MSP", "cert.pem", dir); err == nil {
+		t.Error("manager created without the private key")
+	}
+	if _, err := NewFileManager("Org1MSP", "other.pem", dir); err == nil {
+		t.Error("manager created without the certificate")
+	}
+	if _, err := NewFileManager("Org1MSP", "cert.pem", filepath.Join(dir, "missing")); err == nil {
+		t.Error("manager created from a missing directory")
+	}
+}
+
+func TestFileManagerStore(t *testing.T) {
+	ca := newTestCA(t, "ca.org1.example.com", nil)
+	cert, _, key := newTestCert(t, "User1@org1.example.com", false, ca.crt, ca.key)
+	dir := t.TempDir()
+	testMSP(t, dir, map[string][]byte{
+		testUserMSP + "signcerts/User1@org1.example.com-cert.pem": cert,
+		testUserMSP + "keystore/priv_sk":                          newTestKeyPEM(t, key),
+	})
+
+	m, err := NewFileManager("Org1MSP", "User1@org1.example.com-cert.pem", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+
+	next, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCert, _, _ := newTestCert(t, "User1@org1.example.com", false, ca.crt, ca.key)
+	tests := []struct {
+		name  string
+		value []byte
+		file  string
+	}{
+		{name: "User1@org1.example.com-cert.pem", value: cert, file: testUserMSP + "signcerts/User1@org1.example.com-cert.pem"},
+		{name: privateKeyName(&next.PublicKey), value: newTestKeyPEM(t, next), file: "keystore/" + privateKeyName(&next.PublicKey)},
+		{name: "ca.org2.example.com-cert.pem", value: ca.pem, file: "ca.org2.example.com-cert.pem"},
+		{name: "User2@org1.example.com/tls/client.crt", value: tlsCert, file: "User2@org1.example.com/tls/client.crt"},
+	}
+	for _, test := range tests {
+		if err = m.Store(ctx, test.name, test.value); err != nil {
+			t.Fatalf("store %s: %s", test.name, err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(test.file)))
+		if err != nil {
+			t.Fatalf("store %s: %s", test.name, err)
+		}
+		if string(data) != string(test.value) {
+			t.Errorf("stored %s to %s with other content", test.name, test.file)
+		}
+	}
+
+	// a private key is read back under the SKI of the key
+	if err = m.Store(ctx, "other_sk", newTestKeyPEM(t, next)); err == nil {
+		t.Error("private key stored under a name it is not read back as")
+	}
+
+	// stored crypto is read back under the same names
+	reopened, err := NewFileManager("Org1MSP", "User1@org1.example.com-cert.pem", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for _, test := range tests {
+		if _, err = reopened.Cache().GetCrypto(test.name); err != nil {
+			t.Errorf("%s is not read back: %s", test.name, err)
+		}
+	}
+
+	if err = m.Delete(ctx, "ca.org2.example.com-cert.pem"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "ca.org2.example.com-cert.pem")); !os.IsNotExist(err) {
+		t.Errorf("deleted crypto is left on disk: %v", err)
+	}
+	if _, err = m.Cache().GetCrypto("ca.org2.example.com-cert.pem"); err == nil {
+		t.Error("deleted crypto is left in the cache")
+	}
+}
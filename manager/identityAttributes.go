@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Fabric CA attribute names
+const (
+	AttrEnrollmentID = "hf.EnrollmentID"
+	AttrType         = "hf.Type"
+	AttrAffiliation  = "hf.Affiliation"
+)
+
+// attrsOID is the OID of the certificate extension Fabric CA puts attributes in
+var attrsOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// IDSource returns the ID of an identity from its certificate
+type IDSource func(cert *x509.Certificate) (string, error)
+
+// IDFromCommonName takes the ID from the common name of the certificate, the enrollment ID
+// for Fabric CA and e.g. User1@org1.example.com for cryptogen. It is the default.
+func IDFromCommonName(cert *x509.Certificate) (string, error) {
+	if cert.Subject.CommonName == "" {
+		return "", fmt.Errorf("certificate %s has no common name", cert.SerialNumber)
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// IDFromAttribute takes the ID from the Fabric CA attribute name, e.g. hf.EnrollmentID or a custom attribute
+func IDFromAttribute(name string) IDSource {
+	return func(cert *x509.Certificate) (string, error) {
+		attrs, err := certificateAttributes(cert)
+		if err != nil {
+			return "", err
+		}
+		value, ok := attrs[name]
+		if !ok {
+			return "", fmt.Errorf("certificate %s has no attribute %s", cert.SerialNumber, name)
+		}
+		return value, nil
+	}
+}
+
+// IDFromOU takes the ID from the first organizational unit starting with prefix, the prefix is trimmed.
+// E.g. with prefix "tenant." the OU tenant.acme gives the ID acme.
+func IDFromOU(prefix string) IDSource {
+	return func(cert *x509.Certificate) (string, error) {
+		for _, ou := range cert.Subject.OrganizationalUnit {
+			if strings.HasPrefix(ou, prefix) {
+				return strings.TrimPrefix(ou, prefix), nil
+			}
+		}
+		return "", fmt.Errorf("certificate %s has no organizational unit with prefix %s", cert.SerialNumber, prefix)
+	}
+}
+
+// certificateAttributes returns Fabric CA attributes of cert, empty if the certificate has none
+func certificateAttributes(cert *x509.Certificate) (map[string]string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(attrsOID) {
+			continue
+		}
+		var attrs struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		if err := json.Unmarshal(ext.Value, &attrs); err != nil {
+			return nil, fmt.Errorf("failed to parse attributes of certificate %s: %w", cert.SerialNumber, err)
+		}
+		if attrs.Attrs == nil {
+			break
+		}
+		return attrs.Attrs, nil
+	}
+	return map[string]string{}, nil
+}
+
+// id returns the ID of the identity taken from its certificate by IDSource, the MSP ID if it cannot be taken
+func (m *VaultIdentity) id() string {
+	cert, err := m.Certificate()
+	if err != nil {
+		return m.MSPID
+	}
+	source := m.IDSource
+	if source == nil {
+		source = IDFromCommonName
+	}
+	id, err := source(cert)
+	if err != nil || id == "" {
+		return m.MSPID
+	}
+	return id
+}
+
+// Certificate returns the parsed enrollment certificate
+func (m *VaultIdentity) Certificate() (*x509.Certificate, error) {
+	return DecodeCertificate(m.IDBytes)
+}
+
+// Expiry returns the end of the validity period of the enrollment certificate
+func (m *VaultIdentity) Expiry() (time.Time, error) {
+	cert, err := m.Certificate()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// OUs returns organizational units of the enrollment certificate, e.g. client or admin with NodeOUs
+func (m *VaultIdentity) OUs() ([]string, error) {
+	cert, err := m.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	return cert.Subject.OrganizationalUnit, nil
+}
+
+// Attributes returns Fabric CA attributes of the enrollment certificate, e.g. hf.EnrollmentID, hf.Type
+// and custom attributes requested at enrollment
+func (m *VaultIdentity) Attributes() (map[string]string, error) {
+	cert, err := m.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	return certificateAttributes(cert)
+}
+
+// Attribute returns the Fabric CA attribute name of the enrollment certificate, ok is false if there is none
+func (m *VaultIdentity) Attribute(name string) (value string, ok bool, err error) {
+	attrs, err := m.Attributes()
+	if err != nil {
+		return "", false, err
+	}
+	value, ok = attrs[name]
+	return value, ok, nil
+}
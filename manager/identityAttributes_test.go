@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newAttrCert returns a self-signed PEM certificate with common name cn, organizational units ous
+// and, if attrs is not nil, the Fabric CA attributes extension with value attrs
+func newAttrCert(t *testing.T, cn string, ous []string, attrs []byte) ([]byte, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: ous},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour).Truncate(time.Second),
+	}
+	if attrs != nil {
+		template.ExtraExtensions = []pkix.Extension{{Id: attrsOID, Value: attrs}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), crt
+}
+
+func TestCertificateAttributes(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs []byte
+		want  map[string]string
+		err   bool
+	}{
+		{name: "no extension", want: map[string]string{}},
+		{
+			name:  "attributes",
+			attrs: []byte(`{"attrs":{"hf.Affiliation":"org1.department1","hf.EnrollmentID":"user1","hf.Type":"client","role":"auditor"}}`),
+			want:  map[string]string{AttrAffiliation: "org1.department1", AttrEnrollmentID: "user1", AttrType: "client", "role": "auditor"},
+		},
+		{name: "no attributes", attrs: []byte(`{}`), want: map[string]string{}},
+		{name: "null attributes", attrs: []byte(`{"attrs":null}`), want: map[string]string{}},
+		{name: "invalid JSON", attrs: []byte(`{"attrs":`), err: true},
+		{name: "invalid type", attrs: []byte(`{"attrs":{"hf.Type":1}}`), err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, crt := newAttrCert(t, "user1", nil, test.attrs)
+			attrs, err := certificateAttributes(crt)
+			if test.err {
+				if err == nil {
+					t.Errorf("attributes %v parsed, want error", attrs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(attrs, test.want) {
+				t.Errorf("attributes %v, want %v", attrs, test.want)
+			}
+		})
+	}
+}
+
+func TestIDSources(t *testing.T) {
+	_, crt := newAttrCert(t, "User1@org1.example.com", []string{"client", "tenant.acme"}, []byte(`{"attrs":{"hf.EnrollmentID":"user1"}}`))
+	_, noCN := newAttrCert(t, "", nil, nil)
+
+	tests := []struct {
+		name   string
+		source IDSource
+		cert   *x509.Certificate
+		want   string
+		err    bool
+	}{
+		{name: "common name", source: IDFromCommonName, cert: crt, want: "User1@org1.example.com"},
+		{name: "no common name", source: IDFromCommonName, cert: noCN, err: true},
+		{name: "attribute", source: IDFromAttribute(AttrEnrollmentID), cert: crt, want: "user1"},
+		{name: "missing attribute", source: IDFromAttribute(AttrType), cert: crt, err: true},
+		{name: "no attributes", source: IDFromAttribute(AttrEnrollmentID), cert: noCN, err: true},
+		{name: "OU", source: IDFromOU("tenant."), cert: crt, want: "acme"},
+		{name: "missing OU", source: IDFromOU("dept."), cert: crt, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := test.source(test.cert)
+			if test.err {
+				if err == nil {
+					t.Errorf("ID %s taken, want error", id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != test.want {
+				t.Errorf("ID %s, want %s", id, test.want)
+			}
+		})
+	}
+}
+
+func TestVaultIdentityAttributes(t *testing.T) {
+	cert, crt := newAttrCert(t, "User1@org1.example.com", []string{"client"}, []byte(`{"attrs":{"hf.EnrollmentID":"user1","hf.Type":"client"}}`))
+	identity := &VaultIdentity{MSPID: "Org1MSP", IDBytes: cert}
+
+	if value, ok, err := identity.Attribute(AttrType); err != nil || !ok || value != "client" {
+		t.Errorf("attribute %s = %q, %t, %v, want client", AttrType, value, ok, err)
+	}
+	if _, ok, err := identity.Attribute(AttrAffiliation); err != nil || ok {
+		t.Errorf("missing attribute %s found: %v", AttrAffiliation, err)
+	}
+	if ous, err := identity.OUs(); err != nil || !reflect.DeepEqual(ous, []string{"client"}) {
+		t.Errorf("OUs %v, %v, want [client]", ous, err)
+	}
+	if expiry, err := identity.Expiry(); err != nil || !expiry.Equal(crt.NotAfter) {
+		t.Errorf("expiry %s, %v, want %s", expiry, err, crt.NotAfter)
+	}
+
+	// the ID is taken by IDSource, the MSP ID is used if it cannot be taken
+	tests := []struct {
+		source IDSource
+		want   string
+	}{
+		{source: nil, want: "User1@org1.example.com"},
+		{source: IDFromAttribute(AttrEnrollmentID), want: "user1"},
+		{source: IDFromAttribute(AttrAffiliation), want: "Org1MSP"},
+	}
+	for _, test := range tests {
+		identity.IDSource = test.source
+		if id := identity.Identifier().ID; id != test.want {
+			t.Errorf("ID %s, want %s", id, test.want)
+		}
+	}
+
+	invalid := &VaultIdentity{MSPID: "Org1MSP", IDBytes: []byte("not a certificate")}
+	if _, err := invalid.Attributes(); err == nil {
+		t.Error("attributes of an invalid certificate parsed")
+	}
+	if id := invalid.Identifier().ID; id != "Org1MSP" {
+		t.Errorf("ID of an invalid certificate %s, want the MSP ID", id)
+	}
+}
@@ -0,0 +1,201 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+)
+
+// IdentityRegistry gives access to the signing identities of all users of the org whose crypto
+// is in the cache of a manager, e.g. to sign on behalf of several users with one manager.
+type IdentityRegistry struct {
+	manager    Manager
+	mspID      string
+	mu         sync.Mutex // guards identities and unwatch, not held while crypto is loaded
+	identities map[string]CartridgeSigningIdentity
+	unwatch    []func()
+}
+
+// NewIdentityRegistry returns the registry of identities of the MSP of the manager signing identity
+func NewIdentityRegistry(manager Manager) *IdentityRegistry {
+	return &IdentityRegistry{
+		manager:    manager,
+		mspID:      manager.SigningIdentity().Identifier().MSPID,
+		identities: make(map[string]CartridgeSigningIdentity),
+	}
+}
+
+// Names returns sorted names of certificates that have their private key in the cache,
+// for managers signing remotely names of all user certificates. The certificate of the manager signing identity is always listed. In lazy mode only crypto
+// loaded so far is listed, while Identity loads any.
+func (r *IdentityRegistry) Names() []string {
+	names := make(map[string]struct{})
+	if certName := r.ownCertName(); certName != "" {
+		names[certName] = struct{}{}
+	}
+
+	for name := range r.certificates() {
+		names[name] = struct{}{}
+	}
+
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Identity returns the signing identity with certificate name, e.g. User1@org1.example.com-cert.pem,
+// or with enrollment ID name, the common name of the certificate.
+// For managers signing remotely the backend must hold the key of the certificate.
+// Identities are reloaded when their crypto changes in the cache.
+func (r *IdentityRegistry) Identity(name string) (CartridgeSigningIdentity, error) {
+	r.mu.Lock()
+	identity, ok := r.identities[name]
+	r.mu.Unlock()
+	if ok {
+		return identity, nil
+	}
+
+	// crypto is loaded without the lock, a lazy cache or a remote backend may take long
+	certName, err := r.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var vaultIdentity *VaultSigningIdentity
+	if certName == r.ownCertName() {
+		identity = r.manager.SigningIdentity()
+	} else {
+		if vaultIdentity, err = r.load(certName); err != nil {
+			return nil, err
+		}
+		identity = vaultIdentity
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// another call may have loaded the identity meanwhile
+	if loaded, ok := r.identities[certName]; ok {
+		r.identities[name] = loaded
+		return loaded, nil
+	}
+	if notifier, ok := r.manager.Cache().(cryptocache.Notifier); ok && vaultIdentity != nil {
+		r.unwatch = append(r.unwatch, vaultIdentity.Watch(notifier))
+	}
+	r.identities[name] = identity
+	r.identities[certName] = identity
+	return identity, nil
+}
+
+// load returns the signing identity with certificate certName. If the manager signs remotely,
+// the identity signs by the backend, or by the Signer of the manager signing identity, e.g. a KMS key,
+// and the backend is checked to hold the key of the certificate.
+func (r *IdentityRegistry) load(certName string) (*VaultSigningIdentity, error) {
+	own, ok := r.manager.SigningIdentity().(*VaultSigningIdentity)
+	if !ok || !own.remote {
+		return NewVaultSigningIdentity(r.mspID, certName, r.manager)
+	}
+
+	identity, err := NewVaultSigningIdentityFromCert(r.mspID, certName, r.manager)
+	if err != nil {
+		return nil, err
+	}
+	identity.Key.Signer = own.identity().Key.Signer
+	if err = checkRemoteKey(context.Background(), identity.VaultIdentity, identity.Key.PubKey); err != nil {
+		return nil, fmt.Errorf("failed to load identity %s: %w", certName, err)
+	}
+	return identity, nil
+}
+
+// Close stops reloading of identities returned by Identity
+func (r *IdentityRegistry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, unwatch := range r.unwatch {
+		unwatch()
+	}
+	r.unwatch = nil
+	r.identities = make(map[string]CartridgeSigningIdentity)
+}
+
+// resolve returns the certificate name of the identity with certificate name or enrollment ID name
+func (r *IdentityRegistry) resolve(name string) (string, error) {
+	if name == r.ownCertName() {
+		return name, nil
+	}
+	if cert, err := r.manager.Cache().GetCrypto(name); err == nil {
+		if _, err = parseCertificate(cert); err == nil {
+			return name, nil
+		}
+	}
+
+	if ownCert, err := DecodeCertificate(r.manager.SigningIdentity().EnrollmentCertificate()); err == nil && ownCert.Subject.CommonName == name {
+		return r.ownCertName(), nil
+	}
+	for certName, enrollmentID := range r.certificates() {
+		if enrollmentID == name {
+			return certName, nil
+		}
+	}
+
+	return "", fmt.Errorf("identity %s not found", name)
+}
+
+// certificates maps names of user certificates with private keys in the cache to their common names.
+// Private keys of managers signing remotely are not in the cache, all user certificates are mapped.
+func (r *IdentityRegistry) certificates() map[string]string {
+	certs := make(map[string]string)
+	lister, ok := r.manager.Cache().(cryptocache.Lister)
+	if !ok {
+		return certs
+	}
+
+	own, _ := r.manager.SigningIdentity().(*VaultSigningIdentity)
+	remote := own != nil && own.remote
+
+	keys := lister.Keys()
+	available := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		available[key] = struct{}{}
+	}
+
+	for _, key := range keys {
+		// TLS crypto is cached under paths, private keys under <ski>_sk
+		if strings.Contains(key, "/") || strings.HasSuffix(key, "_sk") {
+			continue
+		}
+		data, err := r.manager.Cache().GetCrypto(key)
+		if err != nil {
+			continue
+		}
+		cert, err := DecodeCertificate(data)
+		if err != nil || cert.IsCA {
+			continue
+		}
+		ecdsaPubKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if _, ok = available[privateKeyName(ecdsaPubKey)]; ok || remote {
+			certs[key] = cert.Subject.CommonName
+		}
+	}
+
+	return certs
+}
+
+// ownCertName returns the certificate name of the manager signing identity
+func (r *IdentityRegistry) ownCertName() string {
+	if identity, ok := r.manager.SigningIdentity().(*VaultSigningIdentity); ok {
+		return identity.certName
+	}
+	return ""
+}
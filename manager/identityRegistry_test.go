@@ -0,0 +1,232 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+)
+
+const testVaultCert2 = "User2@org1.example.com-cert.pem"
+
+// checkIdentitySigned verifies that a signature made by identity verifies against pub
+func checkIdentitySigned(t *testing.T, identity CartridgeSigningIdentity, pub *ecdsa.PublicKey) {
+	t.Helper()
+
+	msg := []byte("message")
+	signature, err := identity.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(msg)
+	if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+		t.Error("signature does not verify against the certificate key")
+	}
+}
+
+func TestIdentityRegistry(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+	key := stub.putIdentity(t, "org1", testVaultCert2, nil)
+	// a certificate without its private key is not listed
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.putIdentity(t, "org1", "User3@org1.example.com-cert.pem", other)
+
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewIdentityRegistry(m)
+	defer r.Close()
+
+	if names, want := r.Names(), []string{testVaultCert, testVaultCert2}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names %v, want %v", names, want)
+	}
+
+	identity, err := r.Identity(testVaultCert2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIdentitySigned(t, identity, &key.PublicKey)
+	if byID, err := r.Identity("User2@org1.example.com"); err != nil || byID != identity {
+		t.Errorf("identity by enrollment ID is another identity: %v", err)
+	}
+	if own, err := r.Identity(testVaultCert); err != nil || own != m.SigningIdentity() {
+		t.Errorf("identity of the manager is another identity: %v", err)
+	}
+	if _, err = r.Identity("User3@org1.example.com-cert.pem"); err == nil {
+		t.Error("identity loaded without its private key")
+	}
+	if _, err = r.Identity("User4"); err == nil {
+		t.Error("unknown identity loaded")
+	}
+}
+
+func TestIdentityRegistryTransit(t *testing.T) {
+	stub, _ := newTransitStub(t)
+	rotated := stub.rotateTransit(t)
+	stub.putIdentity(t, "org1", testVaultCert2, rotated)
+	foreign, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.putIdentity(t, "org1", "User3@org1.example.com-cert.pem", foreign)
+
+	m, err := newStubVaultManager(t, stub, WithTransitSigning("transit", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewIdentityRegistry(m)
+	defer r.Close()
+
+	// private keys are held by the transit key, the certificates are listed without them
+	want := []string{testVaultCert, testVaultCert2, "User3@org1.example.com-cert.pem"}
+	if names := r.Names(); !reflect.DeepEqual(names, want) {
+		t.Errorf("names %v, want %v", names, want)
+	}
+
+	identity, err := r.Identity("User2@org1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIdentitySigned(t, identity, &rotated.PublicKey)
+
+	if _, err = r.Identity("User3@org1.example.com-cert.pem"); err == nil {
+		t.Error("identity loaded for a key the transit key does not hold")
+	}
+}
+
+func TestIdentityRegistryRemoteSigner(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	key := stub.putIdentity(t, "org1", testVaultCert, nil)
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. SecretManager signing with a KMS key: the Signer signs only for its own key
+	own, err := NewVaultSigningIdentityFromCert("Org1MSP", testVaultCert, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	own.Key.Signer = key
+	m.signingIdentity = own
+
+	reissued, _ := newTestUserCert(t, testVaultCert, key)
+	setCrypto(t, m.Cache(), "User1@org1.example.com-reissued.pem", reissued)
+	cert, _ := newTestUserCert(t, testVaultCert2, nil)
+	setCrypto(t, m.Cache(), testVaultCert2, cert)
+
+	r := NewIdentityRegistry(m)
+	defer r.Close()
+	identity, err := r.Identity("User1@org1.example.com-reissued.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIdentitySigned(t, identity, &key.PublicKey)
+
+	if _, err = r.Identity(testVaultCert2); err == nil {
+		t.Error("identity loaded for a key the Signer does not hold")
+	}
+}
+
+// blockingCache blocks the first read of key until release is closed
+type blockingCache struct {
+	*cryptocache.MemCache
+	key     string
+	once    sync.Once
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingCache) GetCrypto(key string) ([]byte, error) {
+	if key == c.key {
+		c.once.Do(func() {
+			close(c.blocked)
+			<-c.release
+		})
+	}
+	return c.MemCache.GetCrypto(key)
+}
+
+// cacheManager is a manager with another cache
+type cacheManager struct {
+	*VaultManager
+	cache cryptocache.CryptoCache
+}
+
+func (m *cacheManager) Cache() cryptocache.CryptoCache {
+	return m.cache
+}
+
+func TestIdentityRegistryConcurrentLoad(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+	key := stub.putIdentity(t, "org1", testVaultCert2, nil)
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &blockingCache{
+		MemCache: m.Cache().(*cryptocache.MemCache),
+		key:      testVaultCert2,
+		blocked:  make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	r := NewIdentityRegistry(&cacheManager{VaultManager: m, cache: cache})
+	defer r.Close()
+
+	const n = 5
+	identities := make([]CartridgeSigningIdentity, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			identities[i], errs[i] = r.Identity(testVaultCert2)
+		}(i)
+	}
+
+	// identities are returned while another one is loaded
+	<-cache.blocked
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = r.Identity(testVaultCert)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("identity is not returned while another one is loaded")
+	}
+	close(cache.release)
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if identities[i] != identities[0] {
+			t.Error("concurrent calls returned different identities")
+		}
+	}
+	checkIdentitySigned(t, identities[0], &key.PublicKey)
+}
+
+func setCrypto(t *testing.T, cache cryptocache.CryptoCache, key string, value []byte) {
+	t.Helper()
+
+	if err := cache.SetCrypto(key, value); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	"github.com/sirupsen/logrus"
+)
+
+// Rotate atomically switches the identity to PEM certificate cert and the key of signer without
+// recreating the SDK, e.g. when the certificate is renewed by other means than the cache.
+// Signatures in flight complete with the previous key, Serialize and Sign use the new certificate
+// and key once Rotate returns. The certificate is validated as by NewVaultSigningIdentity.
+// signer may be nil for identities signing remotely, the current signer or the backend of the manager
+// must then hold the key of cert, the backend is asked before the identity is switched.
+// Rotate does not store cert and the key, a later change of the certificate in the cache replaces them.
+func (m *VaultSigningIdentity) Rotate(cert []byte, signer crypto.Signer) error {
+	ecdsaPubKey, err := parseCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	current := m.identity()
+	if err = validateCertificate(context.Background(), current.Manager, current.MSPID, m.certName, cert); err != nil {
+		return err
+	}
+
+	switch {
+	case signer != nil:
+		if !ecdsaPubKey.Equal(signer.Public()) {
+			return &KeyMismatchError{CertName: m.certName}
+		}
+	case !m.remote:
+		return errors.New("private key is not available")
+	case current.Key.Signer != nil:
+		// a remote signer, e.g. Cloud KMS, keeps signing if it holds the key of cert
+		if !ecdsaPubKey.Equal(current.Key.Signer.Public()) {
+			return &KeyMismatchError{CertName: m.certName}
+		}
+		signer = current.Key.Signer
+	default:
+		if err = checkRemoteKey(context.Background(), current, ecdsaPubKey); err != nil {
+			return fmt.Errorf("failed to check the key of certificate %s: %w", m.certName, err)
+		}
+	}
+
+	m.mu.Lock()
+	m.VaultIdentity = &VaultIdentity{
+		MSPID:    current.MSPID,
+		Manager:  current.Manager,
+		Key:      &CartridgeKey{PubKey: ecdsaPubKey, Signer: signer},
+		IDBytes:  cert,
+		IDSource: m.VaultIdentity.IDSource,
+	}
+	m.pending = nil
+	m.mu.Unlock()
+
+	logrus.Infof("signing identity %s rotated", m.certName)
+	return nil
+}
+
+// Snapshot returns the current certificate and key as a signing identity that is never rotated.
+// fabric-sdk serializes the identity and signs with its key in separate calls, so a proposal built
+// while the identity rotates may carry the old certificate and the new signature and be rejected.
+// Building it with a snapshot keeps the certificate and the key consistent.
+func (m *VaultSigningIdentity) Snapshot() CartridgeSigningIdentity {
+	return &snapshotIdentity{VaultIdentity: m.identity()}
+}
+
+// snapshotIdentity is a signing identity with fixed certificate and key
+type snapshotIdentity struct {
+	*VaultIdentity
+}
+
+// Sign the message
+func (s *snapshotIdentity) Sign(msg []byte) ([]byte, error) {
+	return s.SignContext(context.Background(), msg)
+}
+
+// SignContext signs the message with cancellation by ctx
+func (s *snapshotIdentity) SignContext(ctx context.Context, msg []byte) ([]byte, error) {
+	hash := sha256.Sum256(msg)
+	return s.Manager.SignContext(ctx, hash[:], s.Key)
+}
+
+// PublicVersion returns the public parts of this identity
+func (s *snapshotIdentity) PublicVersion() msp.Identity {
+	return s
+}
+
+// PrivateKey returns the crypto suite representation of the private key
+func (s *snapshotIdentity) PrivateKey() core.Key {
+	return s.Key
+}
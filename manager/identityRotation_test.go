@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestVaultSigningIdentityRotate(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	key := stub.putIdentity(t, "org1", testVaultCert, nil)
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := m.SigningIdentity().(*VaultSigningIdentity)
+
+	next, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := newTestUserCert(t, testVaultCert, next)
+
+	var mismatchErr *KeyMismatchError
+	if err = identity.Rotate(cert, key); !errors.As(err, &mismatchErr) {
+		t.Errorf("err = %v, want KeyMismatchError", err)
+	}
+	if err = identity.Rotate(cert, nil); err == nil {
+		t.Error("identity holding its private key rotated without a key")
+	}
+	checkSigned(t, m, &key.PublicKey)
+
+	if err = identity.Rotate(cert, next); err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &next.PublicKey)
+}
+
+func TestVaultSigningIdentityRotateTransit(t *testing.T) {
+	stub, key := newTransitStub(t)
+	m, err := newStubVaultManager(t, stub, WithTransitSigning("transit", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := m.SigningIdentity().(*VaultSigningIdentity)
+
+	// a certificate for a key the transit key does not hold is rejected before the switch
+	foreign, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := newTestUserCert(t, testVaultCert, foreign)
+	if err = identity.Rotate(cert, nil); err == nil {
+		t.Error("identity rotated to a key the transit key does not hold")
+	}
+	checkSigned(t, m, &key.PublicKey)
+
+	rotated := stub.rotateTransit(t)
+	cert, _ = newTestUserCert(t, testVaultCert, rotated)
+	if err = identity.Rotate(cert, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &rotated.PublicKey)
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if version := stub.signVersions[len(stub.signVersions)-1]; version != 2 {
+		t.Errorf("signature made with key version %d after rotation, want 2", version)
+	}
+}
+
+func TestVaultSigningIdentityRotateRemoteSigner(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	key := stub.putIdentity(t, "org1", testVaultCert, nil)
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. SecretManager signing with a KMS key
+	identity, err := NewVaultSigningIdentityFromCert("Org1MSP", testVaultCert, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity.Key.Signer = key
+	m.signingIdentity = identity
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := newTestUserCert(t, testVaultCert, other)
+	var mismatchErr *KeyMismatchError
+	if err = identity.Rotate(cert, nil); !errors.As(err, &mismatchErr) {
+		t.Errorf("err = %v, want KeyMismatchError", err)
+	}
+	checkSigned(t, m, &key.PublicKey)
+
+	// a certificate renewed for the same key keeps the signer
+	cert, _ = newTestUserCert(t, testVaultCert, key)
+	if err = identity.Rotate(cert, nil); err != nil {
+		t.Fatal(err)
+	}
+	if string(identity.EnrollmentCertificate()) != string(cert) {
+		t.Error("certificate is not rotated")
+	}
+	checkSigned(t, m, &key.PublicKey)
+}
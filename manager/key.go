@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/sha256"
@@ -12,10 +13,13 @@ import (
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
 )
 
-// CartridgeKey is a core.Key wrapper for *ecdsa.PublicKey
+// CartridgeKey is a core.Key wrapper for *ecdsa.PublicKey.
+// It is a handle of the private key: Signer signs with it, whether it is held in memory
+// (*ecdsa.PrivateKey) or outside of the process, e.g. in Cloud KMS. Signer is nil for keys
+// that only the Manager can locate, e.g. in Vault Transit or an HSM.
 type CartridgeKey struct {
-	PrivKey *ecdsa.PrivateKey
-	PubKey  *ecdsa.PublicKey
+	PubKey *ecdsa.PublicKey
+	Signer crypto.Signer
 }
 
 // Bytes converts this key to its byte representation.
@@ -53,6 +57,19 @@ func (k *CartridgeKey) PublicKey() (core.Key, error) {
 	return k, nil
 }
 
+// wipeSigner overwrites the private scalar of an in-memory ECDSA key with zeros, other signers are left as is
+func wipeSigner(signer crypto.Signer) {
+	key, ok := signer.(*ecdsa.PrivateKey)
+	if !ok || key.D == nil {
+		return
+	}
+	bits := key.D.Bits()
+	for i := range bits {
+		bits[i] = 0
+	}
+	key.D.SetInt64(0)
+}
+
 // PEMToPrivateKey converts a PEM encoded private key to a *ecdsa.PrivateKey
 func PEMToPrivateKey(raw []byte, pwd []byte) (interface{}, error) {
 	block, _ := pem.Decode(raw)
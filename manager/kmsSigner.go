@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// KMSSigner signs digests with a Cloud KMS EC_SIGN_P256_SHA256 key version, the private key never leaves KMS
+type KMSSigner struct {
+	client  *kms.KeyManagementClient
+	keyName string
+	pub     *ecdsa.PublicKey
+}
+
+// NewKMSSigner returns a signer for key version keyName,
+// e.g. projects/p/locations/global/keyRings/fabric/cryptoKeys/user1/cryptoKeyVersions/1
+func NewKMSSigner(ctx context.Context, client *kms.KeyManagementClient, keyName string) (*KMSSigner, error) {
+	publicKey, err := client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: keyName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key of %s: %w", keyName, err)
+	}
+	if publicKey.Algorithm != kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256 {
+		return nil, fmt.Errorf("key %s is %s, expecting EC_SIGN_P256_SHA256", keyName, publicKey.Algorithm)
+	}
+
+	block, _ := pem.Decode([]byte(publicKey.Pem))
+	if block == nil {
+		return nil, fmt.Errorf("cannot decode public key of %s", keyName)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaPubKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("invalid key type, expecting ECDSA Public Key")
+	}
+
+	return &KMSSigner{client: client, keyName: keyName, pub: ecdsaPubKey}, nil
+}
+
+// Public returns the public key of the KMS key version
+func (s *KMSSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs the SHA-256 digest, rand is ignored
+func (s *KMSSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts != nil && opts.HashFunc() != crypto.SHA256 {
+		return nil, fmt.Errorf("unsupported hash function %s, expecting SHA-256", opts.HashFunc())
+	}
+	return s.SignContext(context.Background(), digest)
+}
+
+// SignContext signs the SHA-256 digest with cancellation by ctx and returns a DER signature
+func (s *KMSSigner) SignContext(ctx context.Context, digest []byte) ([]byte, error) {
+	if len(digest) != sha256.Size {
+		return nil, fmt.Errorf("invalid digest length %d, expecting %d", len(digest), sha256.Size)
+	}
+
+	resp, err := s.client.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{
+		Name:         s.keyName,
+		Digest:       &kmspb.Digest{Digest: &kmspb.Digest_Sha256{Sha256: digest}},
+		DigestCrc32C: wrapperspb.Int64(int64(crc32.Checksum(digest, crc32c))),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// checksums guard against corruption in transit
+	if !resp.VerifiedDigestCrc32C {
+		return nil, errors.New("digest corrupted in transit to KMS")
+	}
+	if resp.SignatureCrc32C == nil || resp.SignatureCrc32C.Value != int64(crc32.Checksum(resp.Signature, crc32c)) {
+		return nil, errors.New("signature corrupted in transit from KMS")
+	}
+
+	return resp.Signature, nil
+}
@@ -0,0 +1,192 @@
+package manager
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"hash/crc32"
+	"net"
+	"strings"
+	"testing"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testKMSKeyName = "projects/p/locations/global/keyRings/fabric/cryptoKeys/user1/cryptoKeyVersions/1"
+
+// kmsStub is a Cloud KMS service signing with key, corrupt changes the checksums of responses
+type kmsStub struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+
+	key       *ecdsa.PrivateKey
+	algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	corrupt   func(resp *kmspb.AsymmetricSignResponse)
+}
+
+func (s *kmsStub) GetPublicKey(_ context.Context, req *kmspb.GetPublicKeyRequest) (*kmspb.PublicKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.PublicKey{
+		Name:      req.Name,
+		Algorithm: s.algorithm,
+		Pem:       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
+
+func (s *kmsStub) AsymmetricSign(_ context.Context, req *kmspb.AsymmetricSignRequest) (*kmspb.AsymmetricSignResponse, error) {
+	digest := req.Digest.GetSha256()
+	signature, err := ecdsa.SignASN1(rand.Reader, s.key, digest)
+	if err != nil {
+		return nil, err
+	}
+	resp := &kmspb.AsymmetricSignResponse{
+		Name:                 req.Name,
+		Signature:            signature,
+		SignatureCrc32C:      wrapperspb.Int64(int64(crc32.Checksum(signature, crc32c))),
+		VerifiedDigestCrc32C: req.DigestCrc32C != nil && req.DigestCrc32C.Value == int64(crc32.Checksum(digest, crc32c)),
+	}
+	if s.corrupt != nil {
+		s.corrupt(resp)
+	}
+	return resp, nil
+}
+
+// newStubConn returns a connection to a gRPC server with services registered by register
+// served over an in-memory listener until the test ends
+func newStubConn(t *testing.T, register func(srv *grpc.Server)) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	register(srv)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// newKMSStubClient returns a KMS client connected to stub
+func newKMSStubClient(t *testing.T, stub kmspb.KeyManagementServiceServer) *kms.KeyManagementClient {
+	t.Helper()
+
+	conn := newStubConn(t, func(srv *grpc.Server) { kmspb.RegisterKeyManagementServiceServer(srv, stub) })
+	client, err := kms.NewKeyManagementClient(context.Background(), option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestKMSSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newKMSStubClient(t, &kmsStub{key: key, algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256})
+	ctx := context.Background()
+
+	signer, err := NewKMSSigner(ctx, client, testKMSKeyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(signer.Public()) {
+		t.Fatal("public key of the key version is not returned")
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := signer.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("signature does not verify")
+	}
+
+	if _, err = signer.Sign(nil, digest[:], crypto.SHA384); err == nil {
+		t.Error("digest of SHA-384 signed")
+	}
+	if _, err = signer.SignContext(ctx, digest[:16]); err == nil {
+		t.Error("digest of invalid length signed")
+	}
+}
+
+func TestKMSSignerAlgorithm(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newKMSStubClient(t, &kmsStub{key: key, algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384})
+
+	if _, err = NewKMSSigner(context.Background(), client, testKMSKeyName); err == nil {
+		t.Error("signer created for a key of another algorithm")
+	}
+}
+
+func TestKMSSignerChecksums(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+
+	tests := []struct {
+		name    string
+		corrupt func(resp *kmspb.AsymmetricSignResponse)
+		err     string
+	}{
+		{
+			name:    "digest not verified",
+			corrupt: func(resp *kmspb.AsymmetricSignResponse) { resp.VerifiedDigestCrc32C = false },
+			err:     "digest corrupted",
+		},
+		{
+			name:    "signature checksum missing",
+			corrupt: func(resp *kmspb.AsymmetricSignResponse) { resp.SignatureCrc32C = nil },
+			err:     "signature corrupted",
+		},
+		{
+			name:    "signature checksum mismatch",
+			corrupt: func(resp *kmspb.AsymmetricSignResponse) { resp.SignatureCrc32C.Value++ },
+			err:     "signature corrupted",
+		},
+		{
+			name:    "signature corrupted",
+			corrupt: func(resp *kmspb.AsymmetricSignResponse) { resp.Signature[len(resp.Signature)-1] ^= 0xff },
+			err:     "signature corrupted",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &kmsStub{key: key, algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256, corrupt: test.corrupt}
+			signer, err := NewKMSSigner(context.Background(), newKMSStubClient(t, stub), testKMSKeyName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = signer.SignContext(context.Background(), digest[:])
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
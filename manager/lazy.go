@@ -0,0 +1,26 @@
+package manager
+
+import (
+	"fmt"
+	"path"
+)
+
+// validatePatterns checks that patterns are valid path.Match patterns
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchAny reports whether name matches one of patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
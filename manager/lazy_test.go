@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const testUserTLS = "org1.example.com/users/User1@org1.example.com/tls/"
+
+// putMSPIdentity puts the MSP of User1 issued by ca with the CA certificate of cacerts to stub
+func putMSPIdentity(t *testing.T, stub *vaultStub, ca, cacerts testCA) {
+	t.Helper()
+
+	certPEM, _, key := newTestCert(t, "User1@org1.example.com", false, ca.crt, ca.key)
+	ski := hex.EncodeToString((&CartridgeKey{PubKey: &key.PublicKey}).SKI())
+	stub.put(testUserMSP+"signcerts/"+testVaultCert, certPEM)
+	stub.put(testUserMSP+"keystore/"+ski+"_sk", newTestKeyPEM(t, key))
+	stub.put(testUserMSP+"cacerts/ca-cert.pem", cacerts.pem)
+}
+
+func sorted(names ...string) []string {
+	sort.Strings(names)
+	return names
+}
+
+func TestVaultLazyLoading(t *testing.T) {
+	const (
+		user2Cert = "org1.example.com/users/User2@org1.example.com/msp/signcerts/User2@org1.example.com-cert.pem"
+		tlsCert   = "User1@org1.example.com/tls/client.crt"
+		tlsKey    = "User1@org1.example.com/tls/client.key"
+	)
+
+	org1 := newTestCA(t, "ca.org1.example.com", nil)
+	stub := newVaultStub(kvVersion2)
+	putMSPIdentity(t, stub, org1, org1)
+	user2PEM, _, _ := newTestCert(t, "User2@org1.example.com", false, org1.crt, org1.key)
+	stub.put(user2Cert, user2PEM)
+	stub.put(testUserTLS+"client.crt", []byte("tls certificate"))
+	stub.put(testUserTLS+"client.key", []byte("tls key"))
+
+	m, err := newStubVaultManager(t, stub, WithLazyLoading("User1@org1.example.com/tls/*.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lazy, ok := m.Cache().(interface {
+		Keys() []string
+		LoadedKeys() []string
+	})
+	if !ok {
+		t.Fatalf("cache %T is not lazy", m.Cache())
+	}
+
+	// the signing identity is validated against cacerts loaded on demand
+	loaded := lazy.LoadedKeys()
+	sort.Strings(loaded)
+	ski := hex.EncodeToString(m.SigningIdentity().PrivateKey().SKI())
+	if want := sorted(testVaultCert, ski+"_sk", tlsCert, "ca-cert.pem"); !reflect.DeepEqual(loaded, want) {
+		t.Errorf("loaded %v, want %v", loaded, want)
+	}
+	if n := stub.callCount("kv/data/" + user2Cert); n != 0 {
+		t.Errorf("certificate of another user read %d times", n)
+	}
+
+	keys := lazy.Keys()
+	sort.Strings(keys)
+	if want := sorted(testVaultCert, "User2@org1.example.com-cert.pem", ski+"_sk", tlsCert, tlsKey, "ca-cert.pem"); !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+
+	if value, err := m.Cache().GetCrypto(tlsKey); err != nil || string(value) != "tls key" {
+		t.Errorf("GetCrypto = %q, %v", value, err)
+	}
+
+	// refresh re-reads loaded crypto only
+	stub.put(testUserTLS+"client.crt", []byte("new tls certificate"))
+	if err = m.refreshCrypto(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := m.Cache().GetCrypto(tlsCert); string(value) != "new tls certificate" {
+		t.Errorf("refreshed %q", value)
+	}
+	if n := stub.callCount("kv/data/" + user2Cert); n != 0 {
+		t.Errorf("certificate of another user read %d times by refresh", n)
+	}
+}
+
+func TestVaultLazyLoadingValidation(t *testing.T) {
+	org1 := newTestCA(t, "ca.org1.example.com", nil)
+	org2 := newTestCA(t, "ca.org2.example.com", nil)
+	stub := newVaultStub(kvVersion2)
+	putMSPIdentity(t, stub, org2, org1)
+
+	_, err := newStubVaultManager(t, stub, WithLazyLoading())
+	var chainErr *ChainError
+	if !errors.As(err, &chainErr) {
+		t.Errorf("err = %v, want ChainError", err)
+	}
+
+	if _, err = newStubVaultManager(t, stub, WithLazyLoading("[")); err == nil {
+		t.Error("invalid prefetch pattern accepted")
+	}
+}
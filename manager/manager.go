@@ -1,15 +1,26 @@
 package manager
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"io"
 
 	"github.com/atomyze-foundation/cartridge/cryptocache"
 )
 
 // Manager is responsible for sign/verify operations.
+// Keys are passed as handles: a manager signs with the Signer of the key, backends holding keys remotely
+// locate their key by the public key (SKI) of the handle instead.
+// Close releases backend clients, stops background work and wipes cached crypto.
 type Manager interface {
-	Sign(digest []byte, ecdsaPrivateKey *ecdsa.PrivateKey, ecdsaPublicKey *ecdsa.PublicKey) ([]byte, error)
+	io.Closer
+	// Sign signs the SHA-256 digest with key and returns a low-S DER signature
+	Sign(digest []byte, key *CartridgeKey) ([]byte, error)
 	Verify(digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error
+	// SignContext is Sign with cancellation by ctx, remote backends must give up when ctx is done
+	SignContext(ctx context.Context, digest []byte, key *CartridgeKey) ([]byte, error)
+	// VerifyContext is Verify with cancellation by ctx
+	VerifyContext(ctx context.Context, digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error
 	SigningIdentity() CartridgeSigningIdentity
 	Cache() cryptocache.CryptoCache
 }
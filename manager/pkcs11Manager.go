@@ -0,0 +1,426 @@
+//go:build pkcs11
+// +build pkcs11
+
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/miekg/pkcs11"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultPKCS11Sessions = 10
+
+// PKCS11Config locates the token holding the signing keys
+type PKCS11Config struct {
+	// Library is the path of the PKCS#11 module, e.g. /usr/lib/softhsm/libsofthsm2.so
+	Library string
+	// Label of the token
+	Label string
+	// Pin of the user
+	Pin string
+}
+
+// PKCS11Option is a function that configures a PKCS11Manager
+type PKCS11Option func(m *PKCS11Manager) error
+
+// WithPKCS11CertificateSource makes PKCS11Manager use crypto of cache, e.g. the cache of another manager,
+// instead of reading certificates from the token
+func WithPKCS11CertificateSource(cache cryptocache.CryptoCache) PKCS11Option {
+	return func(m *PKCS11Manager) error {
+		if cache == nil {
+			return errors.New("certificate source must not be nil")
+		}
+		m.memcache = cache
+		return nil
+	}
+}
+
+// WithPKCS11Sessions sets the maximum number of sessions used for concurrent signing, 10 by default
+func WithPKCS11Sessions(sessions int) PKCS11Option {
+	return func(m *PKCS11Manager) error {
+		if sessions <= 0 {
+			return fmt.Errorf("invalid number of sessions %d", sessions)
+		}
+		m.sessions = make(chan pkcs11.SessionHandle, sessions)
+		return nil
+	}
+}
+
+// WithPKCS11CACertificates makes PKCS11Manager trust only the CA certificates with names as roots of the org
+func WithPKCS11CACertificates(names ...string) PKCS11Option {
+	return func(m *PKCS11Manager) error {
+		return m.setCANames(names)
+	}
+}
+
+// WithPKCS11CRLCheckDisabled makes PKCS11Manager not check certificates against CRLs
+func WithPKCS11CRLCheckDisabled() PKCS11Option {
+	return func(m *PKCS11Manager) error {
+		m.skipCRLs = true
+		return nil
+	}
+}
+
+// PKCS11Manager signs with ECDSA keys held in an HSM. Keys are located by CKA_ID equal to
+// the SKI of the public key, the way Fabric stores them.
+type PKCS11Manager struct {
+	validationConfig
+
+	ctx             *pkcs11.Ctx
+	slot            uint
+	pin             string
+	sessions        chan pkcs11.SessionHandle
+	opened          int
+	openMu          sync.Mutex
+	keys            map[string]pkcs11.ObjectHandle
+	keysMu          sync.RWMutex
+	memcache        cryptocache.CryptoCache
+	ownCache        bool
+	signingIdentity *VaultSigningIdentity
+	unwatch         func()
+	closeOnce       sync.Once
+}
+
+// NewPKCS11Manager gets new instance of PKCS11Manager
+// Certificates are read from the token and cached under their labels unless WithPKCS11CertificateSource is set,
+// userCert is the name of the user certificate in the cache.
+func NewPKCS11Manager(mspID, userCert string, cfg PKCS11Config, opts ...PKCS11Option) (*PKCS11Manager, error) {
+	return NewPKCS11ManagerContext(context.Background(), mspID, userCert, cfg, opts...)
+}
+
+// NewPKCS11ManagerContext is NewPKCS11Manager with cancellation by ctx
+func NewPKCS11ManagerContext(ctx context.Context, mspID, userCert string, cfg PKCS11Config, opts ...PKCS11Option) (manager *PKCS11Manager, err error) {
+	manager = &PKCS11Manager{
+		pin:      cfg.Pin,
+		sessions: make(chan pkcs11.SessionHandle, defaultPKCS11Sessions),
+		keys:     make(map[string]pkcs11.ObjectHandle),
+	}
+	for _, opt := range opts {
+		if err = opt(manager); err != nil {
+			return nil, err
+		}
+	}
+
+	manager.ctx = pkcs11.New(cfg.Library)
+	if manager.ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 library %s", cfg.Library)
+	}
+	if err = manager.ctx.Initialize(); err != nil {
+		manager.ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize PKCS#11 library: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = manager.Close()
+		}
+	}()
+
+	if manager.slot, err = findSlot(manager.ctx, cfg.Label); err != nil {
+		return nil, err
+	}
+
+	if manager.memcache == nil {
+		manager.memcache = cryptocache.NewMemCache()
+		manager.ownCache = true
+		if err = manager.loadCertificates(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	manager.signingIdentity, err = NewVaultSigningIdentityFromCertContext(ctx, mspID, userCert, manager)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = manager.findKey(ctx, manager.signingIdentity.Key.PubKey); err != nil {
+		return nil, err
+	}
+
+	if notifier, ok := manager.memcache.(cryptocache.Notifier); ok {
+		manager.unwatch = manager.signingIdentity.Watch(notifier)
+	}
+
+	return manager, nil
+}
+
+// findSlot returns the slot of the token with label
+func findSlot(p *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := p.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := p.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read token info of slot %d: %w", slot, err)
+		}
+		if info.Label == label {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("PKCS#11 token %s not found", label)
+}
+
+// session takes a logged in session from the pool, opening a new one if the pool is not full
+func (m *PKCS11Manager) session(ctx context.Context) (pkcs11.SessionHandle, error) {
+	select {
+	case session := <-m.sessions:
+		return session, nil
+	default:
+	}
+
+	m.openMu.Lock()
+	if m.opened < cap(m.sessions) {
+		m.opened++
+		m.openMu.Unlock()
+
+		session, err := m.openSession()
+		if err != nil {
+			m.openMu.Lock()
+			m.opened--
+			m.openMu.Unlock()
+			return 0, err
+		}
+		return session, nil
+	}
+	m.openMu.Unlock()
+
+	select {
+	case session := <-m.sessions:
+		return session, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (m *PKCS11Manager) openSession() (pkcs11.SessionHandle, error) {
+	session, err := m.ctx.OpenSession(m.slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open PKCS#11 session: %w", err)
+	}
+
+	// login is shared by all sessions of the application
+	err = m.ctx.Login(session, pkcs11.CKU_USER, m.pin)
+	if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		_ = m.ctx.CloseSession(session)
+		return 0, fmt.Errorf("PKCS#11 login failed: %w", err)
+	}
+
+	return session, nil
+}
+
+// release returns session to the pool, broken sessions are closed
+func (m *PKCS11Manager) release(session pkcs11.SessionHandle, err error) {
+	var p11Err pkcs11.Error
+	if errors.As(err, &p11Err) && (p11Err == pkcs11.CKR_SESSION_HANDLE_INVALID || p11Err == pkcs11.CKR_SESSION_CLOSED ||
+		p11Err == pkcs11.CKR_DEVICE_ERROR || p11Err == pkcs11.CKR_DEVICE_REMOVED) {
+		_ = m.ctx.CloseSession(session)
+		m.openMu.Lock()
+		m.opened--
+		m.openMu.Unlock()
+		return
+	}
+	m.sessions <- session
+}
+
+// findObjects returns handles of objects matching template
+func (m *PKCS11Manager) findObjects(session pkcs11.SessionHandle, template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if err := m.ctx.FindObjectsInit(session, template); err != nil {
+		return nil, err
+	}
+
+	var handles []pkcs11.ObjectHandle
+	for {
+		batch, _, err := m.ctx.FindObjects(session, 100) //nolint:gomnd
+		if err != nil {
+			_ = m.ctx.FindObjectsFinal(session)
+			return nil, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		handles = append(handles, batch...)
+	}
+
+	return handles, m.ctx.FindObjectsFinal(session)
+}
+
+// loadCertificates puts X.509 certificates of the token to the cache as PEM under their labels
+func (m *PKCS11Manager) loadCertificates(ctx context.Context) (err error) {
+	session, err := m.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { m.release(session, err) }()
+
+	handles, err := m.findObjects(session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+		pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find certificates: %w", err)
+	}
+
+	for _, handle := range handles {
+		attrs, err := m.ctx.GetAttributeValue(session, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read certificate: %w", err)
+		}
+
+		label, value := string(attrs[0].Value), attrs[1].Value
+		if label == "" {
+			logrus.Warnf("skipping certificate without label")
+			continue
+		}
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: value})
+		if err = m.memcache.SetCrypto(label, cert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findKey returns the handle of the private key with CKA_ID equal to the SKI of ecdsaPublicKey
+func (m *PKCS11Manager) findKey(ctx context.Context, ecdsaPublicKey *ecdsa.PublicKey) (handle pkcs11.ObjectHandle, err error) {
+	ski := (&CartridgeKey{PubKey: ecdsaPublicKey}).SKI()
+	name := hex.EncodeToString(ski)
+
+	m.keysMu.RLock()
+	handle, ok := m.keys[name]
+	m.keysMu.RUnlock()
+	if ok {
+		return handle, nil
+	}
+
+	session, err := m.session(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { m.release(session, err) }()
+
+	handles, err := m.findObjects(session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, ski),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find private key %s: %w", name, err)
+	}
+	if len(handles) == 0 {
+		return 0, fmt.Errorf("private key %s not found on the token", name)
+	}
+
+	m.keysMu.Lock()
+	m.keys[name] = handles[0]
+	m.keysMu.Unlock()
+
+	return handles[0], nil
+}
+
+// checkRemoteKey checks that the token holds the private key of ecdsaPublicKey
+func (m *PKCS11Manager) checkRemoteKey(ctx context.Context, ecdsaPublicKey *ecdsa.PublicKey) error {
+	_, err := m.findKey(ctx, ecdsaPublicKey)
+	return err
+}
+
+// Sign signs the digest with the Signer of key. Keys without a Signer, such as the key of the signing
+// identity, are signed with the HSM key with the SKI of key.
+func (m *PKCS11Manager) Sign(digest []byte, key *CartridgeKey) ([]byte, error) {
+	return m.SignContext(context.Background(), digest, key)
+}
+
+// SignContext is Sign with cancellation by ctx. A started C_Sign call is not interrupted.
+func (m *PKCS11Manager) SignContext(ctx context.Context, digest []byte, key *CartridgeKey) (signature []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, errors.New("key must not be nil")
+	}
+	if key.Signer != nil {
+		return signWithKey(ctx, digest, key)
+	}
+
+	handle, err := m.findKey(ctx, key.PubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := m.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { m.release(session, err) }()
+
+	if err = m.ctx.SignInit(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, handle); err != nil {
+		return nil, fmt.Errorf("C_SignInit failed: %w", err)
+	}
+	raw, err := m.ctx.Sign(session, digest)
+	if err != nil {
+		return nil, fmt.Errorf("C_Sign failed: %w", err)
+	}
+
+	// CKM_ECDSA signatures are raw r||s
+	return rawToLowSDER(key.PubKey, raw)
+}
+
+// Verify verifies signature against digest using ecdsaPublicKey
+func (m *PKCS11Manager) Verify(digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return m.VerifyContext(context.Background(), digest, signature, ecdsaPublicKey)
+}
+
+// VerifyContext is Verify with cancellation by ctx
+func (m *PKCS11Manager) VerifyContext(ctx context.Context, digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return verifyECDSA(ctx, digest, signature, ecdsaPublicKey)
+}
+
+// SigningIdentity returns signing identity
+func (m *PKCS11Manager) SigningIdentity() CartridgeSigningIdentity {
+	return m.signingIdentity
+}
+
+// Cache returns cache
+func (m *PKCS11Manager) Cache() cryptocache.CryptoCache {
+	return m.memcache
+}
+
+// Close closes the sessions and unloads the PKCS#11 library. Signing in progress must be finished first.
+// The cache is wiped unless it was set by WithPKCS11CertificateSource.
+func (m *PKCS11Manager) Close() (err error) {
+	m.closeOnce.Do(func() {
+		if m.unwatch != nil {
+			m.unwatch()
+		}
+
+		m.openMu.Lock()
+		for ; m.opened > 0; m.opened-- {
+			session := <-m.sessions
+			if m.opened == 1 {
+				// logout applies to all sessions of the application
+				_ = m.ctx.Logout(session)
+			}
+			_ = m.ctx.CloseSession(session)
+		}
+		m.openMu.Unlock()
+
+		err = m.ctx.Finalize()
+		m.ctx.Destroy()
+
+		if clearer, ok := m.memcache.(cryptocache.Clearer); ok && m.ownCache {
+			clearer.Clear()
+		}
+	})
+	return err
+}
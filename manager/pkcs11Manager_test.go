@@ -0,0 +1,268 @@
+//go:build pkcs11
+// +build pkcs11
+
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/hyperledger/fabric/bccsp/utils"
+	"github.com/miekg/pkcs11"
+)
+
+const (
+	defaultSoftHSMLibrary = "/usr/lib/softhsm/libsofthsm2.so"
+	defaultSoftHSMLabel   = "ForFabric"
+	defaultSoftHSMPin     = "98765432"
+)
+
+// oidNamedCurveP256 is the DER encoded OID of P-256 for CKA_EC_PARAMS
+var oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+
+// softHSMConfig returns the config of the SoftHSM token, the test is skipped unless SOFTHSM2_CONF is set.
+// The library, token label and pin default to those of Fabric tests and are overridden by
+// PKCS11_LIB, PKCS11_LABEL and PKCS11_PIN.
+func softHSMConfig(t *testing.T) PKCS11Config {
+	t.Helper()
+
+	if os.Getenv("SOFTHSM2_CONF") == "" {
+		t.Skip("SOFTHSM2_CONF is not set")
+	}
+	cfg := PKCS11Config{Library: defaultSoftHSMLibrary, Label: defaultSoftHSMLabel, Pin: defaultSoftHSMPin}
+	if lib := os.Getenv("PKCS11_LIB"); lib != "" {
+		cfg.Library = lib
+	}
+	if label := os.Getenv("PKCS11_LABEL"); label != "" {
+		cfg.Label = label
+	}
+	if pin := os.Getenv("PKCS11_PIN"); pin != "" {
+		cfg.Pin = pin
+	}
+	return cfg
+}
+
+// withToken runs f with a logged in session of the token of cfg, the library is finalized afterwards
+// so that managers can initialize it
+func withToken(t *testing.T, cfg PKCS11Config, f func(p *pkcs11.Ctx, session pkcs11.SessionHandle)) {
+	t.Helper()
+
+	p := pkcs11.New(cfg.Library)
+	if p == nil {
+		t.Fatalf("failed to load %s", cfg.Library)
+	}
+	defer p.Destroy()
+	if err := p.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = p.Finalize() }()
+
+	slot, err := findSlot(p, cfg.Label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := p.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = p.CloseSession(session) }()
+	if err = p.Login(session, pkcs11.CKU_USER, cfg.Pin); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = p.Logout(session) }()
+
+	f(p, session)
+}
+
+// importTestIdentity puts key with CKA_ID equal to its SKI and certificate crt labeled certName to the token
+// and removes them when the test finishes
+func importTestIdentity(t *testing.T, cfg PKCS11Config, certName string, crt *x509.Certificate, key *ecdsa.PrivateKey) {
+	t.Helper()
+
+	ecParams, err := asn1.Marshal(oidNamedCurveP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ski := (&CartridgeKey{PubKey: &key.PublicKey}).SKI()
+
+	var handles []pkcs11.ObjectHandle
+	withToken(t, cfg, func(p *pkcs11.Ctx, session pkcs11.SessionHandle) {
+		keyHandle, err := p.CreateObject(session, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, ski),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, ecParams),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE, key.D.Bytes()),
+		})
+		if err != nil {
+			t.Fatalf("failed to import private key: %s", err)
+		}
+		handles = append(handles, keyHandle)
+
+		certHandle, err := p.CreateObject(session, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+			pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, certName),
+			pkcs11.NewAttribute(pkcs11.CKA_SUBJECT, crt.RawSubject),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE, crt.Raw),
+		})
+		if err != nil {
+			t.Fatalf("failed to import certificate: %s", err)
+		}
+		handles = append(handles, certHandle)
+	})
+
+	t.Cleanup(func() {
+		withToken(t, cfg, func(p *pkcs11.Ctx, session pkcs11.SessionHandle) {
+			for _, handle := range handles {
+				_ = p.DestroyObject(session, handle)
+			}
+		})
+	})
+}
+
+func TestPKCS11ManagerSign(t *testing.T) {
+	cfg := softHSMConfig(t)
+	certName := "User1@org1.example.com-cert.pem"
+	_, crt, key := newTestCert(t, "User1@org1.example.com", false, nil, nil)
+	importTestIdentity(t, cfg, certName, crt, key)
+
+	// certificates are read from the token, the key is found by the SKI of the certificate
+	m, err := NewPKCS11Manager("Org1MSP", certName, cfg, WithPKCS11Sessions(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// more signers than sessions wait for sessions returned to the pool
+	const signers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, signers)
+	for i := 0; i < signers; i++ {
+		msg := []byte(fmt.Sprintf("message %d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			signature, err := m.SigningIdentity().Sign(msg)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			digest := sha256.Sum256(msg)
+			if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+				errs <- fmt.Errorf("signature of %q does not verify", msg)
+				return
+			}
+			_, s, err := utils.UnmarshalECDSASignature(signature)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if lowS, err := utils.IsLowS(&key.PublicKey, s); err != nil || !lowS {
+				errs <- fmt.Errorf("signature of %q is not low-S", msg)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err = range errs {
+		t.Error(err)
+	}
+
+	if m.opened > 2 {
+		t.Errorf("%d sessions opened, want at most 2", m.opened)
+	}
+}
+
+func TestPKCS11ManagerSignerKey(t *testing.T) {
+	cfg := softHSMConfig(t)
+	certName := "User4@org1.example.com-cert.pem"
+	_, crt, key := newTestCert(t, "User4@org1.example.com", false, nil, nil)
+	importTestIdentity(t, cfg, certName, crt, key)
+
+	m, err := NewPKCS11Manager("Org1MSP", certName, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// a key with a Signer is not on the token and is signed locally
+	_, _, local := newTestCert(t, "local", false, nil, nil)
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := m.Sign(digest[:], &CartridgeKey{PubKey: &local.PublicKey, Signer: local})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&local.PublicKey, digest[:], signature) {
+		t.Error("signature of a local key does not verify")
+	}
+
+	if _, err = m.Sign(digest[:], &CartridgeKey{PubKey: &local.PublicKey}); err == nil {
+		t.Error("key without a Signer signed although it is not on the token")
+	}
+}
+
+func TestPKCS11ManagerKeyNotFound(t *testing.T) {
+	cfg := softHSMConfig(t)
+	certPEM, _, key := newTestCert(t, "User2@org1.example.com", false, nil, nil)
+
+	cache := cryptocache.NewMemCache()
+	if err := cache.SetCrypto("User2@org1.example.com-cert.pem", certPEM); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewPKCS11Manager("Org1MSP", "User2@org1.example.com-cert.pem", cfg, WithPKCS11CertificateSource(cache))
+	ski := (&CartridgeKey{PubKey: &key.PublicKey}).SKI()
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%x", ski)) {
+		t.Fatalf("err = %v, want private key %x not found", err, ski)
+	}
+}
+
+func TestPKCS11ManagerClose(t *testing.T) {
+	cfg := softHSMConfig(t)
+	certName := "User3@org1.example.com-cert.pem"
+	_, crt, key := newTestCert(t, "User3@org1.example.com", false, nil, nil)
+	importTestIdentity(t, cfg, certName, crt, key)
+
+	m, err := NewPKCS11Manager("Org1MSP", certName, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.SigningIdentity().Sign([]byte("message")); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Close(); err != nil {
+		t.Fatalf("second Close: %s", err)
+	}
+	if m.opened != 0 {
+		t.Errorf("%d sessions left open", m.opened)
+	}
+	if _, err = m.Cache().GetCrypto(certName); err == nil {
+		t.Error("certificates read from the token are not wiped")
+	}
+
+	// the library is finalized, so a new manager can initialize it again
+	m, err = NewPKCS11Manager("Org1MSP", certName, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,65 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithRefresh makes VaultManager re-read crypto from Vault every interval. Changed crypto is
+// saved to the cache, which notifies subscribers such as the signing identity and the endpoint config.
+// In lazy mode only crypto loaded so far is re-read.
+func WithRefresh(interval time.Duration) Option {
+	return func(v *VaultManager) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid refresh interval %s", interval)
+		}
+		v.refreshInterval = interval
+		return nil
+	}
+}
+
+// WithSecretRefresh makes SecretManager re-read crypto from Secret Manager every interval.
+// Changed crypto is saved to the cache, which notifies subscribers. In lazy mode only crypto
+// loaded so far is re-read.
+func WithSecretRefresh(interval time.Duration) SecretOption {
+	return func(sm *SecretManager) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid refresh interval %s", interval)
+		}
+		sm.refreshInterval = interval
+		return nil
+	}
+}
+
+// runRefresh calls refresh every interval until closeCh is closed
+func runRefresh(interval time.Duration, closeCh <-chan struct{}, refresh func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-closeCh
+		cancel()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closeCh:
+			return
+		case <-ticker.C:
+		}
+
+		t := time.Now()
+		if err := refresh(ctx); err != nil {
+			if ctx.Err() == nil {
+				logrus.Errorf("crypto refresh failed: %s", err)
+			}
+			continue
+		}
+		logrus.Debugf("refresh of cryptomaterials took %.2f seconds", time.Since(t).Seconds())
+	}
+}
@@ -3,27 +3,92 @@ package manager
 import (
 	"context"
 	"crypto/ecdsa"
-	"crypto/rand"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	kms "cloud.google.com/go/kms/apiv1"
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/atomyze-foundation/cartridge/cryptocache"
-	"github.com/hyperledger/fabric/bccsp/utils"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// SecretOption is a function that configures a SecretManager
+type SecretOption func(c *SecretManager) error
+
+// WithSecretFetchConfig configures concurrency and retries of crypto download
+func WithSecretFetchConfig(cfg FetchConfig) SecretOption {
+	return func(sm *SecretManager) error {
+		sm.fetchConfig = cfg
+		return nil
+	}
+}
+
+// WithSecretLazyLoading makes SecretManager only list secrets at construction and read them on first access.
+// Crypto with names matching one of prefetch patterns (path.Match syntax, e.g. "*-cert.pem") is read at construction.
+func WithSecretLazyLoading(prefetch ...string) SecretOption {
+	return func(sm *SecretManager) error {
+		if err := validatePatterns(prefetch); err != nil {
+			return err
+		}
+		sm.lazy = true
+		sm.prefetch = prefetch
+		return nil
+	}
+}
+
+// WithKMSSigning makes SecretManager sign digests with the Cloud KMS key version keyName
+// (EC_SIGN_P256_SHA256), so the private key is never read from Secret Manager: secrets of private keys
+// named <ski>_sk are skipped when crypto is listed. The key must match
+// the public key of the user certificate, e.g.
+// projects/p/locations/global/keyRings/fabric/cryptoKeys/user1/cryptoKeyVersions/1
+func WithKMSSigning(keyName string) SecretOption {
+	return func(sm *SecretManager) error {
+		if keyName == "" {
+			return errors.New("KMS key name must not be empty")
+		}
+		sm.kmsKeyName = keyName
+		return nil
+	}
+}
+
+// WithSecretClientOptions adds options of the Secret Manager and KMS clients, e.g. an endpoint
+func WithSecretClientOptions(options ...option.ClientOption) SecretOption {
+	return func(sm *SecretManager) error {
+		sm.clientOptions = append(sm.clientOptions, options...)
+		return nil
+	}
+}
+
 // SecretManager handles SecretManager operations
 type SecretManager struct {
+	validationConfig
+
 	client          *secretmanager.Client
+	kmsClient       *kms.KeyManagementClient
+	kmsKeyName      string
+	clientOptions   []option.ClientOption
 	memcache        cryptocache.CryptoCache
 	signingIdentity *VaultSigningIdentity
+	unwatch         func()
+	fetchConfig     FetchConfig
+	lazy            bool
+	prefetch        []string
+	index           map[string]string
+	indexMu         sync.RWMutex
+	project         string
+	refreshInterval time.Duration
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
 func encodeSecretName(secretName string) string {
@@ -38,49 +103,283 @@ func decodeSecretName(encodedSecretName string) string {
 
 // NewSecretManager GetManager gets new instance of SecretManager
 // userCryptoPath is used to resolve secrets for the current application (e.g. observer.atomyze.dev0.dlt.atomyze.ch)
-func NewSecretManager(mspID, project, userCert, credsPath string) (*SecretManager, error) {
-	ctx := context.Background()
-	client, err := secretmanager.NewClient(ctx, option.WithCredentialsFile(credsPath))
+func NewSecretManager(mspID, project, userCert, credsPath string, opts ...SecretOption) (*SecretManager, error) {
+	return NewSecretManagerContext(context.Background(), mspID, project, userCert, credsPath, opts...)
+}
+
+// NewSecretManagerContext is NewSecretManager with ctx bounding download of crypto.
+// Background refresh does not depend on ctx.
+func NewSecretManagerContext(ctx context.Context, mspID, project, userCert, credsPath string, opts ...SecretOption) (*SecretManager, error) {
+	manager := &SecretManager{
+		memcache: cryptocache.NewMemCache(),
+		project:  project,
+		closeCh:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(manager); err != nil {
+			return nil, err
+		}
+	}
+
+	// the client keeps using the context it was created with to refresh credentials
+	var err error
+	manager.client, err = secretmanager.NewClient(context.Background(), manager.gcpClientOptions(credsPath)...)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			_ = manager.Close()
+		}
+	}()
 
-	manager := &SecretManager{client: client, memcache: cryptocache.NewMemCache()}
 	t := time.Now()
-	if err = manager.pullSecretCrypto(ctx, project, ""); err != nil {
+	if manager.lazy {
+		err = manager.indexSecretCrypto(ctx, project)
+	} else {
+		err = manager.pullSecretCrypto(ctx, project, "")
+	}
+	if err != nil {
 		return nil, err
 	}
 	logrus.Infof("loading of cryptomaterials took %.2f seconds", time.Since(t).Seconds())
 
-	manager.signingIdentity, err = NewVaultSigningIdentity(mspID, userCert, manager)
+	if manager.kmsKeyName != "" {
+		manager.signingIdentity, err = manager.kmsSigningIdentity(ctx, mspID, userCert, credsPath)
+	} else {
+		manager.signingIdentity, err = NewVaultSigningIdentityContext(ctx, mspID, userCert, manager)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if notifier, ok := manager.memcache.(cryptocache.Notifier); ok {
+		manager.unwatch = manager.signingIdentity.Watch(notifier)
+	}
+
+	if manager.refreshInterval > 0 {
+		manager.wg.Add(1)
+		go func() {
+			defer manager.wg.Done()
+			runRefresh(manager.refreshInterval, manager.closeCh, manager.refreshCrypto)
+		}()
+	}
+
 	return manager, nil
 }
 
-//nolint:funlen
+// kmsSigningIdentity returns the identity of certificate userCert signing with the KMS key
+func (sm *SecretManager) kmsSigningIdentity(ctx context.Context, mspID, userCert, credsPath string) (*VaultSigningIdentity, error) {
+	var err error
+	sm.kmsClient, err = kms.NewKeyManagementClient(context.Background(), sm.gcpClientOptions(credsPath)...)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := NewKMSSigner(ctx, sm.kmsClient, sm.kmsKeyName)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := NewVaultSigningIdentityFromCertContext(ctx, mspID, userCert, sm)
+	if err != nil {
+		return nil, err
+	}
+	if !signer.pub.Equal(identity.Key.PubKey) {
+		return nil, fmt.Errorf("key %s does not match the certificate public key", sm.kmsKeyName)
+	}
+	identity.Key.Signer = signer
+
+	return identity, nil
+}
+
+// gcpClientOptions returns options of the Secret Manager and KMS clients authenticating with the credentials file
+func (sm *SecretManager) gcpClientOptions(credsPath string) []option.ClientOption {
+	return append([]option.ClientOption{option.WithCredentialsFile(credsPath)}, sm.clientOptions...)
+}
+
 func (sm *SecretManager) pullSecretCrypto(ctx context.Context, project string, keyName string) error {
 	if keyName != "" {
-		encodedSecretName := encodeSecretName(keyName)
-
-		accessSecretVersionRequest := &secretmanagerpb.AccessSecretVersionRequest{
-			Name: fmt.Sprintf("projects/%s/secrets/%s/versions/latest", project, encodedSecretName),
-		}
-		secretVersion, err := sm.client.AccessSecretVersion(ctx, accessSecretVersionRequest)
+		// crypto is read back from the secret Store writes it to, as stored
+		secretName, _ := sm.secretName(keyName)
+		data, err := sm.readSecret(ctx, secretName)
 		if err != nil {
 			return err
 		}
+		if data == nil {
+			return fmt.Errorf("no accessible version of secret %s", secretName)
+		}
+		return sm.memcache.SetCrypto(keyName, data)
+	}
 
-		secretAsBytes, err := base64.StdEncoding.DecodeString(string(secretVersion.Payload.Data))
-		if err != nil {
-			return err
+	f := newFetcher(ctx, sm.fetchConfig, isTransientGCPError)
+	err := sm.listSecrets(ctx, project, func(secretName string) {
+		if sm.skipCrypto(secretCryptoName(decodeSecretName(secretName))) {
+			return
+		}
+		f.Go(func(ctx context.Context) error {
+			return sm.pullSecret(ctx, secretName)
+		})
+	})
+	if err != nil {
+		f.fail(err)
+	}
+
+	return f.Wait()
+}
+
+// skipCrypto reports whether crypto with name is not read from Secret Manager,
+// private keys are not read with KMS signing enabled
+func (sm *SecretManager) skipCrypto(name string) bool {
+	return sm.kmsKeyName != "" && strings.HasSuffix(name, "_sk")
+}
+
+// indexSecretCrypto lists secrets of project, reads only those matching prefetch patterns
+// and makes the cache load the rest on first access
+func (sm *SecretManager) indexSecretCrypto(ctx context.Context, project string) error {
+	index, err := sm.buildIndex(ctx, project)
+	if err != nil {
+		return err
+	}
+	sm.index = index
+
+	f := newFetcher(ctx, sm.fetchConfig, isTransientGCPError)
+	for name, secretName := range index {
+		if !matchAny(sm.prefetch, name) {
+			continue
+		}
+		secretName := secretName
+		f.Go(func(ctx context.Context) error {
+			return sm.pullSecret(ctx, secretName)
+		})
+	}
+	if err = f.Wait(); err != nil {
+		return err
+	}
+
+	sm.memcache = cryptocache.NewLazyCache(sm.memcache, sm.loadCrypto, sm.indexedNames)
+	return nil
+}
+
+// buildIndex maps names of crypto of project to their secret names
+func (sm *SecretManager) buildIndex(ctx context.Context, project string) (map[string]string, error) {
+	index := make(map[string]string)
+	err := sm.listSecrets(ctx, project, func(secretName string) {
+		name := secretCryptoName(decodeSecretName(secretName))
+		if sm.skipCrypto(name) {
+			return
+		}
+		if old, ok := index[name]; !ok || !keepPath(decodeSecretName(old), decodeSecretName(secretName)) {
+			index[name] = secretName
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// refreshCrypto re-reads crypto from Secret Manager, in lazy mode only crypto already in the cache is re-read
+func (sm *SecretManager) refreshCrypto(ctx context.Context) error {
+	if !sm.lazy {
+		return sm.pullSecretCrypto(ctx, sm.project, "")
+	}
+
+	index, err := sm.buildIndex(ctx, sm.project)
+	if err != nil {
+		return err
+	}
+	sm.indexMu.Lock()
+	sm.index = index
+	sm.indexMu.Unlock()
+
+	lazy, ok := sm.memcache.(*cryptocache.LazyCache)
+	if !ok {
+		return nil
+	}
+	f := newFetcher(ctx, sm.fetchConfig, isTransientGCPError)
+	for _, name := range lazy.LoadedKeys() {
+		secretName, ok := index[name]
+		if !ok {
+			continue
+		}
+		f.Go(func(ctx context.Context) error {
+			return sm.pullSecret(ctx, secretName)
+		})
+	}
+	return f.Wait()
+}
+
+// Close stops the crypto refresh, wipes cached crypto and the private key of the signing identity
+// and closes the Secret Manager and KMS clients. The manager must not be used after Close.
+func (sm *SecretManager) Close() (err error) {
+	sm.closeOnce.Do(func() {
+		close(sm.closeCh)
+		sm.wg.Wait()
+
+		if sm.unwatch != nil {
+			sm.unwatch()
 		}
+		if clearer, ok := sm.memcache.(cryptocache.Clearer); ok {
+			clearer.Clear()
+		}
+		if sm.signingIdentity != nil {
+			sm.signingIdentity.wipe()
+		}
+		err = sm.client.Close()
+		if sm.kmsClient != nil {
+			if kmsErr := sm.kmsClient.Close(); err == nil {
+				err = kmsErr
+			}
+		}
+	})
+	return err
+}
+
+// backendPath returns the decoded name of the secret of crypto with name
+func (sm *SecretManager) backendPath(name string) (string, bool) {
+	sm.indexMu.RLock()
+	defer sm.indexMu.RUnlock()
+	secretName, ok := sm.index[name]
+	return decodeSecretName(secretName), ok
+}
+
+// indexedNames returns names of crypto in Secret Manager, loaded or not
+func (sm *SecretManager) indexedNames() []string {
+	sm.indexMu.RLock()
+	defer sm.indexMu.RUnlock()
+	names := make([]string, 0, len(sm.index))
+	for name := range sm.index {
+		names = append(names, name)
+	}
+	return names
+}
+
+// loadCrypto reads crypto with name from Secret Manager on cache miss, transient failures are retried as by download
+func (sm *SecretManager) loadCrypto(ctx context.Context, name string) ([]byte, error) {
+	sm.indexMu.RLock()
+	secretName, ok := sm.index[name]
+	sm.indexMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no crypto for key %s", name)
+	}
 
-		return sm.memcache.SetCrypto(keyName, secretAsBytes)
+	var data []byte
+	f := newFetcher(ctx, sm.fetchConfig, isTransientGCPError)
+	f.Go(func(ctx context.Context) (err error) {
+		data, err = sm.readSecret(ctx, secretName)
+		return err
+	})
+	if err := f.Wait(); err != nil {
+		return nil, err
 	}
+	if data == nil {
+		return nil, fmt.Errorf("no accessible version of secret %s", secretName)
+	}
+	return data, nil
+}
 
+// listSecrets calls visit with the name of every secret of project
+func (sm *SecretManager) listSecrets(ctx context.Context, project string, visit func(secretName string)) error {
 	listSecretRequest := &secretmanagerpb.ListSecretsRequest{
 		Parent: fmt.Sprintf("projects/%s", project),
 	}
@@ -89,87 +388,171 @@ func (sm *SecretManager) pullSecretCrypto(ctx context.Context, project string, k
 	for {
 		secret, err := secretIterator.Next()
 		if errors.Is(err, iterator.Done) {
-			break
+			return nil
 		}
 
 		if err != nil {
 			return fmt.Errorf("failed to list secret versions: %w", err)
 		}
 
-		accessSecretVersionRequest := &secretmanagerpb.AccessSecretVersionRequest{
-			Name: secret.Name + "/versions/latest",
-		}
-		secretVersion, err := sm.client.AccessSecretVersion(ctx, accessSecretVersionRequest)
-		if err != nil {
-			if strings.Contains(err.Error(), "secretmanager.versions.access") || strings.Contains(err.Error(), "not found or has no versions") {
-				continue
-			}
-			return err
-		}
+		visit(secret.Name)
+	}
+}
 
-		decodedSecretName := decodeSecretName(secret.Name)
+// pullSecret reads the latest version of secret secretName and puts it to the cache
+func (sm *SecretManager) pullSecret(ctx context.Context, secretName string) error {
+	data, err := sm.readSecret(ctx, secretName)
+	if err != nil || data == nil {
+		return err
+	}
 
-		if strings.Contains(decodedSecretName, "/tls/") {
-			tlsIndex := strings.Index(decodedSecretName, "/tls/")
-			secretName := decodedSecretName[tlsIndex-strings.Index(Reverse(decodedSecretName)[len(decodedSecretName)-tlsIndex:], "/"):]
-			if err := sm.memcache.SetCrypto(secretName, secretVersion.Payload.Data); err != nil { // username@org/tls/cryptoname
-				return err
-			}
-		} else {
-			parts := strings.Split(decodedSecretName, "/")
-			if err := sm.memcache.SetCrypto(parts[len(parts)-1], secretVersion.Payload.Data); err != nil {
-				return err
-			}
+	name := secretCryptoName(decodeSecretName(secretName))
+	sm.indexMu.Lock()
+	if sm.index == nil {
+		sm.index = make(map[string]string)
+	}
+	if old, ok := sm.index[name]; !ok || !keepPath(decodeSecretName(old), decodeSecretName(secretName)) {
+		sm.index[name] = secretName
+	}
+	sm.indexMu.Unlock()
+
+	return sm.memcache.SetCrypto(name, data)
+}
+
+// Store adds value as a new version of the secret crypto with name was read from and puts it to the cache.
+// For new crypto a secret with automatic replication is created, named by encodeSecretName.
+func (sm *SecretManager) Store(ctx context.Context, name string, value []byte) error {
+	secretName, exists := sm.secretName(name)
+	if err := checkStoredName(name, secretName, func(secretName string) string {
+		return secretCryptoName(decodeSecretName(secretName))
+	}); err != nil {
+		return err
+	}
+
+	if !exists {
+		_, err := sm.client.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{
+			Parent:   fmt.Sprintf("projects/%s", sm.project),
+			SecretId: encodeSecretName(secretStoreName(name)),
+			Secret: &secretmanagerpb.Secret{
+				Replication: &secretmanagerpb.Replication{
+					Replication: &secretmanagerpb.Replication_Automatic_{Automatic: &secretmanagerpb.Replication_Automatic{}},
+				},
+			},
+		})
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return fmt.Errorf("failed to create secret %s: %w", secretName, err)
 		}
 	}
 
-	return nil
+	_, err := sm.client.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
+		Parent:  secretName,
+		Payload: &secretmanagerpb.SecretPayload{Data: value},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store %s to %s: %w", name, secretName, err)
+	}
+
+	sm.indexMu.Lock()
+	if sm.index == nil {
+		sm.index = make(map[string]string)
+	}
+	sm.index[name] = secretName
+	sm.indexMu.Unlock()
+
+	return sm.memcache.SetCrypto(name, value)
 }
 
-// Reverse reverses a string
-func Reverse(s string) (result string) {
-	for _, v := range s {
-		result = string(v) + result
+// Delete deletes the secret of crypto with name with all its versions and removes the crypto from the cache
+func (sm *SecretManager) Delete(ctx context.Context, name string) error {
+	secretName, _ := sm.secretName(name)
+	err := sm.client.DeleteSecret(ctx, &secretmanagerpb.DeleteSecretRequest{Name: secretName})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("failed to delete %s at %s: %w", name, secretName, err)
 	}
-	return
+
+	sm.indexMu.Lock()
+	delete(sm.index, name)
+	sm.indexMu.Unlock()
+
+	deleteCached(sm.memcache, name)
+	return nil
 }
 
-// Sign signs digest using ecdsaPrivateKey
-func (sm *SecretManager) Sign(digest []byte, ecdsaPrivateKey *ecdsa.PrivateKey, ecdsaPublicKey *ecdsa.PublicKey) ([]byte, error) {
-	r, s, err := ecdsa.Sign(rand.Reader, ecdsaPrivateKey, digest)
-	if err != nil {
-		return nil, err
+// secretName returns the name of the secret crypto with name was read from,
+// the name of the secret encoded by encodeSecretName if it was not
+func (sm *SecretManager) secretName(name string) (secretName string, exists bool) {
+	sm.indexMu.RLock()
+	secretName, exists = sm.index[name]
+	sm.indexMu.RUnlock()
+	if exists {
+		return secretName, true
 	}
+	return fmt.Sprintf("projects/%s/secrets/%s", sm.project, encodeSecretName(secretStoreName(name))), false
+}
 
-	signature, err := utils.ToLowS(ecdsaPublicKey, s)
+// readSecret reads the latest version of secret secretName, returns nil if there is no accessible version
+func (sm *SecretManager) readSecret(ctx context.Context, secretName string) ([]byte, error) {
+	accessSecretVersionRequest := &secretmanagerpb.AccessSecretVersionRequest{
+		Name: secretName + "/versions/latest",
+	}
+	secretVersion, err := sm.client.AccessSecretVersion(ctx, accessSecretVersionRequest)
 	if err != nil {
+		if strings.Contains(err.Error(), "secretmanager.versions.access") || strings.Contains(err.Error(), "not found or has no versions") {
+			return nil, nil
+		}
 		return nil, err
 	}
 
-	return utils.MarshalECDSASignature(r, signature)
+	return secretVersion.Payload.Data, nil
 }
 
-// Verify verifies signature against digest using ecdsaPublicKey
-func (sm *SecretManager) Verify(digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
-	r, s, err := utils.UnmarshalECDSASignature(signature)
-	if err != nil {
-		return fmt.Errorf("failed unmashalling signature [%w]", err)
+// secretCryptoName returns the name under which the secret with decoded name decodedSecretName is cached
+func secretCryptoName(decodedSecretName string) string {
+	if strings.Contains(decodedSecretName, "/tls/") {
+		tlsIndex := strings.Index(decodedSecretName, "/tls/")
+		return decodedSecretName[tlsIndex-strings.Index(Reverse(decodedSecretName)[len(decodedSecretName)-tlsIndex:], "/"):] // username@org/tls/cryptoname
 	}
 
-	lowS, err := utils.IsLowS(ecdsaPublicKey, s)
-	if err != nil {
-		return err
-	}
+	parts := strings.Split(decodedSecretName, "/")
+	return parts[len(parts)-1]
+}
 
-	if !lowS {
-		return fmt.Errorf("invalid S. Must be smaller than half the order [%s][%s]", s, utils.GetCurveHalfOrdersAt(ecdsaPublicKey.Curve))
+// isTransientGCPError reports whether a failed Secret Manager request may succeed on retry
+func isTransientGCPError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
 	}
+}
 
-	if ok := ecdsa.Verify(ecdsaPublicKey, digest, r, s); ok {
-		return nil
+// Reverse reverses a string
+func Reverse(s string) (result string) {
+	for _, v := range s {
+		result = string(v) + result
 	}
+	return
+}
+
+// Sign signs digest with the Signer of key
+func (sm *SecretManager) Sign(digest []byte, key *CartridgeKey) ([]byte, error) {
+	return sm.SignContext(context.Background(), digest, key)
+}
+
+// SignContext is Sign with cancellation by ctx
+func (sm *SecretManager) SignContext(ctx context.Context, digest []byte, key *CartridgeKey) ([]byte, error) {
+	return signWithKey(ctx, digest, key)
+}
+
+// Verify verifies signature against digest using ecdsaPublicKey
+func (sm *SecretManager) Verify(digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return sm.VerifyContext(context.Background(), digest, signature, ecdsaPublicKey)
+}
 
-	return errors.New("invalid signature")
+// VerifyContext is Verify with cancellation by ctx
+func (sm *SecretManager) VerifyContext(ctx context.Context, digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return verifyECDSA(ctx, digest, signature, ecdsaPublicKey)
 }
 
 // SigningIdentity returns signing identity
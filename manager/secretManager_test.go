@@ -0,0 +1,174 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const testGCPProject = "p"
+
+// secretManagerStub serves the parts of the Secret Manager API used by SecretManager
+type secretManagerStub struct {
+	secretmanagerpb.UnimplementedSecretManagerServiceServer
+
+	mu sync.Mutex
+	// secrets maps names of secrets to their versions, latest last
+	secrets map[string][][]byte
+}
+
+// put adds a version of the secret of crypto with decoded name, creating the secret if needed
+func (s *secretManagerStub) put(name string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secretName := "projects/" + testGCPProject + "/secrets/" + encodeSecretName(name)
+	s.secrets[secretName] = append(s.secrets[secretName], value)
+}
+
+func (s *secretManagerStub) latest(secretName string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.secrets[secretName]
+	if len(versions) == 0 {
+		return nil, false
+	}
+	return versions[len(versions)-1], true
+}
+
+func (s *secretManagerStub) ListSecrets(_ context.Context, req *secretmanagerpb.ListSecretsRequest) (*secretmanagerpb.ListSecretsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &secretmanagerpb.ListSecretsResponse{}
+	for secretName := range s.secrets {
+		if strings.HasPrefix(secretName, req.Parent+"/secrets/") {
+			resp.Secrets = append(resp.Secrets, &secretmanagerpb.Secret{Name: secretName})
+		}
+	}
+	sort.Slice(resp.Secrets, func(i, j int) bool { return resp.Secrets[i].Name < resp.Secrets[j].Name })
+	resp.TotalSize = int32(len(resp.Secrets))
+	return resp, nil
+}
+
+func (s *secretManagerStub) AccessSecretVersion(_ context.Context, req *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	secretName := strings.TrimSuffix(req.Name, "/versions/latest")
+	data, ok := s.latest(secretName)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Secret [%s] not found or has no versions.", secretName)
+	}
+	return &secretmanagerpb.AccessSecretVersionResponse{Name: req.Name, Payload: &secretmanagerpb.SecretPayload{Data: data}}, nil
+}
+
+func (s *secretManagerStub) CreateSecret(_ context.Context, req *secretmanagerpb.CreateSecretRequest) (*secretmanagerpb.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secretName := req.Parent + "/secrets/" + req.SecretId
+	if _, ok := s.secrets[secretName]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "Secret [%s] already exists.", secretName)
+	}
+	s.secrets[secretName] = nil
+	return &secretmanagerpb.Secret{Name: secretName}, nil
+}
+
+func (s *secretManagerStub) AddSecretVersion(_ context.Context, req *secretmanagerpb.AddSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions, ok := s.secrets[req.Parent]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Secret [%s] not found.", req.Parent)
+	}
+	s.secrets[req.Parent] = append(versions, req.Payload.Data)
+	return &secretmanagerpb.SecretVersion{Name: req.Parent + "/versions/latest"}, nil
+}
+
+func (s *secretManagerStub) DeleteSecret(_ context.Context, req *secretmanagerpb.DeleteSecretRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[req.Name]; !ok {
+		return nil, status.Errorf(codes.NotFound, "Secret [%s] not found.", req.Name)
+	}
+	delete(s.secrets, req.Name)
+	return &emptypb.Empty{}, nil
+}
+
+// newStubSecretManager returns a manager of User1 of Org1MSP reading secrets from stub
+func newStubSecretManager(t *testing.T, stub *secretManagerStub, opts ...SecretOption) (*SecretManager, error) {
+	t.Helper()
+
+	conn := newStubConn(t, func(srv *grpc.Server) { secretmanagerpb.RegisterSecretManagerServiceServer(srv, stub) })
+	opts = append([]SecretOption{WithSecretClientOptions(option.WithGRPCConn(conn))}, opts...)
+	m, err := NewSecretManager("Org1MSP", testGCPProject, testVaultCert, "", opts...)
+	if err == nil {
+		t.Cleanup(func() { _ = m.Close() })
+	}
+	return m, err
+}
+
+func TestSecretManagerStore(t *testing.T) {
+	stub := &secretManagerStub{secrets: make(map[string][][]byte)}
+	cert, key := newTestUserCert(t, testVaultCert, nil)
+	stub.put(testVaultCert, cert)
+	stub.put(privateKeyName(&key.PublicKey), newTestKeyPEM(t, key))
+
+	m, err := newStubSecretManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &key.PublicKey)
+	ctx := context.Background()
+
+	tlsCert, _ := newTestUserCert(t, testVaultCert, nil)
+	caCert, _, _ := newTestCert(t, "ca.org1.example.com", true, nil, nil)
+	stored := map[string][]byte{
+		"ca.org1.example.com-cert.pem":          caCert,
+		"User1@org1.example.com/tls/client.crt": tlsCert,
+	}
+	for name, value := range stored {
+		if err = m.Store(ctx, name, value); err != nil {
+			t.Fatalf("store %s: %s", name, err)
+		}
+		// crypto is stored as is, not encoded
+		secretName, _ := m.secretName(name)
+		if data, _ := stub.latest(secretName); !bytes.Equal(data, value) {
+			t.Errorf("%s stored as %q", name, data)
+		}
+	}
+
+	// crypto is read back by name as stored
+	for name, value := range stored {
+		deleteCached(m.Cache(), name)
+		if err = m.pullSecretCrypto(ctx, testGCPProject, name); err != nil {
+			t.Fatalf("read %s: %s", name, err)
+		}
+		if data, err := m.Cache().GetCrypto(name); err != nil || !bytes.Equal(data, value) {
+			t.Errorf("%s read back as %q: %v", name, data, err)
+		}
+	}
+
+	// and by listing of secrets
+	reopened, err := newStubSecretManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range stored {
+		if data, err := reopened.Cache().GetCrypto(name); err != nil || !bytes.Equal(data, value) {
+			t.Errorf("%s listed as %q: %v", name, data, err)
+		}
+	}
+
+	if err = m.Delete(ctx, "ca.org1.example.com-cert.pem"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.pullSecretCrypto(ctx, testGCPProject, "ca.org1.example.com-cert.pem"); err == nil {
+		t.Error("deleted crypto read back")
+	}
+}
@@ -0,0 +1,73 @@
+package manager
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+
+	"github.com/hyperledger/fabric/bccsp/utils"
+)
+
+// ContextSigner is a crypto.Signer that can give up when ctx is done
+type ContextSigner interface {
+	crypto.Signer
+	// SignContext signs the SHA-256 digest and returns a DER signature
+	SignContext(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// NewCartridgeKey returns the key handle for signer, e.g. an *ecdsa.PrivateKey held in memory or a KMSSigner
+func NewCartridgeKey(signer crypto.Signer) (*CartridgeKey, error) {
+	if signer == nil {
+		return nil, errors.New("signer must not be nil")
+	}
+	ecdsaPubKey, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("invalid key type, expecting ECDSA Public Key")
+	}
+	return &CartridgeKey{PubKey: ecdsaPubKey, Signer: signer}, nil
+}
+
+// SignECDSA signs the SHA-256 digest the way Manager.Sign did before key handles: with ecdsaPrivateKey
+// when it is set, otherwise with the key of the manager matching ecdsaPublicKey, e.g. a Vault Transit key.
+//
+// Deprecated: use manager.SignContext with a key handle, e.g. from NewCartridgeKey.
+func SignECDSA(ctx context.Context, manager Manager, digest []byte, ecdsaPrivateKey *ecdsa.PrivateKey, ecdsaPublicKey *ecdsa.PublicKey) ([]byte, error) {
+	key := &CartridgeKey{PubKey: ecdsaPublicKey}
+	if ecdsaPrivateKey != nil {
+		key.Signer = ecdsaPrivateKey
+		if key.PubKey == nil {
+			key.PubKey = &ecdsaPrivateKey.PublicKey
+		}
+	}
+	if key.PubKey == nil {
+		return nil, errors.New("public key must not be nil")
+	}
+	return manager.SignContext(ctx, digest, key)
+}
+
+// signWithKey signs the SHA-256 digest with the Signer of key and returns a low-S DER signature
+func signWithKey(ctx context.Context, digest []byte, key *CartridgeKey) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if key == nil || key.Signer == nil {
+		return nil, errors.New("private key is not available")
+	}
+
+	var (
+		sig []byte
+		err error
+	)
+	if signer, ok := key.Signer.(ContextSigner); ok {
+		sig, err = signer.SignContext(ctx, digest)
+	} else {
+		sig, err = key.Signer.Sign(rand.Reader, digest, crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.SignatureToLowS(key.PubKey, sig)
+}
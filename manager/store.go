@@ -0,0 +1,48 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+)
+
+// Storer is implemented by managers that can write crypto back to their backend.
+// Crypto is named as in the cache: <ski>_sk for private keys, <user>/tls/<name> for TLS crypto and
+// the file name otherwise. Backend names are derived by the same rules the manager reads crypto with,
+// so stored crypto is read back under the same name, names that cannot be round-tripped are rejected.
+type Storer interface {
+	// Store writes crypto value with name to the backend and puts it to the cache under name
+	Store(ctx context.Context, name string, value []byte) error
+	// Delete deletes crypto with name from the backend and the cache, missing crypto is not an error
+	Delete(ctx context.Context, name string) error
+}
+
+// checkStoredName checks that crypto name stored under backend name storedName is read back as name
+func checkStoredName(name, storedName string, readName func(storedName string) string) error {
+	if name == "" {
+		return errors.New("crypto name is empty")
+	}
+	if read := readName(storedName); read != name {
+		return fmt.Errorf("crypto %s cannot be stored, it would be read back as %s", name, read)
+	}
+	return nil
+}
+
+// deleteCached removes crypto name from cache if the cache can delete crypto
+func deleteCached(cache cryptocache.CryptoCache, name string) {
+	if deleter, ok := cache.(cryptocache.Deleter); ok {
+		deleter.DeleteCrypto(name)
+	}
+}
+
+// secretStoreName returns the name crypto with name is stored under in backends with flat secret names,
+// before encoding. Readers take TLS crypto from the directory preceding tls, so one is started for it.
+func secretStoreName(name string) string {
+	if strings.Contains(name, "/tls/") && !strings.HasPrefix(name, "/") {
+		return "/" + name
+	}
+	return name
+}
@@ -0,0 +1,405 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+)
+
+// KeyMismatchError is returned when the private key cached for a certificate belongs to another certificate
+type KeyMismatchError struct {
+	CertName string
+}
+
+func (e *KeyMismatchError) Error() string {
+	return fmt.Sprintf("private key does not match the public key of certificate %s", e.CertName)
+}
+
+// ValidityError is returned when a certificate is expired or not valid yet
+type ValidityError struct {
+	CertName  string
+	NotBefore time.Time
+	NotAfter  time.Time
+	At        time.Time
+}
+
+func (e *ValidityError) Error() string {
+	if e.Expired() {
+		return fmt.Sprintf("certificate %s expired at %s", e.CertName, e.NotAfter.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("certificate %s is not valid before %s", e.CertName, e.NotBefore.Format(time.RFC3339))
+}
+
+// Expired reports whether the certificate is expired rather than not valid yet
+func (e *ValidityError) Expired() bool {
+	return e.At.After(e.NotAfter)
+}
+
+// ChainError is returned when a certificate is not issued by the CA certificates of the org
+type ChainError struct {
+	CertName string
+	Err      error
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("certificate %s is not issued by the org CA: %s", e.CertName, e.Err)
+}
+
+// Unwrap returns the error of chain verification
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
+
+// RevokedError is returned when a certificate is listed in a CRL of its issuer
+type RevokedError struct {
+	CertName       string
+	SerialNumber   *big.Int
+	RevocationTime time.Time
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf("certificate %s with serial number %s was revoked at %s", e.CertName, e.SerialNumber, e.RevocationTime.Format(time.RFC3339))
+}
+
+// CRLError is returned when a CRL of the org cannot be parsed
+type CRLError struct {
+	Name string
+	Err  error
+}
+
+func (e *CRLError) Error() string {
+	return fmt.Sprintf("failed to parse CRL %s: %s", e.Name, e.Err)
+}
+
+// Unwrap returns the error of CRL parsing
+func (e *CRLError) Unwrap() error {
+	return e.Err
+}
+
+// validationConfig configures validation of certificates by a manager, managers embed it
+type validationConfig struct {
+	// caNames are names of the CA certificates that are roots of the org in flat layouts
+	caNames []string
+	// skipCRLs turns off revocation checks
+	skipCRLs bool
+}
+
+// validation returns the validation config
+func (c *validationConfig) validation() *validationConfig {
+	return c
+}
+
+// validator is implemented by managers embedding validationConfig
+type validator interface {
+	validation() *validationConfig
+}
+
+// setCANames sets the names of root CA certificates of the org
+func (c *validationConfig) setCANames(names []string) error {
+	if len(names) == 0 {
+		return errors.New("no CA certificate names")
+	}
+	for _, name := range names {
+		if name == "" {
+			return errors.New("CA certificate name must not be empty")
+		}
+	}
+	c.caNames = names
+	return nil
+}
+
+// WithCACertificates makes VaultManager trust only the CA certificates with names, e.g. ca.org1.example.com-cert.pem,
+// as roots of the org when crypto is not read from an MSP directory tree
+func WithCACertificates(names ...string) Option {
+	return func(v *VaultManager) error {
+		return v.setCANames(names)
+	}
+}
+
+// WithCRLCheckDisabled makes VaultManager not check certificates against CRLs
+func WithCRLCheckDisabled() Option {
+	return func(v *VaultManager) error {
+		v.skipCRLs = true
+		return nil
+	}
+}
+
+// WithSecretCACertificates makes SecretManager trust only the CA certificates with names as roots of the org
+func WithSecretCACertificates(names ...string) SecretOption {
+	return func(sm *SecretManager) error {
+		return sm.setCANames(names)
+	}
+}
+
+// WithSecretCRLCheckDisabled makes SecretManager not check certificates against CRLs
+func WithSecretCRLCheckDisabled() SecretOption {
+	return func(sm *SecretManager) error {
+		sm.skipCRLs = true
+		return nil
+	}
+}
+
+// WithAWSCACertificates makes AWSSecretsManager trust only the CA certificates with names as roots of the org
+func WithAWSCACertificates(names ...string) AWSOption {
+	return func(m *AWSSecretsManager) error {
+		return m.setCANames(names)
+	}
+}
+
+// WithAWSCRLCheckDisabled makes AWSSecretsManager not check certificates against CRLs
+func WithAWSCRLCheckDisabled() AWSOption {
+	return func(m *AWSSecretsManager) error {
+		m.skipCRLs = true
+		return nil
+	}
+}
+
+// WithAzureCACertificates makes AzureKeyVaultManager trust only the CA certificates with names as roots of the org
+func WithAzureCACertificates(names ...string) AzureOption {
+	return func(m *AzureKeyVaultManager) error {
+		return m.setCANames(names)
+	}
+}
+
+// WithAzureCRLCheckDisabled makes AzureKeyVaultManager not check certificates against CRLs
+func WithAzureCRLCheckDisabled() AzureOption {
+	return func(m *AzureKeyVaultManager) error {
+		m.skipCRLs = true
+		return nil
+	}
+}
+
+// WithFileCACertificates makes FileManager trust only the CA certificates with names as roots of the org
+// when the user certificate is not read from a signcerts directory
+func WithFileCACertificates(names ...string) FileOption {
+	return func(fm *FileManager) error {
+		return fm.setCANames(names)
+	}
+}
+
+// WithFileCRLCheckDisabled makes FileManager not check certificates against CRLs
+func WithFileCRLCheckDisabled() FileOption {
+	return func(fm *FileManager) error {
+		fm.skipCRLs = true
+		return nil
+	}
+}
+
+// backendPather is implemented by managers that know the backend path crypto was read from
+type backendPather interface {
+	// backendPath returns the slash separated path of crypto with name in the backend
+	backendPath(name string) (string, bool)
+}
+
+// validateCertificate checks that PEM certificate certName is valid now, is issued by a CA certificate
+// of the org with mspID and is not revoked by a CRL of the org. Chain and revocation are checked only if
+// the cache can be listed and holds root CA certificates of the org, see orgCAs. ctx bounds lazy loading of CA certificates.
+func validateCertificate(ctx context.Context, manager Manager, mspID, certName string, cert []byte) error {
+	crt, err := DecodeCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if now.Before(crt.NotBefore) || now.After(crt.NotAfter) {
+		return &ValidityError{CertName: certName, NotBefore: crt.NotBefore, NotAfter: crt.NotAfter, At: now}
+	}
+
+	var config validationConfig
+	if v, ok := manager.(validator); ok {
+		config = *v.validation()
+	}
+
+	rootCAs, intermediateCAs, crls := orgCAs(ctx, manager, config, mspID, certName)
+	if len(rootCAs) == 0 {
+		if len(config.caNames) != 0 {
+			return &ChainError{CertName: certName, Err: fmt.Errorf("CA certificates %s are not cached", strings.Join(config.caNames, ", "))}
+		}
+		if len(intermediateCAs) != 0 {
+			return &ChainError{CertName: certName, Err: errors.New("intermediate CA certificates are cached without a root CA certificate")}
+		}
+		return nil
+	}
+
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	for _, ca := range rootCAs {
+		roots.AddCert(ca)
+	}
+	for _, ca := range intermediateCAs {
+		intermediates.AddCert(ca)
+	}
+
+	chains, err := crt.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return &ChainError{CertName: certName, Err: err}
+	}
+
+	return checkRevocation(certName, crt, chains, crls)
+}
+
+// validateKey checks that ecdsaPrivateKey belongs to ecdsaPublicKey of certificate certName
+func validateKey(certName string, ecdsaPrivateKey *ecdsa.PrivateKey, ecdsaPublicKey *ecdsa.PublicKey) error {
+	if !ecdsaPrivateKey.PublicKey.Equal(ecdsaPublicKey) {
+		return &KeyMismatchError{CertName: certName}
+	}
+	return nil
+}
+
+// namedCRL is a PEM CRL with the name it is cached under
+type namedCRL struct {
+	name string
+	data []byte
+}
+
+// orgCAs returns root and intermediate CA certificates and CRLs of the org with mspID of certificate certName.
+// If the manager read certName from the signcerts directory of an MSP, roots are taken only from cacerts,
+// intermediates from intermediatecerts and CRLs from crls directories. Otherwise, e.g. for flat layouts,
+// roots are the CA certificates named by config or, if there are none, self-signed CA certificates with mspID
+// as a segment of their path, e.g. Org1MSP/cacerts/ca.pem. CA certificates of other orgs are never roots,
+// other CA certificates are intermediates. Intermediates are dropped if there is no root. TLS crypto and
+// TLS CA certificates named tlsca.* by cryptogen are not used. CRLs are not returned if config skips them.
+func orgCAs(ctx context.Context, manager Manager, config validationConfig, mspID, certName string) (roots, intermediates []*x509.Certificate, crls []namedCRL) {
+	cache := manager.Cache()
+	lister, ok := cache.(cryptocache.Lister)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	pather, inMSP := manager.(backendPather)
+	if inMSP {
+		certPath, known := pather.backendPath(certName)
+		inMSP = known && dirName(certPath) == "signcerts"
+	}
+
+	for _, key := range lister.Keys() {
+		// TLS crypto is cached under paths
+		if strings.Contains(key, "/") || strings.HasSuffix(key, "_sk") {
+			continue
+		}
+		dir, keyPath, known := "", key, false
+		if pather != nil {
+			if p, ok := pather.backendPath(key); ok {
+				keyPath, known = p, true
+			}
+		}
+		if inMSP {
+			if !known {
+				continue
+			}
+			dir = dirName(keyPath)
+			if !isMSPCADir(dir) {
+				continue
+			}
+		} else if strings.HasPrefix(key, "tlsca") {
+			continue
+		}
+
+		data, err := cryptocache.GetCryptoContext(ctx, cache, key)
+		if err != nil {
+			continue
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			continue
+		}
+		switch block.Type {
+		case "X509 CRL":
+			if !config.skipCRLs && (dir == "" || dir == "crls") {
+				crls = append(crls, namedCRL{name: key, data: data})
+			}
+		case "CERTIFICATE":
+			crt, err := x509.ParseCertificate(block.Bytes)
+			if err != nil || !crt.IsCA {
+				continue
+			}
+			switch {
+			case dir == "cacerts", dir == "" && isFlatRoot(config, mspID, key, keyPath, crt):
+				roots = append(roots, crt)
+			case dir == "intermediatecerts", dir == "" && crt.CheckSignatureFrom(crt) != nil:
+				intermediates = append(intermediates, crt)
+			}
+		}
+	}
+
+	if !inMSP && len(roots) == 0 {
+		intermediates = nil
+	}
+	return roots, intermediates, crls
+}
+
+// isFlatRoot reports whether CA certificate crt cached under name and read from backend path keyPath
+// is a root of the org with mspID in a flat layout
+func isFlatRoot(config validationConfig, mspID, name, keyPath string, crt *x509.Certificate) bool {
+	if len(config.caNames) != 0 {
+		for _, caName := range config.caNames {
+			if caName == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	if mspID == "" || crt.CheckSignatureFrom(crt) != nil {
+		return false
+	}
+	for _, segment := range strings.Split(keyPath, "/") {
+		if strings.EqualFold(segment, mspID) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMSPCADir reports whether MSP directory dir holds CA certificates or CRLs
+func isMSPCADir(dir string) bool {
+	return dir == "cacerts" || dir == "intermediatecerts" || dir == "crls"
+}
+
+// keepPath reports whether crypto indexed as read from path old keeps it when it is read from path p as well.
+// Crypto with the same name is read from several directories, e.g. cryptogen puts the CA certificate
+// to ca and to every msp/cacerts, the path in an MSP CA directory is kept for orgCAs.
+func keepPath(old, p string) bool {
+	return isMSPCADir(dirName(old)) && !isMSPCADir(dirName(p))
+}
+
+// dirName returns the name of the directory of slash separated path p, e.g. cacerts for MSP crypto
+func dirName(p string) string {
+	return path.Base(path.Dir(p))
+}
+
+// checkRevocation checks crt against CRLs signed by its issuer in any of verified chains
+func checkRevocation(certName string, crt *x509.Certificate, chains [][]*x509.Certificate, crls []namedCRL) error {
+	for _, named := range crls {
+		crl, err := x509.ParseCRL(named.data) //nolint:staticcheck
+		if err != nil {
+			return &CRLError{Name: named.name, Err: err}
+		}
+
+		for _, chain := range chains {
+			if len(chain) < 2 || chain[1].CheckCRLSignature(crl) != nil { //nolint:staticcheck
+				continue
+			}
+			for _, revoked := range crl.TBSCertList.RevokedCertificates {
+				if revoked.SerialNumber.Cmp(crt.SerialNumber) == 0 {
+					return &RevokedError{CertName: certName, SerialNumber: crt.SerialNumber, RevocationTime: revoked.RevocationTime}
+				}
+			}
+		}
+	}
+
+	return nil
+}
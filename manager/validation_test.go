@@ -0,0 +1,378 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testMSP writes files of an MSP tree under dir, keys are paths relative to dir
+func testMSP(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+
+	for name, data := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testCA is a CA certificate with its key
+type testCA struct {
+	pem []byte
+	crt *x509.Certificate
+	key *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, cn string, parent *testCA) testCA {
+	t.Helper()
+
+	var ca testCA
+	if parent == nil {
+		ca.pem, ca.crt, ca.key = newTestCert(t, cn, true, nil, nil)
+	} else {
+		ca.pem, ca.crt, ca.key = newTestCert(t, cn, true, parent.crt, parent.key)
+	}
+	return ca
+}
+
+const testUserMSP = "org1.example.com/users/User1@org1.example.com/msp/"
+
+func TestValidateCertificateMSPRoots(t *testing.T) {
+	org1 := newTestCA(t, "ca.org1.example.com", nil)
+	org2 := newTestCA(t, "ca.org2.example.com", nil)
+
+	tests := []struct {
+		name   string
+		issuer testCA
+		files  func(t *testing.T) map[string][]byte
+		chain  bool
+	}{
+		{
+			name:   "issued by cacerts",
+			issuer: org1,
+			files: func(t *testing.T) map[string][]byte {
+				return map[string][]byte{
+					// cryptogen puts the CA certificate to ca as well
+					"org1.example.com/ca/ca.org1.example.com-cert.pem":          org1.pem,
+					"org1.example.com/msp/cacerts/ca.org1.example.com-cert.pem": org1.pem,
+					testUserMSP + "cacerts/ca.org1.example.com-cert.pem":        org1.pem,
+				}
+			},
+		},
+		{
+			name:   "issued by CA of another org",
+			issuer: org2,
+			files: func(t *testing.T) map[string][]byte {
+				return map[string][]byte{
+					testUserMSP + "cacerts/ca.org1.example.com-cert.pem": org1.pem,
+					"org2.example.com/ca/ca.org2.example.com-cert.pem":   org2.pem,
+				}
+			},
+			chain: true,
+		},
+		{
+			name:   "intermediates without root",
+			issuer: org1,
+			files: func(t *testing.T) map[string][]byte {
+				ica := newTestCA(t, "ica.org1.example.com", &org1)
+				return map[string][]byte{
+					testUserMSP + "intermediatecerts/ica.org1.example.com-cert.pem": ica.pem,
+				}
+			},
+			chain: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certPEM, _, key := newTestCert(t, "User1@org1.example.com", false, test.issuer.crt, test.issuer.key)
+			files := test.files(t)
+			files[testUserMSP+"signcerts/User1@org1.example.com-cert.pem"] = certPEM
+			files[testUserMSP+"keystore/priv_sk"] = newTestKeyPEM(t, key)
+			dir := t.TempDir()
+			testMSP(t, dir, files)
+
+			m, err := NewFileManager("Org1MSP", "User1@org1.example.com-cert.pem", dir)
+			var chainErr *ChainError
+			if test.chain {
+				if !errors.As(err, &chainErr) {
+					t.Fatalf("err = %v, want ChainError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = m.Close()
+		})
+	}
+}
+
+// putFlatIdentity puts the certificate of User1 issued by ca and its private key to dir under kv
+func putFlatIdentity(t *testing.T, stub *vaultStub, dir string, ca testCA) {
+	t.Helper()
+
+	certPEM, _, key := newTestCert(t, "User1@org1.example.com", false, ca.crt, ca.key)
+	stub.put(joinStubPath(dir, testVaultCert), certPEM)
+	stub.put(joinStubPath(dir, privateKeyName(&key.PublicKey)), newTestKeyPEM(t, key))
+}
+
+func TestValidateCertificateFlatRoots(t *testing.T) {
+	org1 := newTestCA(t, "ca.org1.example.com", nil)
+	org2 := newTestCA(t, "ca.org2.example.com", nil)
+
+	tests := []struct {
+		name   string
+		issuer testCA
+		cas    map[string][]byte
+		opts   []Option
+		chain  bool
+	}{
+		{
+			name:   "issued by CA under MSP ID",
+			issuer: org1,
+			cas: map[string][]byte{
+				"Org1MSP/cacerts/ca.org1.example.com-cert.pem": org1.pem,
+				"Org2MSP/cacerts/ca.org2.example.com-cert.pem": org2.pem,
+			},
+		},
+		{
+			name:   "issued by CA of another org under its MSP ID",
+			issuer: org2,
+			cas: map[string][]byte{
+				"Org1MSP/cacerts/ca.org1.example.com-cert.pem": org1.pem,
+				"Org2MSP/cacerts/ca.org2.example.com-cert.pem": org2.pem,
+			},
+			chain: true,
+		},
+		{
+			name:   "issued by configured CA",
+			issuer: org1,
+			cas: map[string][]byte{
+				"org1/ca.org1.example.com-cert.pem": org1.pem,
+				"org1/ca.org2.example.com-cert.pem": org2.pem,
+			},
+			opts: []Option{WithCACertificates("ca.org1.example.com-cert.pem")},
+		},
+		{
+			name:   "issued by CA of another org in the same directory",
+			issuer: org2,
+			cas: map[string][]byte{
+				"org1/ca.org1.example.com-cert.pem": org1.pem,
+				"org1/ca.org2.example.com-cert.pem": org2.pem,
+			},
+			opts:  []Option{WithCACertificates("ca.org1.example.com-cert.pem")},
+			chain: true,
+		},
+		{
+			name:   "configured CA not cached",
+			issuer: org1,
+			cas:    map[string][]byte{"org1/ca.org1.example.com-cert.pem": org1.pem},
+			opts:   []Option{WithCACertificates("ca.example.com-cert.pem")},
+			chain:  true,
+		},
+		{
+			// self-signed CA certificates are not roots of the org by themselves, the chain is not checked
+			name:   "no CA of the org",
+			issuer: org2,
+			cas:    map[string][]byte{"org1/ca.org2.example.com-cert.pem": org2.pem},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := newVaultStub(kvVersion2)
+			putFlatIdentity(t, stub, "org1", test.issuer)
+			for secretPath, ca := range test.cas {
+				stub.put(secretPath, ca)
+			}
+
+			_, err := newStubVaultManager(t, stub, test.opts...)
+			var chainErr *ChainError
+			if test.chain {
+				if !errors.As(err, &chainErr) {
+					t.Fatalf("err = %v, want ChainError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	if _, err := newStubVaultManager(t, newVaultStub(kvVersion2), WithCACertificates()); err == nil {
+		t.Error("empty list of CA certificates accepted")
+	}
+}
+
+func TestValidateKeyMismatch(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	cert, key := newTestUserCert(t, testVaultCert, nil)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.put("org1/"+testVaultCert, cert)
+	stub.put("org1/"+privateKeyName(&key.PublicKey), newTestKeyPEM(t, other))
+
+	_, err = newStubVaultManager(t, stub)
+	var mismatchErr *KeyMismatchError
+	if !errors.As(err, &mismatchErr) || mismatchErr.CertName != testVaultCert {
+		t.Errorf("err = %v, want KeyMismatchError", err)
+	}
+}
+
+// newTestCertValidity returns a PEM certificate of User1 issued by ca valid from notBefore to notAfter
+func newTestCertValidity(t *testing.T, ca testCA, notBefore, notAfter time.Time) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "User1@org1.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.crt, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key
+}
+
+func TestValidateCertificateValidity(t *testing.T) {
+	ca := newTestCA(t, "ca.org1.example.com", nil)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		expired   bool
+	}{
+		{name: "expired", notBefore: now.Add(-2 * time.Hour), notAfter: now.Add(-time.Hour), expired: true},
+		{name: "not valid yet", notBefore: now.Add(time.Hour), notAfter: now.Add(2 * time.Hour)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cert, key := newTestCertValidity(t, ca, test.notBefore, test.notAfter)
+			dir := t.TempDir()
+			testMSP(t, dir, map[string][]byte{
+				"signcerts/cert.pem":  cert,
+				"keystore/priv_sk":    newTestKeyPEM(t, key),
+				"cacerts/ca-cert.pem": ca.pem,
+			})
+
+			_, err := NewFileManager("Org1MSP", "cert.pem", dir)
+			var validityErr *ValidityError
+			if !errors.As(err, &validityErr) {
+				t.Fatalf("err = %v, want ValidityError", err)
+			}
+			if validityErr.Expired() != test.expired {
+				t.Errorf("expired = %t, want %t", validityErr.Expired(), test.expired)
+			}
+			if !validityErr.NotAfter.Equal(test.notAfter.Truncate(time.Second)) {
+				t.Errorf("not after %s, want %s", validityErr.NotAfter, test.notAfter)
+			}
+		})
+	}
+}
+
+// newTestCRL returns a PEM CRL of ca revoking serials
+func newTestCRL(t *testing.T, ca testCA, serials ...*big.Int) []byte {
+	t.Helper()
+
+	revoked := make([]pkix.RevokedCertificate, 0, len(serials))
+	for _, serial := range serials {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: time.Now().Add(-time.Minute)})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          time.Now().Add(-time.Hour),
+		NextUpdate:          time.Now().Add(time.Hour),
+		RevokedCertificates: revoked,
+	}, ca.crt, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func TestValidateCertificateRevocation(t *testing.T) {
+	org1 := newTestCA(t, "ca.org1.example.com", nil)
+	org2 := newTestCA(t, "ca.org2.example.com", nil)
+	cert, crt, key := newTestCert(t, "User1@org1.example.com", false, org1.crt, org1.key)
+	files := map[string][]byte{
+		"signcerts/cert.pem":  cert,
+		"keystore/priv_sk":    newTestKeyPEM(t, key),
+		"cacerts/ca-cert.pem": org1.pem,
+	}
+
+	tests := []struct {
+		name string
+		crls map[string][]byte
+		opts []FileOption
+		err  interface{}
+	}{
+		{name: "not revoked", crls: map[string][]byte{"crls/crl.pem": newTestCRL(t, org1, big.NewInt(1))}},
+		{name: "revoked", crls: map[string][]byte{"crls/crl.pem": newTestCRL(t, org1, crt.SerialNumber)}, err: new(*RevokedError)},
+		// the serial number is revoked by a CRL of another CA
+		{name: "revoked by another CA", crls: map[string][]byte{"crls/crl.pem": newTestCRL(t, org2, crt.SerialNumber)}},
+		{
+			name: "CRL check disabled",
+			crls: map[string][]byte{"crls/crl.pem": newTestCRL(t, org1, crt.SerialNumber)},
+			opts: []FileOption{WithFileCRLCheckDisabled()},
+		},
+		{
+			name: "invalid CRL",
+			crls: map[string][]byte{"crls/bad.pem": pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: []byte("not a CRL")})},
+			err:  new(*CRLError),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			testMSP(t, dir, files)
+			testMSP(t, dir, test.crls)
+
+			m, err := NewFileManager("Org1MSP", "cert.pem", dir, test.opts...)
+			if test.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = m.Close()
+				return
+			}
+			if !errors.As(err, test.err) {
+				t.Fatalf("err = %v, want %T", err, test.err)
+			}
+		})
+	}
+
+	var revokedErr *RevokedError
+	dir := t.TempDir()
+	testMSP(t, dir, files)
+	testMSP(t, dir, map[string][]byte{"crls/crl.pem": newTestCRL(t, org1, crt.SerialNumber)})
+	if _, err := NewFileManager("Org1MSP", "cert.pem", dir); !errors.As(err, &revokedErr) || revokedErr.SerialNumber.Cmp(crt.SerialNumber) != 0 {
+		t.Errorf("err = %v, want RevokedError of serial number %s", err, crt.SerialNumber)
+	}
+}
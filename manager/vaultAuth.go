@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const defaultKubernetesJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token" //nolint:gosec
+
+// AuthMethod logs in to Vault and returns the secret holding the client token
+type AuthMethod interface {
+	Login(ctx context.Context, client *vault.Client) (*vault.Secret, error)
+}
+
+// WithAuth makes VaultManager log in with method at construction and every time the token expires.
+// The static token passed to NewVaultManager is ignored.
+func WithAuth(method AuthMethod) Option {
+	return func(v *VaultManager) error {
+		if method == nil {
+			return errors.New("auth method must not be nil")
+		}
+		v.auth = method
+		return nil
+	}
+}
+
+// WithTLSConfig configures TLS of the Vault client, e.g. the client certificate used by CertAuth
+func WithTLSConfig(tlsConfig *vault.TLSConfig) Option {
+	return func(v *VaultManager) error {
+		return v.config.ConfigureTLS(tlsConfig)
+	}
+}
+
+// AppRoleAuth authenticates with AppRole role_id and secret_id
+type AppRoleAuth struct {
+	// MountPath of the auth method, "approle" if empty
+	MountPath string
+	RoleID    string
+	SecretID  string
+}
+
+// Login logs in to Vault
+func (a *AppRoleAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	return login(ctx, client, loginPath(a.MountPath, "approle"), map[string]interface{}{
+		"role_id":   a.RoleID,
+		"secret_id": a.SecretID,
+	})
+}
+
+// KubernetesAuth authenticates with the Kubernetes service account JWT
+type KubernetesAuth struct {
+	// MountPath of the auth method, "kubernetes" if empty
+	MountPath string
+	Role      string
+	// JWTPath is the service account token file, the in-cluster token if empty.
+	// The file is re-read on every login as Kubernetes rotates the token.
+	JWTPath string
+}
+
+// Login logs in to Vault
+func (a *KubernetesAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	jwtPath := a.JWTPath
+	if jwtPath == "" {
+		jwtPath = defaultKubernetesJWTPath
+	}
+	jwt, err := os.ReadFile(jwtPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+
+	return login(ctx, client, loginPath(a.MountPath, "kubernetes"), map[string]interface{}{
+		"role": a.Role,
+		"jwt":  string(jwt),
+	})
+}
+
+// CertAuth authenticates with the TLS client certificate configured by WithTLSConfig
+type CertAuth struct {
+	// MountPath of the auth method, "cert" if empty
+	MountPath string
+	// Name of the certificate role, optional
+	Name string
+}
+
+// Login logs in to Vault
+func (a *CertAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	data := map[string]interface{}{}
+	if a.Name != "" {
+		data["name"] = a.Name
+	}
+	return login(ctx, client, loginPath(a.MountPath, "cert"), data)
+}
+
+// UserpassAuth authenticates with username and password
+type UserpassAuth struct {
+	// MountPath of the auth method, "userpass" if empty
+	MountPath string
+	Username  string
+	Password  string
+}
+
+// Login logs in to Vault
+func (a *UserpassAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	return login(ctx, client, path.Join(loginPath(a.MountPath, "userpass"), a.Username), map[string]interface{}{
+		"password": a.Password,
+	})
+}
+
+func loginPath(mountPath, defaultMountPath string) string {
+	if mountPath == "" {
+		mountPath = defaultMountPath
+	}
+	return path.Join("auth", mountPath, "login")
+}
+
+func login(ctx context.Context, client *vault.Client, loginPath string, data map[string]interface{}) (*vault.Secret, error) {
+	secret, err := logicalRequest(ctx, client, http.MethodPut, loginPath, nil, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("no client token returned by %s", loginPath)
+	}
+	return secret, nil
+}
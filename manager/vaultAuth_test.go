@@ -0,0 +1,192 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+func TestVaultAuthLogin(t *testing.T) {
+	jwtPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwtPath, []byte("service account jwt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method AuthMethod
+		path   string
+		data   map[string]interface{}
+	}{
+		{
+			name:   "approle",
+			method: &AppRoleAuth{RoleID: "role", SecretID: "secret"},
+			path:   "auth/approle/login",
+			data:   map[string]interface{}{"role_id": "role", "secret_id": "secret"},
+		},
+		{
+			name:   "approle mount path",
+			method: &AppRoleAuth{MountPath: "ci", RoleID: "role", SecretID: "secret"},
+			path:   "auth/ci/login",
+			data:   map[string]interface{}{"role_id": "role", "secret_id": "secret"},
+		},
+		{
+			name:   "kubernetes",
+			method: &KubernetesAuth{Role: "cartridge", JWTPath: jwtPath},
+			path:   "auth/kubernetes/login",
+			data:   map[string]interface{}{"role": "cartridge", "jwt": "service account jwt"},
+		},
+		{
+			name:   "userpass",
+			method: &UserpassAuth{Username: "user1", Password: "password"},
+			path:   "auth/userpass/login/user1",
+			data:   map[string]interface{}{"password": "password"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := newVaultStub(kvVersion2)
+			key := stub.putIdentity(t, "org1", testVaultCert, nil)
+
+			// the static token is ignored with an auth method
+			m, err := NewVaultManager("Org1MSP", testVaultCert, stub.start(t), "ignored", "kv", WithAuth(test.method))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			checkSigned(t, m, &key.PublicKey)
+
+			logins := stub.loginRequests()
+			if len(logins) != 1 {
+				t.Fatalf("%d logins, want 1", len(logins))
+			}
+			if login := logins[0]; login.path != test.path || !reflect.DeepEqual(login.data, test.data) {
+				t.Errorf("login to %s with %v, want %s with %v", login.path, login.data, test.path, test.data)
+			}
+		})
+	}
+}
+
+func TestVaultAuthLoginFailure(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.fail("auth/approle/login", 1)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+
+	_, err := newStubVaultManager(t, stub, WithAuth(&AppRoleAuth{RoleID: "role", SecretID: "secret"}))
+	if err == nil {
+		t.Fatal("manager created with a failed login")
+	}
+
+	if _, err = newStubVaultManager(t, stub, WithAuth(&KubernetesAuth{JWTPath: filepath.Join(t.TempDir(), "missing")})); err == nil {
+		t.Error("manager created without a service account token")
+	}
+}
+
+func TestVaultCertAuth(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	key := stub.putIdentity(t, "org1", testVaultCert, nil)
+
+	server := httptest.NewUnstartedServer(stub)
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeFile := func(name string, data []byte) string {
+		filePath := filepath.Join(dir, name)
+		if err := os.WriteFile(filePath, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return filePath
+	}
+	clientCert, _, clientKey := newTestCert(t, "cartridge", false, nil, nil)
+	tlsConfig := &vault.TLSConfig{
+		CACert:     writeFile("ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+		ClientCert: writeFile("client.pem", clientCert),
+		ClientKey:  writeFile("client.key", newTestKeyPEM(t, clientKey)),
+	}
+
+	m, err := NewVaultManager("Org1MSP", testVaultCert, server.URL, "", "kv",
+		WithTLSConfig(tlsConfig), WithAuth(&CertAuth{Name: "cartridge"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	checkSigned(t, m, &key.PublicKey)
+
+	logins := stub.loginRequests()
+	if len(logins) != 1 {
+		t.Fatalf("%d logins, want 1", len(logins))
+	}
+	login := logins[0]
+	if login.path != "auth/cert/login" || login.data["name"] != "cartridge" {
+		t.Errorf("login to %s with %v", login.path, login.data)
+	}
+	if !login.clientCert {
+		t.Error("login without the client certificate")
+	}
+}
+
+func TestVaultReauth(t *testing.T) {
+	jwtPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwtPath, []byte("jwt 1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stub := newVaultStub(kvVersion2)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+	m, err := newStubVaultManager(t, stub, WithAuth(&KubernetesAuth{Role: "cartridge", JWTPath: jwtPath}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Kubernetes rotated the service account token and Vault revoked the client token
+	if err = os.WriteFile(jwtPath, []byte("jwt 2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stub.revokeToken()
+	if err = m.Store(context.Background(), "ca.org1.example.com-cert.pem", []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.logins) != 2 {
+		t.Fatalf("%d logins, want 2", len(stub.logins))
+	}
+	if jwt := stub.logins[1].data["jwt"]; jwt != "jwt 2" {
+		t.Errorf("login again with jwt %v", jwt)
+	}
+	if value := stub.secrets["ca.org1.example.com-cert.pem"]; len(value) != 1 || string(value[0]) != "certificate" {
+		t.Errorf("stored %q", value)
+	}
+}
+
+func TestVaultStaticTokenRejected(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub.revokeToken()
+	err = m.Store(context.Background(), "ca.org1.example.com-cert.pem", []byte("certificate"))
+	var respErr *vault.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusForbidden {
+		t.Errorf("err = %v, want 403 without an auth method", err)
+	}
+	if logins := stub.loginRequests(); len(logins) != 0 {
+		t.Errorf("%d logins without an auth method", len(logins))
+	}
+}
@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/sha256"
 	"crypto/x509"
@@ -8,19 +9,47 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
-	"github.com/golang/protobuf/proto" //nolint:staticcheck
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	"github.com/golang/protobuf/proto" //nolint:staticcheck // fabric-protos-go v0.2.0 messages implement the v1 API only
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
-	"github.com/hyperledger/fabric/bccsp/utils"
+	"github.com/sirupsen/logrus"
 )
 
-// VaultIdentity is an interface that provides access to the identity
+// VaultIdentity is an interface that provides access to the identity.
+// It is serialized as the Fabric msp.SerializedIdentity, the creator of proposals and transactions.
 type VaultIdentity struct {
 	MSPID   string        `protobuf:"bytes,1,opt,name=mspid,proto3" json:"mspid,omitempty"`
 	IDBytes []byte        `protobuf:"bytes,2,opt,name=idBytes,proto3" json:"idBytes,omitempty"`
 	Manager Manager       `json:"-"`
 	Key     *CartridgeKey `json:"-"`
+	// IDSource takes the ID from the certificate, IDFromCommonName if nil
+	IDSource IDSource `json:"-"`
+}
+
+// DeserializeIdentity reconstructs the identity serialized as msp.SerializedIdentity, e.g. the creator
+// of a transaction or the signer of a block, to verify its signatures. The identity has no manager and
+// verifies signatures locally. Its certificate is not validated against the MSP of the channel.
+func DeserializeIdentity(serialized []byte) (*VaultIdentity, error) {
+	sid := &mspproto.SerializedIdentity{}
+	if err := proto.Unmarshal(serialized, sid); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal serialized identity: %w", err)
+	}
+
+	ecdsaPubKey, err := parseCertificate(sid.IdBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate of serialized identity: %w", err)
+	}
+
+	return &VaultIdentity{
+		MSPID:   sid.Mspid,
+		IDBytes: sid.IdBytes,
+		Key:     &CartridgeKey{PubKey: ecdsaPubKey},
+	}, nil
 }
 
 // Reset resets struct
@@ -36,10 +65,11 @@ func (m *VaultIdentity) String() string {
 // ProtoMessage indicates the identity is Protobuf serializable
 func (m *VaultIdentity) ProtoMessage() {}
 
-// Identifier returns the identifier of that identity
+// Identifier returns the identifier of that identity.
+// The ID is taken from the certificate by IDSource, it is the MSP ID if the certificate has no such ID.
 func (m *VaultIdentity) Identifier() *msp.IdentityIdentifier {
 	return &msp.IdentityIdentifier{
-		ID:    m.MSPID,
+		ID:    m.id(),
 		MSPID: m.MSPID,
 	}
 }
@@ -47,16 +77,15 @@ func (m *VaultIdentity) Identifier() *msp.IdentityIdentifier {
 // Verify a signature over some message using this identity as reference
 func (m *VaultIdentity) Verify(msg []byte, sig []byte) error {
 	hash := sha256.Sum256(msg)
+	if m.Manager == nil {
+		return verifyECDSA(context.Background(), hash[:], sig, m.Key.PubKey)
+	}
 	return m.Manager.Verify(hash[:], sig, m.Key.PubKey)
 }
 
-// Serialize converts an identity to bytes
+// Serialize converts an identity to bytes of msp.SerializedIdentity
 func (m *VaultIdentity) Serialize() ([]byte, error) {
-	ident, err := proto.Marshal(m)
-	if err != nil {
-		return nil, err
-	}
-	return ident, nil
+	return proto.Marshal(&mspproto.SerializedIdentity{Mspid: m.MSPID, IdBytes: m.IDBytes})
 }
 
 // EnrollmentCertificate Returns the underlying ECert representing this user’s identity.
@@ -64,25 +93,121 @@ func (m *VaultIdentity) EnrollmentCertificate() []byte {
 	return m.IDBytes
 }
 
-// VaultSigningIdentity represents singing identity using Manager
+// VaultSigningIdentity represents singing identity using Manager.
+// It is reloaded when its certificate changes in a cache it watches.
 type VaultSigningIdentity struct {
 	*VaultIdentity
+
+	certName string
+	remote   bool // the private key is not held in memory
+	mu       sync.RWMutex
+	pending  *VaultIdentity // identity with a new certificate waiting for its private key
 }
 
-// NewVaultSigningIdentity initializes VaultSigningIdentity
+// NewVaultSigningIdentity initializes VaultSigningIdentity.
+// The certificate must match the private key, be valid now and, if the cache holds CA certificates of the org,
+// be issued by them and not revoked by a CRL in the cache. Failures are reported as KeyMismatchError,
+// ValidityError, ChainError, RevokedError and CRLError.
 func NewVaultSigningIdentity(mspid, certname string, manager Manager) (*VaultSigningIdentity, error) {
+	return NewVaultSigningIdentityContext(context.Background(), mspid, certname, manager)
+}
+
+// NewVaultSigningIdentityContext is NewVaultSigningIdentity with ctx bounding lazy loading of crypto
+func NewVaultSigningIdentityContext(ctx context.Context, mspid, certname string, manager Manager) (*VaultSigningIdentity, error) {
 	cache := manager.Cache()
 
-	cert, err := cache.GetCrypto(certname)
+	cert, ecdsaPubKey, err := loadCertificate(ctx, cache, certname)
+	if err != nil {
+		return nil, err
+	}
+
+	privatekey, err := cryptocache.GetCryptoContext(ctx, cache, privateKeyName(ecdsaPubKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find private key in memory, %w", err)
+	}
+
+	pkECDSA, err := parsePrivateKey(privatekey)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateKey(certname, pkECDSA, ecdsaPubKey); err != nil {
+		return nil, err
+	}
+	if err = validateCertificate(ctx, manager, mspid, certname, cert); err != nil {
+		return nil, err
+	}
+
+	identity := &VaultSigningIdentity{
+		VaultIdentity: &VaultIdentity{
+			MSPID:   mspid,
+			Manager: manager,
+			Key:     &CartridgeKey{PubKey: ecdsaPubKey, Signer: pkECDSA},
+			IDBytes: cert,
+		},
+		certName: certname,
+	}
+
+	return identity, nil
+}
+
+// NewVaultSigningIdentityFromCert initializes VaultSigningIdentity from the certificate only.
+// It is used by managers that sign remotely and never hold the private key in memory.
+// The certificate is validated as by NewVaultSigningIdentity, except for the private key.
+func NewVaultSigningIdentityFromCert(mspid, certname string, manager Manager) (*VaultSigningIdentity, error) {
+	return NewVaultSigningIdentityFromCertContext(context.Background(), mspid, certname, manager)
+}
+
+// NewVaultSigningIdentityFromCertContext is NewVaultSigningIdentityFromCert with ctx bounding lazy loading of crypto
+func NewVaultSigningIdentityFromCertContext(ctx context.Context, mspid, certname string, manager Manager) (*VaultSigningIdentity, error) {
+	cert, ecdsaPubKey, err := loadCertificate(ctx, manager.Cache(), certname)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find certificate in memory, %w", err)
+		return nil, err
+	}
+	if err = validateCertificate(ctx, manager, mspid, certname, cert); err != nil {
+		return nil, err
+	}
+
+	identity := &VaultSigningIdentity{
+		VaultIdentity: &VaultIdentity{
+			MSPID:   mspid,
+			Manager: manager,
+			Key:     &CartridgeKey{PubKey: ecdsaPubKey},
+			IDBytes: cert,
+		},
+		certName: certname,
+		remote:   true,
 	}
 
+	return identity, nil
+}
+
+// loadCertificate reads PEM certificate certname from cache and returns it with its ECDSA public key
+func loadCertificate(ctx context.Context, cache cryptocache.CryptoCache, certname string) ([]byte, *ecdsa.PublicKey, error) {
+	cert, err := cryptocache.GetCryptoContext(ctx, cache, certname)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find certificate in memory, %w", err)
+	}
+
+	ecdsaPubKey, err := parseCertificate(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, ecdsaPubKey, nil
+}
+
+// DecodeCertificate parses PEM certificate cert
+func DecodeCertificate(cert []byte) (*x509.Certificate, error) {
 	block, _ := pem.Decode(cert)
 	if block == nil {
 		return nil, errors.New("cannot decode cert")
 	}
-	pubCrt, err := x509.ParseCertificate(block.Bytes)
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parseCertificate returns the ECDSA public key of PEM certificate cert
+func parseCertificate(cert []byte) (*ecdsa.PublicKey, error) {
+	pubCrt, err := DecodeCertificate(cert)
 	if err != nil {
 		return nil, err
 	}
@@ -91,13 +216,11 @@ func NewVaultSigningIdentity(mspid, certname string, manager Manager) (*VaultSig
 		return nil, errors.New("invalid key type, expecting ECDSA Public Key")
 	}
 
-	key := &CartridgeKey{PubKey: ecdsaPubKey}
-
-	privatekey, err := cache.GetCrypto(fmt.Sprintf("%s_sk", hex.EncodeToString(key.SKI())))
-	if err != nil {
-		return nil, fmt.Errorf("failed to find private key in memory, %w", err)
-	}
+	return ecdsaPubKey, nil
+}
 
+// parsePrivateKey parses PEM private key
+func parsePrivateKey(privatekey []byte) (*ecdsa.PrivateKey, error) {
 	pkDecoded, err := PEMToPrivateKey(privatekey, nil)
 	if err != nil {
 		return nil, err
@@ -108,32 +231,234 @@ func NewVaultSigningIdentity(mspid, certname string, manager Manager) (*VaultSig
 		return nil, errors.New("failed to assert private key PEM-encoded bytes under interface{} to []byte")
 	}
 
-	identity := &VaultSigningIdentity{
-		VaultIdentity: &VaultIdentity{
-			MSPID:   mspid,
-			Manager: manager,
-			Key:     &CartridgeKey{PrivKey: pkECDSA, PubKey: ecdsaPubKey},
-			IDBytes: cert,
-		},
+	return pkECDSA, nil
+}
+
+// privateKeyName returns the name under which the private key of pub is cached
+func privateKeyName(pub *ecdsa.PublicKey) string {
+	return hexSKI(pub) + "_sk"
+}
+
+// hexSKI returns the hex encoded SKI of pub
+func hexSKI(pub *ecdsa.PublicKey) string {
+	return hex.EncodeToString((&CartridgeKey{PubKey: pub}).SKI())
+}
+
+// Watch reloads the identity every time its certificate changes in notifier.
+// A certificate issued for a new key takes effect once the new private key is in the cache as well.
+func (m *VaultSigningIdentity) Watch(notifier cryptocache.Notifier) (unsubscribe func()) {
+	return notifier.Subscribe(m.reload)
+}
+
+func (m *VaultSigningIdentity) reload(event cryptocache.Event) {
+	if event.Key == m.certName {
+		m.reloadCertificate(event.Value)
+		return
 	}
 
-	return identity, nil
+	m.mu.RLock()
+	pending := m.pending
+	m.mu.RUnlock()
+	if pending != nil && event.Key == privateKeyName(pending.Key.PubKey) {
+		m.completeReload(pending, event.Value)
+	}
 }
 
-// Sign the message
-func (m *VaultSigningIdentity) Sign(msg []byte) ([]byte, error) {
-	hash := sha256.Sum256(msg)
-	sig, err := m.Manager.Sign(hash[:], m.Key.PrivKey, m.Key.PubKey)
+func (m *VaultSigningIdentity) reloadCertificate(cert []byte) {
+	ecdsaPubKey, err := parseCertificate(cert)
 	if err != nil {
-		return nil, err
+		logrus.Errorf("failed to reload signing identity from %s: %s", m.certName, err)
+		return
 	}
 
-	sigLowS, err := utils.SignatureToLowS(m.Key.PubKey, sig)
+	current := m.identity()
+	if err = validateCertificate(context.Background(), current.Manager, current.MSPID, m.certName, cert); err != nil {
+		logrus.Errorf("failed to reload signing identity from %s: %s", m.certName, err)
+		return
+	}
+	identity := &VaultIdentity{
+		MSPID:    current.MSPID,
+		Manager:  current.Manager,
+		Key:      &CartridgeKey{PubKey: ecdsaPubKey},
+		IDBytes:  cert,
+		IDSource: current.IDSource,
+	}
+
+	if m.remote {
+		if err = checkRemoteKey(context.Background(), current, ecdsaPubKey); err != nil {
+			logrus.Errorf("failed to reload signing identity from %s: %s", m.certName, err)
+			return
+		}
+		identity.Key.Signer = current.Key.Signer
+		m.mu.Lock()
+		m.VaultIdentity = identity
+		m.pending = nil
+		m.mu.Unlock()
+		logrus.Infof("signing identity reloaded from %s", m.certName)
+		return
+	}
+
+	m.mu.Lock()
+	m.pending = identity
+	m.mu.Unlock()
+
+	// the cache lock is not held here, so a lazy cache may load the key
+	privatekey, err := current.Manager.Cache().GetCrypto(privateKeyName(ecdsaPubKey))
 	if err != nil {
-		return nil, err
+		logrus.Infof("private key for %s is not available yet, signing identity will be reloaded when it is", m.certName)
+		return
+	}
+	m.completeReload(identity, privatekey)
+}
+
+// remoteKeyChecker is implemented by managers signing with private keys held by a remote backend
+type remoteKeyChecker interface {
+	// checkRemoteKey checks that the backend holds the private key of ecdsaPublicKey
+	checkRemoteKey(ctx context.Context, ecdsaPublicKey *ecdsa.PublicKey) error
+}
+
+// checkRemoteKey checks that identity can sign for ecdsaPublicKey without a private key in memory,
+// either by its Signer or by the remote backend of its manager
+func checkRemoteKey(ctx context.Context, identity *VaultIdentity, ecdsaPublicKey *ecdsa.PublicKey) error {
+	if signer := identity.Key.Signer; signer != nil {
+		if !ecdsaPublicKey.Equal(signer.Public()) {
+			return errors.New("certificate does not match the signer key")
+		}
+		return nil
+	}
+	checker, ok := identity.Manager.(remoteKeyChecker)
+	if !ok {
+		return errors.New("manager cannot check that it holds the key of the certificate")
+	}
+	return checker.checkRemoteKey(ctx, ecdsaPublicKey)
+}
+
+// completeReload switches to pending identity using PEM private key privatekey
+func (m *VaultSigningIdentity) completeReload(pending *VaultIdentity, privatekey []byte) {
+	pkECDSA, err := parsePrivateKey(privatekey)
+	if err == nil {
+		err = validateKey(m.certName, pkECDSA, pending.Key.PubKey)
+	}
+	if err != nil {
+		logrus.Errorf("failed to reload signing identity from %s: %s", m.certName, err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pending != pending {
+		return
+	}
+	m.VaultIdentity = &VaultIdentity{
+		MSPID:    pending.MSPID,
+		Manager:  pending.Manager,
+		Key:      &CartridgeKey{PubKey: pending.Key.PubKey, Signer: pkECDSA},
+		IDBytes:  pending.IDBytes,
+		IDSource: pending.IDSource,
+	}
+	m.pending = nil
+	logrus.Infof("signing identity reloaded from %s", m.certName)
+}
+
+// identity returns the current identity
+func (m *VaultSigningIdentity) identity() *VaultIdentity {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.VaultIdentity
+}
+
+// wipe overwrites the in-memory private key of the identity with zeros and drops it,
+// the identity cannot sign with it afterwards
+func (m *VaultSigningIdentity) wipe() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.VaultIdentity == nil || m.Key == nil {
+		return
 	}
+	wipeSigner(m.Key.Signer)
+	identity := *m.VaultIdentity
+	identity.Key = &CartridgeKey{PubKey: m.Key.PubKey}
+	m.VaultIdentity = &identity
+	m.pending = nil
+}
+
+// SetIDSource makes the identity take its ID from the certificate with source, e.g. IDFromAttribute(AttrEnrollmentID).
+// It applies to reloaded certificates as well.
+func (m *VaultSigningIdentity) SetIDSource(source IDSource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	identity := *m.VaultIdentity
+	identity.IDSource = source
+	m.VaultIdentity = &identity
+	if m.pending != nil {
+		pending := *m.pending
+		pending.IDSource = source
+		m.pending = &pending
+	}
+}
 
-	return sigLowS, nil
+// String converts the current identity to string reprezentation
+func (m *VaultSigningIdentity) String() string {
+	return m.identity().String()
+}
+
+// Identifier returns the identifier of that identity
+func (m *VaultSigningIdentity) Identifier() *msp.IdentityIdentifier {
+	return m.identity().Identifier()
+}
+
+// Certificate returns the parsed enrollment certificate
+func (m *VaultSigningIdentity) Certificate() (*x509.Certificate, error) {
+	return m.identity().Certificate()
+}
+
+// Expiry returns the end of the validity period of the enrollment certificate
+func (m *VaultSigningIdentity) Expiry() (time.Time, error) {
+	return m.identity().Expiry()
+}
+
+// OUs returns organizational units of the enrollment certificate
+func (m *VaultSigningIdentity) OUs() ([]string, error) {
+	return m.identity().OUs()
+}
+
+// Attributes returns Fabric CA attributes of the enrollment certificate
+func (m *VaultSigningIdentity) Attributes() (map[string]string, error) {
+	return m.identity().Attributes()
+}
+
+// Attribute returns the Fabric CA attribute name of the enrollment certificate, ok is false if there is none
+func (m *VaultSigningIdentity) Attribute(name string) (value string, ok bool, err error) {
+	return m.identity().Attribute(name)
+}
+
+// Verify a signature over some message using this identity as reference
+func (m *VaultSigningIdentity) Verify(msg []byte, sig []byte) error {
+	return m.identity().Verify(msg, sig)
+}
+
+// Serialize converts an identity to bytes
+func (m *VaultSigningIdentity) Serialize() ([]byte, error) {
+	return m.identity().Serialize()
+}
+
+// EnrollmentCertificate Returns the underlying ECert representing this user’s identity.
+func (m *VaultSigningIdentity) EnrollmentCertificate() []byte {
+	return m.identity().EnrollmentCertificate()
+}
+
+// Sign the message
+func (m *VaultSigningIdentity) Sign(msg []byte) ([]byte, error) {
+	return m.SignContext(context.Background(), msg)
+}
+
+// SignContext signs the message with cancellation by ctx
+func (m *VaultSigningIdentity) SignContext(ctx context.Context, msg []byte) ([]byte, error) {
+	identity := m.identity()
+	hash := sha256.Sum256(msg)
+	return identity.Manager.SignContext(ctx, hash[:], identity.Key)
 }
 
 // PublicVersion returns the public parts of this identity
@@ -143,5 +468,5 @@ func (m *VaultSigningIdentity) PublicVersion() msp.Identity {
 
 // PrivateKey returns the crypto suite representation of the private key
 func (m *VaultSigningIdentity) PrivateKey() core.Key {
-	return m.Key
+	return m.identity().Key
 }
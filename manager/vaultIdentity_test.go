@@ -0,0 +1,129 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto" //nolint:staticcheck // fabric-protos-go v0.2.0 messages implement the v1 API only
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
+)
+
+func TestVaultIdentityReload(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// a certificate and its private key are swapped together
+	key := stub.putIdentity(t, "org1", testVaultCert, nil)
+	if err = m.refreshCrypto(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &key.PublicKey)
+
+	// a certificate waits for its private key
+	next, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.putIdentity(t, "org1", testVaultCert, next)
+	if err = m.refreshCrypto(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &key.PublicKey)
+
+	ski := hex.EncodeToString((&CartridgeKey{PubKey: &next.PublicKey}).SKI())
+	stub.put("org1/"+ski+"_sk", newTestKeyPEM(t, next))
+	if err = m.refreshCrypto(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &next.PublicKey)
+}
+
+func TestVaultIdentitySerialize(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	key := stub.putIdentity(t, "org1", testVaultCert, nil)
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := m.SigningIdentity()
+
+	serialized, err := identity.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid := &mspproto.SerializedIdentity{}
+	if err = proto.Unmarshal(serialized, sid); err != nil {
+		t.Fatal(err)
+	}
+	if sid.Mspid != "Org1MSP" || !bytes.Equal(sid.IdBytes, identity.EnrollmentCertificate()) {
+		t.Errorf("serialized as %s", sid)
+	}
+
+	// the identity is a message of the same wire format
+	vaultIdentity := m.SigningIdentity().(*VaultSigningIdentity).identity()
+	marshaled, err := proto.Marshal(vaultIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(marshaled, serialized) {
+		t.Error("identity marshaled in another format than msp.SerializedIdentity")
+	}
+	if s := vaultIdentity.String(); !strings.Contains(s, `mspid:"Org1MSP"`) {
+		t.Errorf("identity string %s", s)
+	}
+
+	// the deserialized identity has no manager and verifies signatures locally
+	deserialized, err := DeserializeIdentity(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deserialized.Manager != nil || deserialized.MSPID != "Org1MSP" || !bytes.Equal(deserialized.IDBytes, identity.EnrollmentCertificate()) {
+		t.Errorf("deserialized as %s", deserialized)
+	}
+	if !deserialized.Key.PubKey.Equal(&key.PublicKey) {
+		t.Error("deserialized identity holds another public key")
+	}
+	msg := []byte("message")
+	signature, err := identity.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = deserialized.Verify(msg, signature); err != nil {
+		t.Error(err)
+	}
+	if err = deserialized.Verify([]byte("other message"), signature); err == nil {
+		t.Error("signature of another message verified")
+	}
+	digest := sha256.Sum256(msg)
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("signature does not verify against the certificate key")
+	}
+
+	if _, err = DeserializeIdentity([]byte("not an identity")); err == nil {
+		t.Error("invalid serialized identity deserialized")
+	}
+	invalid, err := proto.Marshal(&mspproto.SerializedIdentity{Mspid: "Org1MSP", IdBytes: []byte("not a certificate")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DeserializeIdentity(invalid); err == nil {
+		t.Error("identity with an invalid certificate deserialized")
+	}
+
+	deserialized.Reset()
+	if deserialized.MSPID != "" || deserialized.IDBytes != nil || deserialized.Key != nil {
+		t.Error("identity is not reset")
+	}
+}
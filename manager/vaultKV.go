@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	kvVersion1 = 1
+	kvVersion2 = 2
+)
+
+// WithKVVersion sets the version of the KV secrets engine instead of detecting it
+func WithKVVersion(version int) Option {
+	return func(v *VaultManager) error {
+		if version != kvVersion1 && version != kvVersion2 {
+			return fmt.Errorf("unsupported KV version %d", version)
+		}
+		v.kvVersion = version
+		return nil
+	}
+}
+
+// WithSecretVersion pins the crypto item with the given name (e.g. "User1@org1.example.com-cert.pem"
+// or "User1@org1.example.com/tls/client.key") to a specific secret version. Requires KV version 2.
+func WithSecretVersion(name string, version int) Option {
+	return func(v *VaultManager) error {
+		if version <= 0 {
+			return fmt.Errorf("invalid version %d of secret %s", version, name)
+		}
+		if v.secretVersions == nil {
+			v.secretVersions = make(map[string]int)
+		}
+		v.secretVersions[name] = version
+		return nil
+	}
+}
+
+// detectKV resolves the mount and the KV version of vaultPath
+func (v *VaultManager) detectKV(ctx context.Context, vaultPath string) error {
+	secret, err := v.read(ctx, path.Join("sys/internal/ui/mounts", vaultPath))
+	if err != nil || secret == nil || secret.Data == nil {
+		if v.kvVersion == 0 {
+			logrus.Warnf("failed to detect KV version of %s, assuming version 1: %v", vaultPath, err)
+			v.kvVersion = kvVersion1
+		}
+	} else {
+		mount, _ := secret.Data["path"].(string)
+		v.kvMount = strings.Trim(mount, "/")
+		if v.kvVersion == 0 {
+			v.kvVersion = kvVersion1
+			options, _ := secret.Data["options"].(map[string]interface{})
+			if version, _ := options["version"].(string); version == strconv.Itoa(kvVersion2) {
+				v.kvVersion = kvVersion2
+			}
+		}
+	}
+
+	if v.kvMount == "" {
+		// assume the mount is the first segment of the path
+		v.kvMount = strings.SplitN(strings.Trim(vaultPath, "/"), "/", 2)[0] //nolint:gomnd
+	}
+	if len(v.secretVersions) != 0 && v.kvVersion != kvVersion2 {
+		return errors.New("secret versions are supported by KV version 2 only")
+	}
+
+	return nil
+}
+
+// kvPath converts a logical path like kv/org1/cert.pem to the KV v2 API path kv/<kind>/org1/cert.pem
+func (v *VaultManager) kvPath(kind, vaultPath string) string {
+	if v.kvVersion != kvVersion2 {
+		return vaultPath
+	}
+	vaultPath = strings.Trim(vaultPath, "/")
+	return path.Join(v.kvMount, kind, strings.TrimPrefix(strings.TrimPrefix(vaultPath, v.kvMount), "/"))
+}
+
+// listKV lists keys under vaultPath, returns nil if vaultPath is not a directory
+func (v *VaultManager) listKV(ctx context.Context, vaultPath string) (*vault.Secret, error) {
+	return v.list(ctx, v.kvPath("metadata", vaultPath))
+}
+
+// readKV reads value of the "data" field of the secret at vaultPath.
+// name is the crypto name used to look up the pinned version.
+func (v *VaultManager) readKV(ctx context.Context, vaultPath, name string) (interface{}, error) {
+	var params map[string][]string
+	if version, ok := v.secretVersions[name]; ok {
+		params = map[string][]string{"version": {strconv.Itoa(version)}}
+	}
+
+	secret, err := v.readWithData(ctx, v.kvPath("data", vaultPath), params)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	if v.kvVersion != kvVersion2 {
+		return secret.Data["data"], nil
+	}
+
+	// KV v2 returns nil data for deleted and destroyed versions
+	fields, _ := secret.Data["data"].(map[string]interface{})
+	return fields["data"], nil
+}
+
+// writeKV writes value base64 encoded to the "data" field of the secret at vaultPath
+func (v *VaultManager) writeKV(ctx context.Context, vaultPath string, value []byte) error {
+	data := map[string]interface{}{"data": base64.StdEncoding.EncodeToString(value)}
+	if v.kvVersion == kvVersion2 {
+		data = map[string]interface{}{"data": data}
+	}
+
+	_, err := v.write(ctx, v.kvPath("data", vaultPath), data)
+	return err
+}
+
+// deleteKV deletes the secret at vaultPath, with KV version 2 all its versions and metadata
+func (v *VaultManager) deleteKV(ctx context.Context, vaultPath string) error {
+	return v.delete(ctx, v.kvPath("metadata", vaultPath))
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVaultKVVersions(t *testing.T) {
+	const tlsKey = "User1@org1.example.com/tls/client.key"
+
+	for _, kvVersion := range []int{kvVersion1, kvVersion2} {
+		t.Run("v"+strconv.Itoa(kvVersion), func(t *testing.T) {
+			stub := newVaultStub(kvVersion)
+			key := stub.putIdentity(t, "org1/users", testVaultCert, nil)
+			stub.put("org1/users/"+tlsKey, []byte("tls key"))
+
+			m, err := newStubVaultManager(t, stub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.kvVersion != kvVersion || m.kvMount != "kv" {
+				t.Errorf("detected KV version %d mounted at %s", m.kvVersion, m.kvMount)
+			}
+			checkSigned(t, m, &key.PublicKey)
+			if value, err := m.Cache().GetCrypto(tlsKey); err != nil || string(value) != "tls key" {
+				t.Errorf("TLS crypto %q, %v", value, err)
+			}
+
+			// new crypto is written under the manager path, existing crypto at its path
+			ctx := context.Background()
+			if err = m.Store(ctx, "ca.org1.example.com-cert.pem", []byte("ca")); err != nil {
+				t.Fatal(err)
+			}
+			if err = m.Store(ctx, tlsKey, []byte("new tls key")); err != nil {
+				t.Fatal(err)
+			}
+			stub.mu.Lock()
+			ca, tls := stub.secrets["ca.org1.example.com-cert.pem"], stub.secrets["org1/users/"+tlsKey]
+			stub.mu.Unlock()
+			if len(ca) != 1 || string(ca[0]) != "ca" || string(tls[len(tls)-1]) != "new tls key" {
+				t.Errorf("stored %q and %q", ca, tls)
+			}
+
+			if err = m.Delete(ctx, tlsKey); err != nil {
+				t.Fatal(err)
+			}
+			stub.mu.Lock()
+			_, ok := stub.secrets["org1/users/"+tlsKey]
+			stub.mu.Unlock()
+			if ok {
+				t.Error("crypto is not deleted from Vault")
+			}
+			if _, err = m.Cache().GetCrypto(tlsKey); err == nil {
+				t.Error("crypto is not deleted from the cache")
+			}
+		})
+	}
+}
+
+func TestVaultKVDetectionFallback(t *testing.T) {
+	// the stub serves the KV v1 layout when the mounts endpoint fails
+	stub := newVaultStub(0)
+	key := stub.putIdentity(t, "org1", testVaultCert, nil)
+
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.kvVersion != kvVersion1 || m.kvMount != "kv" {
+		t.Errorf("assumed KV version %d mounted at %s", m.kvVersion, m.kvMount)
+	}
+	checkSigned(t, m, &key.PublicKey)
+
+	if _, err = newStubVaultManager(t, stub, WithSecretVersion(testVaultCert, 1)); err == nil ||
+		!strings.Contains(err.Error(), "KV version 2 only") {
+		t.Errorf("err = %v, want pinned versions rejected with KV version 1", err)
+	}
+	if _, err = newStubVaultManager(t, stub, WithKVVersion(3)); err == nil {
+		t.Error("KV version 3 accepted")
+	}
+}
+
+func TestVaultKVPinnedVersion(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	oldKey := stub.putIdentity(t, "org1", testVaultCert, nil)
+	newKey := stub.putIdentity(t, "org1", testVaultCert, nil)
+
+	latest, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, latest, &newKey.PublicKey)
+
+	pinned, err := newStubVaultManager(t, stub, WithSecretVersion(testVaultCert, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, pinned, &oldKey.PublicKey)
+
+	err = pinned.Store(context.Background(), testVaultCert, []byte("certificate"))
+	if err == nil || !strings.Contains(err.Error(), "pinned to version 1") {
+		t.Errorf("err = %v, want pinned crypto not overwritten", err)
+	}
+
+	// deleted versions are empty
+	stub.put("org1/"+testVaultCert, nil)
+	if _, err = newStubVaultManager(t, stub, WithSecretVersion(testVaultCert, 3)); err == nil {
+		t.Error("manager created with a deleted certificate version")
+	}
+}
@@ -1,142 +1,635 @@
 package manager
 
 import (
+	"context"
 	"crypto/ecdsa"
-	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"path/filepath"
+	"io"
+	"net"
+	"net/http"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/atomyze-foundation/cartridge/cryptocache"
 	vault "github.com/hashicorp/vault/api"
-	"github.com/hyperledger/fabric/bccsp/utils"
 )
 
 // Option is a function that configures a VaultManager
 type Option func(c *VaultManager) error
 
+// WithNamespace sets the Vault Enterprise namespace (X-Vault-Namespace header) used by all
+// requests of VaultManager: listing, reading, signing, auth and token renewal
+func WithNamespace(namespace string) Option {
+	return func(v *VaultManager) error {
+		v.namespace = namespace
+		return nil
+	}
+}
+
+// WithFetchConfig configures concurrency and retries of crypto download
+func WithFetchConfig(cfg FetchConfig) Option {
+	return func(v *VaultManager) error {
+		v.fetchConfig = cfg
+		return nil
+	}
+}
+
+// WithLazyLoading makes VaultManager only list secrets at construction and read them on first access.
+// Crypto with names matching one of prefetch patterns (path.Match syntax, e.g. "*-cert.pem") is read at construction.
+func WithLazyLoading(prefetch ...string) Option {
+	return func(v *VaultManager) error {
+		if err := validatePatterns(prefetch); err != nil {
+			return err
+		}
+		v.lazy = true
+		v.prefetch = prefetch
+		return nil
+	}
+}
+
 // VaultManager handles VaultManager operations
 type VaultManager struct {
+	validationConfig
+
 	client          *vault.Client
+	config          *vault.Config
+	namespace       string
+	auth            AuthMethod
+	authMu          sync.Mutex
 	memcache        cryptocache.CryptoCache
 	signingIdentity *VaultSigningIdentity
+	unwatch         func()
+	transitMount    string
+	transitKey      string
+	transitVersions map[string]int // SKIs of checked public keys to versions of the transit key holding them
+	transitMu       sync.RWMutex
+	fetchConfig     FetchConfig
+	lazy            bool
+	prefetch        []string
+	index           map[string]string
+	indexMu         sync.RWMutex
+	vaultPath       string
+	refreshInterval time.Duration
+	kvMount         string
+	kvVersion       int
+	secretVersions  map[string]int
+
+	tokenSecret  *vault.Secret
+	tokenChanged chan struct{}
+	onRenewError func(error)
+	closeCh      chan struct{}
+	closeOnce    sync.Once
+	wg           sync.WaitGroup
 }
 
 // NewVaultManager gets new instance of VaultManager
-func NewVaultManager(mspID, userCert, address, token, namespace string) (*VaultManager, error) {
-	config := &vault.Config{Address: address}
-	client, err := vault.NewClient(config)
+// vaultPath is the KV path crypto is pulled from (e.g. "kv" or "kv/org1"), the Vault Enterprise
+// namespace is set by WithNamespace. token is used unless an auth method is configured with WithAuth
+func NewVaultManager(mspID, userCert, address, token, vaultPath string, opts ...Option) (*VaultManager, error) {
+	return NewVaultManagerContext(context.Background(), mspID, userCert, address, token, vaultPath, opts...)
+}
+
+// NewVaultManagerContext is NewVaultManager with ctx bounding login and download of crypto.
+// Background token renewal and refresh do not depend on ctx.
+func NewVaultManagerContext(ctx context.Context, mspID, userCert, address, token, vaultPath string, opts ...Option) (*VaultManager, error) {
+	manager := &VaultManager{
+		config:       &vault.Config{Address: address},
+		vaultPath:    vaultPath,
+		memcache:     cryptocache.NewMemCache(),
+		tokenChanged: make(chan struct{}, 1),
+		closeCh:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(manager); err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := vault.NewClient(manager.config)
 	if err != nil {
 		return nil, err
 	}
-	client.SetToken(token)
+	manager.client = client
+	// failed requests are retried by fetcher, retries of the client would multiply them
+	client.SetMaxRetries(0)
+	if manager.namespace != "" {
+		client.SetNamespace(manager.namespace)
+	}
 
-	manager := &VaultManager{client: client, memcache: cryptocache.NewMemCache()}
-	if err = PullCrypto(manager, namespace, ""); err != nil {
+	if manager.auth == nil {
+		client.SetToken(token)
+		manager.tokenSecret = manager.lookupToken(ctx)
+	} else if err = manager.login(ctx); err != nil {
 		return nil, err
 	}
 
-	manager.signingIdentity, err = NewVaultSigningIdentity(mspID, userCert, manager)
+	if err = manager.detectKV(ctx, vaultPath); err != nil {
+		return nil, err
+	}
+
+	if manager.lazy {
+		err = manager.indexCrypto(ctx, vaultPath, "")
+	} else {
+		err = manager.pullCrypto(ctx, vaultPath, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if manager.transitKey != "" {
+		manager.signingIdentity, err = NewVaultSigningIdentityFromCertContext(ctx, mspID, userCert, manager)
+		if err == nil {
+			err = manager.checkTransitKey(ctx, manager.signingIdentity.Key.PubKey)
+		}
+	} else {
+		manager.signingIdentity, err = NewVaultSigningIdentityContext(ctx, mspID, userCert, manager)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if notifier, ok := manager.memcache.(cryptocache.Notifier); ok {
+		manager.unwatch = manager.signingIdentity.Watch(notifier)
+	}
+
+	manager.startTokenRenewal()
+	if manager.refreshInterval > 0 {
+		manager.wg.Add(1)
+		go func() {
+			defer manager.wg.Done()
+			runRefresh(manager.refreshInterval, manager.closeCh, manager.refreshCrypto)
+		}()
+	}
+
 	return manager, nil
 }
 
-// PullCrypto pulls crypto from Vault
+// PullCrypto pulls crypto from Vault, both KV version 1 and version 2 layouts are supported.
+// vaultPath may be a directory or a single secret, keyname is the name of the secret in the latter case.
 func PullCrypto(manager *VaultManager, vaultPath string, keyname string) error {
-	list, err := manager.client.Logical().List(vaultPath)
+	return PullCryptoContext(context.Background(), manager, vaultPath, keyname)
+}
+
+// PullCryptoContext is PullCrypto with cancellation by ctx
+func PullCryptoContext(ctx context.Context, manager *VaultManager, vaultPath string, keyname string) error {
+	return manager.pullCrypto(ctx, vaultPath, keyname)
+}
+
+func (v *VaultManager) pullCrypto(ctx context.Context, vaultPath string, keyname string) error {
+	return v.walkKV(ctx, vaultPath, keyname, func(ctx context.Context, keyPath string, keyname string) error {
+		if v.skipCrypto(cryptoName(keyPath, keyname)) {
+			return nil
+		}
+		return v.pullSecret(ctx, keyPath, keyname)
+	})
+}
+
+// skipCrypto reports whether crypto with name is not read from Vault,
+// private keys are not read with transit signing enabled
+func (v *VaultManager) skipCrypto(name string) bool {
+	return v.transitKey != "" && strings.HasSuffix(name, "_sk")
+}
+
+// indexCrypto lists secrets under vaultPath, reads only those matching prefetch patterns
+// and makes the cache load the rest on first access
+func (v *VaultManager) indexCrypto(ctx context.Context, vaultPath string, keyname string) error {
+	index, err := v.buildIndex(ctx, vaultPath, keyname)
 	if err != nil {
 		return err
 	}
-	//nolint:nestif
-	if list == nil {
-		data, err := manager.client.Logical().Read(vaultPath)
-		if err != nil {
-			return err
+	v.index = index
+
+	f := newFetcher(ctx, v.fetchConfig, isTransientVaultError)
+	for name, keyPath := range index {
+		if !matchAny(v.prefetch, name) {
+			continue
 		}
+		keyPath, keyname := keyPath, path.Base(keyPath)
+		f.Go(func(ctx context.Context) error {
+			return v.pullSecret(ctx, keyPath, keyname)
+		})
+	}
+	if err = f.Wait(); err != nil {
+		return err
+	}
+
+	v.memcache = cryptocache.NewLazyCache(v.memcache, v.loadCrypto, v.indexedNames)
+	return nil
+}
 
-		// if Vault empty, return error
-		if data == nil || data.Data["data"] == nil {
-			return fmt.Errorf("path %s is empty", vaultPath)
+// buildIndex maps names of crypto under vaultPath to their paths
+func (v *VaultManager) buildIndex(ctx context.Context, vaultPath string, keyname string) (map[string]string, error) {
+	index := make(map[string]string)
+	var indexMu sync.Mutex
+	err := v.walkKV(ctx, vaultPath, keyname, func(_ context.Context, keyPath string, keyname string) error {
+		name := cryptoName(keyPath, keyname)
+		if v.skipCrypto(name) {
+			return nil
+		}
+		indexMu.Lock()
+		if old, ok := index[name]; !ok || !keepPath(old, keyPath) {
+			index[name] = keyPath
 		}
+		indexMu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// refreshCrypto re-reads crypto from Vault, in lazy mode only crypto already in the cache is re-read
+func (v *VaultManager) refreshCrypto(ctx context.Context) error {
+	if !v.lazy {
+		return v.pullCrypto(ctx, v.vaultPath, "")
+	}
+
+	index, err := v.buildIndex(ctx, v.vaultPath, "")
+	if err != nil {
+		return err
+	}
+	v.indexMu.Lock()
+	v.index = index
+	v.indexMu.Unlock()
 
-		cryptoAsString, ok := data.Data["data"].(string)
+	lazy, ok := v.memcache.(*cryptocache.LazyCache)
+	if !ok {
+		return nil
+	}
+	f := newFetcher(ctx, v.fetchConfig, isTransientVaultError)
+	for _, name := range lazy.LoadedKeys() {
+		keyPath, ok := index[name]
 		if !ok {
-			return fmt.Errorf("failed to cast value of key %s to string", vaultPath)
+			continue
 		}
+		f.Go(func(ctx context.Context) error {
+			return v.pullSecret(ctx, keyPath, path.Base(keyPath))
+		})
+	}
+	return f.Wait()
+}
 
-		cryptoAsBytes, err := base64.StdEncoding.DecodeString(cryptoAsString)
-		if err != nil && strings.Contains(err.Error(), "illegal base64 data at input byte 0") {
-			cryptoAsBytes = []byte(cryptoAsString)
-		} else if err != nil {
+// backendPath returns the Vault path of crypto with name
+func (v *VaultManager) backendPath(name string) (string, bool) {
+	v.indexMu.RLock()
+	defer v.indexMu.RUnlock()
+	keyPath, ok := v.index[name]
+	return keyPath, ok
+}
+
+// indexedNames returns names of crypto in Vault, loaded or not
+func (v *VaultManager) indexedNames() []string {
+	v.indexMu.RLock()
+	defer v.indexMu.RUnlock()
+	names := make([]string, 0, len(v.index))
+	for name := range v.index {
+		names = append(names, name)
+	}
+	return names
+}
+
+// loadCrypto reads crypto with name from Vault on cache miss, transient failures are retried as by download
+func (v *VaultManager) loadCrypto(ctx context.Context, name string) ([]byte, error) {
+	keyPath, ok := v.backendPath(name)
+	if !ok {
+		return nil, fmt.Errorf("no crypto for key %s", name)
+	}
+
+	var value []byte
+	f := newFetcher(ctx, v.fetchConfig, isTransientVaultError)
+	f.Go(func(ctx context.Context) (err error) {
+		value, err = v.readSecret(ctx, keyPath, path.Base(keyPath))
+		return err
+	})
+	if err := f.Wait(); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// walkKV calls visit for every secret under vaultPath, vaultPath may be a directory or a single secret
+func (v *VaultManager) walkKV(ctx context.Context, vaultPath string, keyname string, visit func(ctx context.Context, keyPath string, keyname string) error) error {
+	f := newFetcher(ctx, v.fetchConfig, isTransientVaultError)
+	f.Go(func(ctx context.Context) error {
+		list, err := v.listKV(ctx, vaultPath)
+		if err != nil {
 			return err
 		}
+		if list == nil {
+			return visit(ctx, vaultPath, keyname)
+		}
+		v.walkKeys(f, vaultPath, list, visit)
+		return nil
+	})
+	return f.Wait()
+}
 
-		if strings.Contains(vaultPath, "/tls/") {
-			parts := strings.Split(vaultPath, "/")
-			if err := manager.memcache.SetCrypto(fmt.Sprintf("%s/%s/%s", parts[len(parts)-3], parts[len(parts)-2], keyname), cryptoAsBytes); err != nil { // username[@org]/tls/cryptoname
+// walkKeys schedules visiting of the listed keys of directory vaultPath
+func (v *VaultManager) walkKeys(f *fetcher, vaultPath string, list *vault.Secret, visit func(ctx context.Context, keyPath string, keyname string) error) {
+	keys, _ := list.Data["keys"].([]interface{})
+	for _, key := range keys {
+		keyname, _ := key.(string)
+		keyPath := path.Join(vaultPath, keyname)
+
+		// directories are listed with a trailing slash
+		if !strings.HasSuffix(keyname, "/") {
+			f.Go(func(ctx context.Context) error {
+				return visit(ctx, keyPath, keyname)
+			})
+			continue
+		}
+
+		f.Go(func(ctx context.Context) error {
+			list, err := v.listKV(ctx, keyPath)
+			if err != nil {
 				return err
 			}
-		} else {
-			if err := manager.memcache.SetCrypto(keyname, cryptoAsBytes); err != nil {
-				return err
+			if list != nil {
+				v.walkKeys(f, keyPath, list, visit)
 			}
-		}
+			return nil
+		})
+	}
+}
 
-		return nil
+// pullSecret reads the secret at vaultPath and puts it to the cache
+func (v *VaultManager) pullSecret(ctx context.Context, vaultPath string, keyname string) error {
+	cryptoAsBytes, err := v.readSecret(ctx, vaultPath, keyname)
+	if err != nil {
+		return err
 	}
 
-	keys, _ := list.Data["keys"].([]interface{})
-	for _, key := range keys {
-		keyname = key.(string) //nolint:forcetypeassert
-		if err = PullCrypto(manager, filepath.Join(vaultPath, keyname), keyname); err != nil {
-			return err
-		}
+	name := cryptoName(vaultPath, keyname)
+	v.indexMu.Lock()
+	if v.index == nil {
+		v.index = make(map[string]string)
+	}
+	if old, ok := v.index[name]; !ok || !keepPath(old, vaultPath) {
+		v.index[name] = vaultPath
+	}
+	v.indexMu.Unlock()
+
+	return v.memcache.SetCrypto(name, cryptoAsBytes)
+}
+
+// Store writes crypto value with name to Vault and puts it to the cache.
+// Crypto read from Vault before is overwritten at its path, new crypto is written under vaultPath,
+// TLS crypto named like user/tls/name under vaultPath/user/tls/name.
+func (v *VaultManager) Store(ctx context.Context, name string, value []byte) error {
+	if version, ok := v.secretVersions[name]; ok {
+		return fmt.Errorf("crypto %s is pinned to version %d", name, version)
+	}
+
+	keyPath := v.storePath(name)
+	if err := checkStoredName(name, keyPath, func(keyPath string) string {
+		return cryptoName(keyPath, path.Base(keyPath))
+	}); err != nil {
+		return err
+	}
+
+	if err := v.writeKV(ctx, keyPath, value); err != nil {
+		return fmt.Errorf("failed to store %s to %s: %w", name, keyPath, err)
+	}
+
+	v.indexMu.Lock()
+	if v.index == nil {
+		v.index = make(map[string]string)
 	}
+	v.index[name] = keyPath
+	v.indexMu.Unlock()
 
+	return v.memcache.SetCrypto(name, value)
+}
+
+// Delete deletes crypto with name from Vault, all versions with KV version 2, and from the cache
+func (v *VaultManager) Delete(ctx context.Context, name string) error {
+	keyPath := v.storePath(name)
+	if err := v.deleteKV(ctx, keyPath); err != nil {
+		return fmt.Errorf("failed to delete %s at %s: %w", name, keyPath, err)
+	}
+
+	v.indexMu.Lock()
+	delete(v.index, name)
+	v.indexMu.Unlock()
+
+	deleteCached(v.memcache, name)
 	return nil
 }
 
-// Sign signs the digest
-func (v *VaultManager) Sign(digest []byte, ecdsaPrivateKey *ecdsa.PrivateKey, ecdsaPublicKey *ecdsa.PublicKey) ([]byte, error) {
-	r, s, err := ecdsa.Sign(rand.Reader, ecdsaPrivateKey, digest)
+// storePath returns the path crypto with name was read from, the path under vaultPath for new crypto
+func (v *VaultManager) storePath(name string) string {
+	v.indexMu.RLock()
+	keyPath, ok := v.index[name]
+	v.indexMu.RUnlock()
+	if ok {
+		return keyPath
+	}
+	return path.Join(v.vaultPath, name)
+}
+
+// readSecret reads the secret at vaultPath
+func (v *VaultManager) readSecret(ctx context.Context, vaultPath string, keyname string) ([]byte, error) {
+	data, err := v.readKV(ctx, vaultPath, cryptoName(vaultPath, keyname))
 	if err != nil {
 		return nil, err
 	}
 
-	signature, err := utils.ToLowS(ecdsaPublicKey, s)
-	if err != nil {
+	// if Vault empty, return error
+	if data == nil {
+		return nil, fmt.Errorf("path %s is empty", vaultPath)
+	}
+
+	cryptoAsString, ok := data.(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast value of key %s to string", vaultPath)
+	}
+
+	cryptoAsBytes, err := base64.StdEncoding.DecodeString(cryptoAsString)
+	if err != nil && strings.Contains(err.Error(), "illegal base64 data at input byte 0") {
+		cryptoAsBytes = []byte(cryptoAsString)
+	} else if err != nil {
 		return nil, err
 	}
 
-	return utils.MarshalECDSASignature(r, signature)
+	return cryptoAsBytes, nil
 }
 
-// Verify verifies the signature
-func (v *VaultManager) Verify(digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
-	r, s, err := utils.UnmarshalECDSASignature(signature)
-	if err != nil {
-		return fmt.Errorf("failed unmashalling signature [%w]", err)
+// isTransientVaultError reports whether a failed Vault request may succeed on retry
+func isTransientVaultError(err error) bool {
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= http.StatusInternalServerError || respErr.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// cryptoName returns the name under which crypto stored at vaultPath is cached
+func cryptoName(vaultPath, keyname string) string {
+	if strings.Contains(vaultPath, "/tls/") {
+		parts := strings.Split(vaultPath, "/")
+		return fmt.Sprintf("%s/%s/%s", parts[len(parts)-3], parts[len(parts)-2], keyname) // username[@org]/tls/cryptoname
 	}
+	return keyname
+}
 
-	lowS, err := utils.IsLowS(ecdsaPublicKey, s)
+// login logs in with the configured auth method and sets the client token
+func (v *VaultManager) login(ctx context.Context) error {
+	// a stale token must not be sent to the login endpoint
+	v.client.ClearToken()
+	secret, err := v.auth.Login(ctx, v.client)
 	if err != nil {
+		return fmt.Errorf("vault login failed: %w", err)
+	}
+	v.client.SetToken(secret.Auth.ClientToken)
+	v.tokenSecret = secret
+
+	select {
+	case v.tokenChanged <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// withReauth calls fn and, if Vault rejected the token, logs in again and retries fn once
+func (v *VaultManager) withReauth(ctx context.Context, fn func() error) error {
+	token := v.client.Token()
+	err := fn()
+	var respErr *vault.ResponseError
+	if err == nil || v.auth == nil || !errors.As(err, &respErr) || respErr.StatusCode != http.StatusForbidden {
 		return err
 	}
 
-	if !lowS {
-		return fmt.Errorf("invalid S. Must be smaller than half the order [%s][%s]", s, utils.GetCurveHalfOrdersAt(ecdsaPublicKey.Curve))
+	if err = v.relogin(ctx, token); err != nil {
+		return err
 	}
 
-	if ok := ecdsa.Verify(ecdsaPublicKey, digest, r, s); ok {
+	return fn()
+}
+
+// relogin logs in again unless another call has already replaced the rejected token
+func (v *VaultManager) relogin(ctx context.Context, rejectedToken string) error {
+	v.authMu.Lock()
+	defer v.authMu.Unlock()
+
+	if v.client.Token() != rejectedToken {
 		return nil
 	}
+	return v.login(ctx)
+}
+
+func (v *VaultManager) read(ctx context.Context, path string) (*vault.Secret, error) {
+	return v.readWithData(ctx, path, nil)
+}
+
+func (v *VaultManager) readWithData(ctx context.Context, path string, data map[string][]string) (secret *vault.Secret, err error) {
+	err = v.withReauth(ctx, func() error {
+		secret, err = v.request(ctx, http.MethodGet, path, data, nil)
+		return err
+	})
+	return
+}
+
+func (v *VaultManager) list(ctx context.Context, path string) (secret *vault.Secret, err error) {
+	err = v.withReauth(ctx, func() error {
+		secret, err = v.request(ctx, "LIST", path, nil, nil)
+		return err
+	})
+	return
+}
+
+func (v *VaultManager) write(ctx context.Context, path string, data map[string]interface{}) (secret *vault.Secret, err error) {
+	err = v.withReauth(ctx, func() error {
+		secret, err = v.request(ctx, http.MethodPut, path, nil, data)
+		return err
+	})
+	return
+}
+
+func (v *VaultManager) delete(ctx context.Context, path string) (err error) {
+	err = v.withReauth(ctx, func() error {
+		_, err = v.request(ctx, http.MethodDelete, path, nil, nil)
+		return err
+	})
+	return
+}
+
+func (v *VaultManager) request(ctx context.Context, method, path string, params map[string][]string, body interface{}) (*vault.Secret, error) {
+	return logicalRequest(ctx, v.client, method, path, params, body)
+}
+
+// logicalRequest sends request to the logical backend the same way as vault.Logical does
+// but with cancellation by ctx. Not found paths result in nil secret.
+func logicalRequest(ctx context.Context, client *vault.Client, method, path string, params map[string][]string, body interface{}) (*vault.Secret, error) {
+	r := client.NewRequest(method, "/v1/"+path)
+	if method == "LIST" {
+		r.Method = http.MethodGet
+		r.Params.Set("list", "true")
+	}
+	for k, values := range params {
+		for _, value := range values {
+			r.Params.Add(k, value)
+		}
+	}
+	if body != nil {
+		if err := r.SetJSONBody(body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		secret, parseErr := vault.ParseSecret(resp.Body)
+		switch {
+		case errors.Is(parseErr, io.EOF):
+			return nil, nil
+		case parseErr != nil:
+			return nil, err
+		case secret != nil && (len(secret.Warnings) > 0 || len(secret.Data) > 0):
+			return secret, nil
+		default:
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return vault.ParseSecret(resp.Body)
+}
+
+// Sign signs the digest with the Signer of key. With transit signing enabled keys without a Signer,
+// such as the key of the signing identity, are signed by Vault with the version of the transit key
+// checked to hold them, other keys without a Signer are rejected.
+func (v *VaultManager) Sign(digest []byte, key *CartridgeKey) ([]byte, error) {
+	return v.SignContext(context.Background(), digest, key)
+}
+
+// SignContext is Sign with cancellation by ctx
+func (v *VaultManager) SignContext(ctx context.Context, digest []byte, key *CartridgeKey) ([]byte, error) {
+	if v.transitKey != "" && key != nil && key.Signer == nil {
+		return v.transitSign(ctx, digest, key.PubKey)
+	}
+	return signWithKey(ctx, digest, key)
+}
+
+// Verify verifies the signature
+func (v *VaultManager) Verify(digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return v.VerifyContext(context.Background(), digest, signature, ecdsaPublicKey)
+}
 
-	return errors.New("invalid signature")
+// VerifyContext is Verify with cancellation by ctx
+func (v *VaultManager) VerifyContext(ctx context.Context, digest, signature []byte, ecdsaPublicKey *ecdsa.PublicKey) error {
+	return verifyECDSA(ctx, digest, signature, ecdsaPublicKey)
 }
 
 // SigningIdentity returns the signing identity
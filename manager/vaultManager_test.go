@@ -0,0 +1,419 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testVaultCert  = "User1@org1.example.com-cert.pem"
+	testVaultToken = "root-token"
+)
+
+// vaultStub serves the parts of the Vault HTTP API used by VaultManager: the KV engine mounted at kv,
+// token lookup and renewal, logins to auth methods and the transit engine mounted at transit
+type vaultStub struct {
+	// kvVersion is the version of the KV engine reported by the mounts endpoint, 0 makes it fail
+	kvVersion int
+
+	mu sync.Mutex
+	// secrets maps paths under kv to versions of their values, deleted versions are nil
+	secrets map[string][][]byte
+	// token is the only token accepted, requests with other tokens are rejected with 403
+	token     string
+	tokenTTL  int
+	renewable bool
+	issued    int
+	renewals  int
+	logins    []vaultStubLogin
+	// transitKeys are the versions of the transit key named key, version 1 first
+	transitKeys  []*ecdsa.PrivateKey
+	signVersions []int
+	// failures holds the number of 503 responses left for a path
+	failures map[string]int
+	calls    map[string]int
+}
+
+// vaultStubLogin is a login request received by vaultStub
+type vaultStubLogin struct {
+	path       string
+	data       map[string]interface{}
+	clientCert bool
+}
+
+func newVaultStub(kvVersion int) *vaultStub {
+	return &vaultStub{
+		kvVersion: kvVersion,
+		secrets:   make(map[string][][]byte),
+		token:     testVaultToken,
+		failures:  make(map[string]int),
+		calls:     make(map[string]int),
+	}
+}
+
+// start serves stub over HTTP until the test ends and returns its address
+func (s *vaultStub) start(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// put adds a version of the secret at path under kv
+func (s *vaultStub) put(secretPath string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[secretPath] = append(s.secrets[secretPath], value)
+}
+
+// putIdentity puts a certificate named certName issued for key to dir under kv. If key is nil,
+// the certificate is issued for a new key put under <ski>_sk as well.
+func (s *vaultStub) putIdentity(t *testing.T, dir, certName string, key *ecdsa.PrivateKey) *ecdsa.PrivateKey {
+	t.Helper()
+
+	cert, issued := newTestUserCert(t, certName, key)
+	s.put(joinStubPath(dir, certName), cert)
+	if key == nil {
+		s.put(joinStubPath(dir, privateKeyName(&issued.PublicKey)), newTestKeyPEM(t, issued))
+	}
+	return issued
+}
+
+// rotateTransit adds a new version of the transit key and returns its private key
+func (s *vaultStub) rotateTransit(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transitKeys = append(s.transitKeys, key)
+	return key
+}
+
+// fail makes the next n requests to path fail with 503
+func (s *vaultStub) fail(requestPath string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[requestPath] = n
+}
+
+// revokeToken makes the stub reject the current token
+func (s *vaultStub) revokeToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = "revoked"
+}
+
+func (s *vaultStub) loginRequests() []vaultStubLogin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]vaultStubLogin(nil), s.logins...)
+}
+
+func (s *vaultStub) callCount(requestPath string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[requestPath]
+}
+
+func joinStubPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// trimStubPath removes the first segment from requestPath
+func trimStubPath(requestPath, segment string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(requestPath, segment), "/")
+}
+
+func (s *vaultStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requestPath := strings.TrimPrefix(r.URL.Path, "/v1/")
+	s.calls[requestPath]++
+	if s.failures[requestPath] > 0 {
+		s.failures[requestPath]--
+		vaultStubError(w, http.StatusServiceUnavailable, "unavailable")
+		return
+	}
+
+	var body map[string]interface{}
+	if r.Body != nil && r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil {
+			vaultStubError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if strings.HasPrefix(requestPath, "auth/") && strings.Contains(requestPath, "/login") {
+		s.login(w, r, requestPath, body)
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != s.token {
+		vaultStubError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(requestPath, "sys/internal/ui/mounts/"):
+		if s.kvVersion == 0 {
+			vaultStubError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		vaultStubData(w, map[string]interface{}{
+			"path":    "kv/",
+			"type":    "kv",
+			"options": map[string]interface{}{"version": strconv.Itoa(s.kvVersion)},
+		})
+	case requestPath == "auth/token/lookup-self":
+		vaultStubData(w, map[string]interface{}{"ttl": s.tokenTTL, "renewable": s.renewable})
+	case requestPath == "auth/token/renew-self":
+		s.renewals++
+		vaultStubAuth(w, s.token, s.tokenTTL, s.renewable)
+	case strings.HasPrefix(requestPath, "transit/"):
+		s.transit(w, r, requestPath, body)
+	case requestPath == "kv" || strings.HasPrefix(requestPath, "kv/"):
+		s.kv(w, r, trimStubPath(requestPath, "kv"), body)
+	default:
+		vaultStubError(w, http.StatusNotFound, "no handler for "+requestPath)
+	}
+}
+
+// login issues a new token, it is the only token accepted afterwards
+func (s *vaultStub) login(w http.ResponseWriter, r *http.Request, requestPath string, body map[string]interface{}) {
+	s.logins = append(s.logins, vaultStubLogin{
+		path:       requestPath,
+		data:       body,
+		clientCert: r.TLS != nil && len(r.TLS.PeerCertificates) != 0,
+	})
+	s.issued++
+	s.token = "token-" + strconv.Itoa(s.issued)
+	vaultStubAuth(w, s.token, s.tokenTTL, s.renewable)
+}
+
+func (s *vaultStub) kv(w http.ResponseWriter, r *http.Request, secretPath string, body map[string]interface{}) {
+	if s.kvVersion == kvVersion2 {
+		switch {
+		case strings.HasPrefix(secretPath+"/", "metadata/"):
+			secretPath = trimStubPath(secretPath, "metadata")
+			if r.Method == http.MethodDelete {
+				delete(s.secrets, secretPath)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		case strings.HasPrefix(secretPath+"/", "data/"):
+			secretPath = trimStubPath(secretPath, "data")
+		default:
+			vaultStubError(w, http.StatusNotFound, "no handler for kv/"+secretPath)
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list") == "true":
+		keys := s.list(secretPath)
+		if len(keys) == 0 {
+			vaultStubError(w, http.StatusNotFound, "")
+			return
+		}
+		vaultStubData(w, map[string]interface{}{"keys": keys})
+
+	case r.Method == http.MethodGet:
+		versions := s.secrets[secretPath]
+		version := len(versions)
+		if requested := r.URL.Query().Get("version"); requested != "" {
+			version, _ = strconv.Atoi(requested)
+		}
+		if version == 0 || version > len(versions) {
+			vaultStubError(w, http.StatusNotFound, "")
+			return
+		}
+		value := versions[version-1]
+		if s.kvVersion != kvVersion2 {
+			vaultStubData(w, map[string]interface{}{"data": base64.StdEncoding.EncodeToString(value)})
+			return
+		}
+		var data interface{}
+		if value != nil {
+			data = map[string]interface{}{"data": base64.StdEncoding.EncodeToString(value)}
+		}
+		vaultStubData(w, map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": version}})
+
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		fields := body
+		if s.kvVersion == kvVersion2 {
+			fields, _ = body["data"].(map[string]interface{})
+		}
+		encoded, _ := fields["data"].(string)
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			vaultStubError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if s.kvVersion == kvVersion2 {
+			s.secrets[secretPath] = append(s.secrets[secretPath], value)
+		} else {
+			s.secrets[secretPath] = [][]byte{value}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodDelete:
+		delete(s.secrets, secretPath)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		vaultStubError(w, http.StatusMethodNotAllowed, r.Method)
+	}
+}
+
+// list returns names of secrets and directories, with a trailing slash, directly under dir
+func (s *vaultStub) list(dir string) []interface{} {
+	prefix := strings.Trim(dir, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	seen := make(map[string]bool)
+	for secretPath := range s.secrets {
+		if !strings.HasPrefix(secretPath, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(secretPath, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i+1]
+		}
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	keys := make([]interface{}, len(names))
+	for i, name := range names {
+		keys[i] = name
+	}
+	return keys
+}
+
+func (s *vaultStub) transit(w http.ResponseWriter, r *http.Request, requestPath string, body map[string]interface{}) {
+	switch {
+	case requestPath == "transit/keys/key" && r.Method == http.MethodGet:
+		if len(s.transitKeys) == 0 {
+			vaultStubError(w, http.StatusNotFound, "")
+			return
+		}
+		keys := make(map[string]interface{})
+		for i, key := range s.transitKeys {
+			der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+			if err != nil {
+				vaultStubError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			keys[strconv.Itoa(i+1)] = map[string]interface{}{
+				"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			}
+		}
+		vaultStubData(w, map[string]interface{}{"type": transitKeyType, "latest_version": len(s.transitKeys), "keys": keys})
+
+	case requestPath == "transit/sign/key":
+		version := len(s.transitKeys)
+		if requested, ok := body["key_version"].(json.Number); ok {
+			n, _ := requested.Int64()
+			version = int(n)
+		}
+		if version == 0 || version > len(s.transitKeys) {
+			vaultStubError(w, http.StatusBadRequest, "invalid key version")
+			return
+		}
+		input, _ := body["input"].(string)
+		digest, err := base64.StdEncoding.DecodeString(input)
+		if err != nil || len(digest) != sha256.Size {
+			vaultStubError(w, http.StatusBadRequest, "invalid input")
+			return
+		}
+		signature, err := ecdsa.SignASN1(rand.Reader, s.transitKeys[version-1], digest)
+		if err != nil {
+			vaultStubError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.signVersions = append(s.signVersions, version)
+		vaultStubData(w, map[string]interface{}{
+			"signature": "vault:v" + strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(signature),
+		})
+
+	default:
+		vaultStubError(w, http.StatusNotFound, "no handler for "+requestPath)
+	}
+}
+
+func vaultStubData(w http.ResponseWriter, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func vaultStubAuth(w http.ResponseWriter, token string, ttl int, renewable bool) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{
+		"client_token":   token,
+		"lease_duration": ttl,
+		"renewable":      renewable,
+	}})
+}
+
+func vaultStubError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	var errs []string
+	if message != "" {
+		errs = append(errs, message)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+}
+
+// newStubVaultManager returns a manager of User1 of Org1MSP reading kv from stub with the static test token
+func newStubVaultManager(t *testing.T, stub *vaultStub, opts ...Option) (*VaultManager, error) {
+	t.Helper()
+
+	m, err := NewVaultManager("Org1MSP", testVaultCert, stub.start(t), testVaultToken, "kv", opts...)
+	if err == nil {
+		t.Cleanup(func() { _ = m.Close() })
+	}
+	return m, err
+}
+
+// checkSigned verifies that a signature made by the signing identity of m verifies against pub
+func checkSigned(t *testing.T, m Manager, pub *ecdsa.PublicKey) {
+	t.Helper()
+
+	msg := []byte("message")
+	signature, err := m.SigningIdentity().Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(msg)
+	if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+		t.Error("signature does not verify against the certificate key")
+	}
+}
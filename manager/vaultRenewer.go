@@ -0,0 +1,192 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/atomyze-foundation/cartridge/cryptocache"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+const reloginRetryInterval = 10 * time.Second
+
+// WithRenewalErrorHandler sets handler called every time the token renewal or re-authentication fails.
+// Failures are logged in any case.
+func WithRenewalErrorHandler(handler func(error)) Option {
+	return func(v *VaultManager) error {
+		v.onRenewError = handler
+		return nil
+	}
+}
+
+// Close stops the token renewal and the crypto refresh, wipes cached crypto and the private key
+// of the signing identity and forgets the token. The manager must not be used after Close.
+func (v *VaultManager) Close() error {
+	v.closeOnce.Do(func() {
+		close(v.closeCh)
+		v.wg.Wait()
+
+		if v.unwatch != nil {
+			v.unwatch()
+		}
+		if clearer, ok := v.memcache.(cryptocache.Clearer); ok {
+			clearer.Clear()
+		}
+		if v.signingIdentity != nil {
+			v.signingIdentity.wipe()
+		}
+		v.client.ClearToken()
+	})
+	return nil
+}
+
+// lookupToken returns the auth secret of the static token or nil if it cannot be looked up
+func (v *VaultManager) lookupToken(ctx context.Context) *vault.Secret {
+	secret, err := v.request(ctx, http.MethodGet, "auth/token/lookup-self", nil, nil)
+	if err == nil && secret == nil {
+		err = errors.New("empty response from token lookup")
+	}
+	if err != nil {
+		logrus.Warnf("failed to look up vault token, it will not be renewed: %s", err)
+		return nil
+	}
+
+	renewable, err := secret.TokenIsRenewable()
+	if err != nil {
+		logrus.Warnf("failed to read renewable flag of vault token, it will not be renewed: %s", err)
+		return nil
+	}
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		logrus.Warnf("failed to read TTL of vault token, it will not be renewed: %s", err)
+		return nil
+	}
+
+	return &vault.Secret{Auth: &vault.SecretAuth{
+		ClientToken:   v.client.Token(),
+		Renewable:     renewable,
+		LeaseDuration: int(ttl.Seconds()),
+	}}
+}
+
+// startTokenRenewal keeps the token alive in the background until Close is called.
+// Tokens without TTL are never renewed.
+func (v *VaultManager) startTokenRenewal() {
+	if v.tokenSecret == nil || v.tokenSecret.Auth.LeaseDuration == 0 {
+		return
+	}
+
+	v.drainTokenChanged()
+	v.wg.Add(1)
+	go v.renewToken()
+}
+
+func (v *VaultManager) renewToken() {
+	defer v.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-v.closeCh
+		cancel()
+	}()
+
+	for {
+		v.authMu.Lock()
+		secret := v.tokenSecret
+		v.authMu.Unlock()
+
+		err := v.watchToken(secret)
+		if v.closed() {
+			return
+		}
+		if errors.Is(err, errTokenChanged) {
+			continue
+		}
+		if err != nil {
+			v.renewalFailed(err)
+		}
+
+		if v.auth == nil {
+			v.renewalFailed(errors.New("vault token can no longer be renewed and no auth method is configured"))
+			return
+		}
+
+		for {
+			if err = v.relogin(ctx, secret.Auth.ClientToken); err == nil {
+				v.drainTokenChanged()
+				break
+			}
+			v.renewalFailed(err)
+			select {
+			case <-v.closeCh:
+				return
+			case <-time.After(reloginRetryInterval):
+			}
+		}
+	}
+}
+
+var errTokenChanged = errors.New("vault token changed")
+
+// watchToken renews the token until it can no longer be renewed, the token is replaced
+// by a new login or the manager is closed
+func (v *VaultManager) watchToken(secret *vault.Secret) error {
+	if !secret.Auth.Renewable {
+		// log in again when two thirds of the token TTL are over
+		ttl := time.Duration(secret.Auth.LeaseDuration) * time.Second
+		select {
+		case <-v.closeCh:
+		case <-v.tokenChanged:
+			return errTokenChanged
+		case <-time.After(ttl * 2 / 3): //nolint:gomnd
+		}
+		return nil
+	}
+
+	renewer, err := v.client.NewRenewer(&vault.RenewerInput{Secret: secret})
+	if err != nil {
+		return err
+	}
+	go renewer.Renew()
+	defer renewer.Stop()
+
+	for {
+		select {
+		case <-v.closeCh:
+			return nil
+		case <-v.tokenChanged:
+			return errTokenChanged
+		case err = <-renewer.DoneCh():
+			return err
+		case renewal := <-renewer.RenewCh():
+			logrus.Debugf("vault token renewed at %s", renewal.RenewedAt)
+		}
+	}
+}
+
+func (v *VaultManager) renewalFailed(err error) {
+	logrus.Errorf("vault token renewal failed: %s", err)
+	if v.onRenewError != nil {
+		v.onRenewError(err)
+	}
+}
+
+func (v *VaultManager) drainTokenChanged() {
+	select {
+	case <-v.tokenChanged:
+	default:
+	}
+}
+
+func (v *VaultManager) closed() bool {
+	select {
+	case <-v.closeCh:
+		return true
+	default:
+		return false
+	}
+}
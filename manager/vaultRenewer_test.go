@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestVaultTokenRenewal(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.tokenTTL, stub.renewable = 3600, true
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "token renewal", func() bool { return stub.callCount("auth/token/renew-self") != 0 })
+
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if token := m.client.Token(); token != "" {
+		t.Errorf("token %q is kept after Close", token)
+	}
+}
+
+func TestVaultTokenWithoutTTL(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.renewable = true
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+
+	m, err := newStubVaultManager(t, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := stub.callCount("auth/token/renew-self"); n != 0 {
+		t.Errorf("token without TTL renewed %d times", n)
+	}
+}
+
+func TestVaultTokenRelogin(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	// a non-renewable token is replaced by a new login after two thirds of its TTL
+	stub.tokenTTL = 1
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+
+	m, err := newStubVaultManager(t, stub, WithAuth(&AppRoleAuth{RoleID: "role", SecretID: "secret"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "login with a new token", func() bool { return len(stub.loginRequests()) >= 2 })
+
+	// requests are sent with the new token
+	if err = m.Store(context.Background(), "ca.org1.example.com-cert.pem", []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	if n := stub.callCount("auth/token/renew-self"); n != 0 {
+		t.Errorf("non-renewable token renewed %d times", n)
+	}
+}
+
+func TestVaultTokenRenewalFailure(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.tokenTTL, stub.renewable = 3600, true
+	stub.fail("auth/token/renew-self", 1)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+
+	errs := make(chan error, 2)
+	_, err := newStubVaultManager(t, stub, WithRenewalErrorHandler(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"unavailable", "no auth method"} {
+		select {
+		case err = <-errs:
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("renewal error %q, want %q", err, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("renewal error %q not reported", want)
+		}
+	}
+}
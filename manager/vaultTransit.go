@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/bccsp/utils"
+)
+
+const transitKeyType = "ecdsa-p256"
+
+// WithTransitSigning makes VaultManager sign digests through the Vault Transit engine
+// mounted at mount using the key keyName, so the private key never leaves Vault.
+// The transit key must be of type ecdsa-p256 and match the public key of the user certificate.
+// Private keys stored in KV under <ski>_sk are not read into the cache in this mode.
+func WithTransitSigning(mount, keyName string) Option {
+	return func(v *VaultManager) error {
+		if mount == "" || keyName == "" {
+			return errors.New("transit mount and key name must not be empty")
+		}
+		v.transitMount = mount
+		v.transitKey = keyName
+		return nil
+	}
+}
+
+// checkTransitKey verifies that a version of the transit key matches ecdsaPublicKey
+// and pins signing with ecdsaPublicKey to the latest such version
+func (v *VaultManager) checkTransitKey(ctx context.Context, ecdsaPublicKey *ecdsa.PublicKey) error {
+	keyPath := path.Join(v.transitMount, "keys", v.transitKey)
+	secret, err := v.read(ctx, keyPath)
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Data == nil {
+		return fmt.Errorf("transit key %s not found", keyPath)
+	}
+
+	if keyType, _ := secret.Data["type"].(string); keyType != transitKeyType {
+		return fmt.Errorf("transit key %s has type %s, expecting %s", keyPath, keyType, transitKeyType)
+	}
+
+	latest, ok := secret.Data["latest_version"].(json.Number)
+	if !ok {
+		return fmt.Errorf("failed to read latest version of transit key %s", keyPath)
+	}
+	latestVersion, err := latest.Int64()
+	if err != nil {
+		return fmt.Errorf("failed to read latest version of transit key %s: %w", keyPath, err)
+	}
+
+	// the certificate may still hold the key of a version older than latest after rotation of the transit key
+	versions, _ := secret.Data["keys"].(map[string]interface{})
+	for version := int(latestVersion); version > 0; version-- {
+		versionData, _ := versions[strconv.Itoa(version)].(map[string]interface{})
+		publicKeyPEM, _ := versionData["public_key"].(string)
+		transitPublicKey, err := parseTransitPublicKey(publicKeyPEM)
+		if err != nil {
+			return fmt.Errorf("failed to read version %d of transit key %s: %w", version, keyPath, err)
+		}
+		if transitPublicKey == nil || !transitPublicKey.Equal(ecdsaPublicKey) {
+			continue
+		}
+
+		v.transitMu.Lock()
+		if v.transitVersions == nil {
+			v.transitVersions = make(map[string]int)
+		}
+		v.transitVersions[hexSKI(ecdsaPublicKey)] = version
+		v.transitMu.Unlock()
+		return nil
+	}
+
+	return fmt.Errorf("transit key %s does not match the certificate public key", keyPath)
+}
+
+// checkRemoteKey checks that a version of the transit key holds ecdsaPublicKey
+func (v *VaultManager) checkRemoteKey(ctx context.Context, ecdsaPublicKey *ecdsa.PublicKey) error {
+	if v.transitKey == "" {
+		return errors.New("transit signing is not enabled")
+	}
+	return v.checkTransitKey(ctx, ecdsaPublicKey)
+}
+
+// parseTransitPublicKey parses the PEM public key of a transit key version,
+// versions trimmed by min_available_version have no public key and result in nil
+func parseTransitPublicKey(publicKeyPEM string) (*ecdsa.PublicKey, error) {
+	if publicKeyPEM == "" {
+		return nil, nil
+	}
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, errors.New("failed to decode public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaPublicKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("invalid key type, expecting ECDSA Public Key")
+	}
+	return ecdsaPublicKey, nil
+}
+
+// transitVersion returns the version of the transit key checked to match ecdsaPublicKey
+func (v *VaultManager) transitVersion(ecdsaPublicKey *ecdsa.PublicKey) (int, bool) {
+	v.transitMu.RLock()
+	defer v.transitMu.RUnlock()
+	version, ok := v.transitVersions[hexSKI(ecdsaPublicKey)]
+	return version, ok
+}
+
+// transitSign signs the SHA-256 digest with the version of the transit key matching ecdsaPublicKey
+// and returns a low-S DER signature
+func (v *VaultManager) transitSign(ctx context.Context, digest []byte, ecdsaPublicKey *ecdsa.PublicKey) ([]byte, error) {
+	if ecdsaPublicKey == nil {
+		return nil, errors.New("key has no public key")
+	}
+	version, ok := v.transitVersion(ecdsaPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not held by transit key %s", hexSKI(ecdsaPublicKey), v.transitKey)
+	}
+
+	secret, err := v.write(ctx, path.Join(v.transitMount, "sign", v.transitKey), map[string]interface{}{
+		"input":                base64.StdEncoding.EncodeToString(digest),
+		"key_version":          version,
+		"prehashed":            true,
+		"hash_algorithm":       "sha2-256",
+		"marshaling_algorithm": "asn1",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("empty response from transit sign")
+	}
+
+	vaultSignature, ok := secret.Data["signature"].(string)
+	if !ok {
+		return nil, errors.New("failed to cast transit signature to string")
+	}
+
+	// signature format is vault:v<key version>:<base64 DER signature>
+	parts := strings.SplitN(vaultSignature, ":", 3) //nolint:gomnd
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("unexpected transit signature format %s", vaultSignature)
+	}
+	if parts[1] != "v"+strconv.Itoa(version) {
+		return nil, fmt.Errorf("transit signature made with key version %s, expecting v%d", parts[1], version)
+	}
+	der, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	r, s, err := utils.UnmarshalECDSASignature(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmashalling signature [%w]", err)
+	}
+
+	signature, err := utils.ToLowS(ecdsaPublicKey, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.MarshalECDSASignature(r, signature)
+}
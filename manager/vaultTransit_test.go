@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// newTransitStub returns a stub with the certificate of User1 issued for version 1 of the transit key
+func newTransitStub(t *testing.T) (*vaultStub, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	stub := newVaultStub(kvVersion2)
+	key := stub.rotateTransit(t)
+	stub.putIdentity(t, "org1", testVaultCert, key)
+	return stub, key
+}
+
+func TestVaultTransitSigningPinsVersion(t *testing.T) {
+	stub, key := newTransitStub(t)
+	// a private key left in KV is not read in transit mode
+	ski := hex.EncodeToString((&CartridgeKey{PubKey: &key.PublicKey}).SKI())
+	stub.put("org1/"+ski+"_sk", newTestKeyPEM(t, key))
+
+	m, err := newStubVaultManager(t, stub, WithTransitSigning("transit", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Cache().GetCrypto(ski + "_sk"); err == nil {
+		t.Error("private key is read from KV")
+	}
+	checkSigned(t, m, &key.PublicKey)
+
+	// the certificate still holds version 1 after rotation of the transit key
+	stub.rotateTransit(t)
+	checkSigned(t, m, &key.PublicKey)
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	for i, version := range stub.signVersions {
+		if version != 1 {
+			t.Errorf("signature %d made with key version %d, want 1", i, version)
+		}
+	}
+}
+
+func TestVaultTransitOlderVersion(t *testing.T) {
+	stub, key := newTransitStub(t)
+	stub.rotateTransit(t)
+
+	m, err := newStubVaultManager(t, stub, WithTransitSigning("transit", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &key.PublicKey)
+}
+
+func TestVaultTransitRejectsForeignKey(t *testing.T) {
+	stub, _ := newTransitStub(t)
+	m, err := newStubVaultManager(t, stub, WithTransitSigning("transit", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. a public key imported through the crypto suite
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	if _, err = m.Sign(digest[:], &CartridgeKey{PubKey: &other.PublicKey}); err == nil || !strings.Contains(err.Error(), "not held by transit key") {
+		t.Errorf("err = %v, want rejection of a key not held by the transit key", err)
+	}
+	if n := stub.callCount("transit/sign/key"); n != 0 {
+		t.Errorf("%d sign requests sent for a foreign key", n)
+	}
+
+	// keys with a Signer are signed locally
+	signature, err := m.Sign(digest[:], &CartridgeKey{PubKey: &other.PublicKey, Signer: other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&other.PublicKey, digest[:], signature) {
+		t.Error("signature of a local key does not verify")
+	}
+}
+
+func TestVaultTransitKeyMismatch(t *testing.T) {
+	stub := newVaultStub(kvVersion2)
+	stub.rotateTransit(t)
+	stub.putIdentity(t, "org1", testVaultCert, nil)
+
+	_, err := newStubVaultManager(t, stub, WithTransitSigning("transit", "key"))
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("err = %v, want transit key mismatch", err)
+	}
+}
+
+func TestVaultTransitReload(t *testing.T) {
+	stub, key := newTransitStub(t)
+	m, err := newStubVaultManager(t, stub, WithTransitSigning("transit", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// a certificate issued for a key the transit key does not hold is not taken
+	foreign, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.putIdentity(t, "org1", testVaultCert, foreign)
+	if err = m.refreshCrypto(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &key.PublicKey)
+
+	// the certificate of a new version of the transit key is
+	rotated := stub.rotateTransit(t)
+	stub.putIdentity(t, "org1", testVaultCert, rotated)
+	if err = m.refreshCrypto(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkSigned(t, m, &rotated.PublicKey)
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if version := stub.signVersions[len(stub.signVersions)-1]; version != 2 {
+		t.Errorf("signature made with key version %d after reload, want 2", version)
+	}
+}
@@ -18,17 +18,19 @@ import (
 
 // ProviderFactory represents the default SDK provider factory.
 type ProviderFactory struct {
-	manager manager.Manager
+	manager         manager.Manager
+	cryptoSuiteOpts []CryptoSuiteOption
 }
 
 // NewCartridgeProviderFactory returns the default SDK provider factory.
-func NewCartridgeProviderFactory(manager manager.Manager) *ProviderFactory {
-	return &ProviderFactory{manager}
+// opts configure the crypto suite, e.g. the sign timeout.
+func NewCartridgeProviderFactory(manager manager.Manager, opts ...CryptoSuiteOption) *ProviderFactory {
+	return &ProviderFactory{manager: manager, cryptoSuiteOpts: opts}
 }
 
 // CreateCryptoSuiteProvider returns a new default implementation of BCCSP
 func (c *ProviderFactory) CreateCryptoSuiteProvider(_ core.CryptoSuiteConfig) (core.CryptoSuite, error) {
-	cryptoSuiteProvider := NewCartridgeCryptoSuite(c.manager)
+	cryptoSuiteProvider := NewCartridgeCryptoSuite(c.manager, c.cryptoSuiteOpts...)
 	return cryptoSuiteProvider, nil
 }
 
@@ -0,0 +1,189 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package txsign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/atomyze-foundation/cartridge/manager"
+	"github.com/golang/protobuf/proto" //nolint:staticcheck // fabric-protos-go v0.2.0 messages implement the v1 API only
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// nonceSize is the size of nonces of signature headers used by Fabric
+const nonceSize = 24
+
+// nonceReader is the source of nonces, replaced in tests
+var nonceReader io.Reader = rand.Reader
+
+// NewSignatureHeader returns the signature header with the serialized identity as creator and a random nonce,
+// to build proposals, transactions and config updates signed by identity
+func NewSignatureHeader(identity manager.CartridgeSigningIdentity) (*common.SignatureHeader, error) {
+	creator, err := identity.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize identity: %w", err)
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err = io.ReadFull(nonceReader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return &common.SignatureHeader{Creator: creator, Nonce: nonce}, nil
+}
+
+// TxID returns the transaction ID of a proposal or transaction with signature header nonce and creator
+func TxID(nonce, creator []byte) string {
+	hash := sha256.Sum256(append(append([]byte{}, nonce...), creator...))
+	return hex.EncodeToString(hash[:])
+}
+
+// SignProposal signs proposal to be sent to endorsing peers
+func SignProposal(identity manager.CartridgeSigningIdentity, proposal *peer.Proposal) (*peer.SignedProposal, error) {
+	proposalBytes, err := proto.Marshal(proposal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal proposal: %w", err)
+	}
+
+	signature, err := identity.Sign(proposalBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign proposal: %w", err)
+	}
+
+	return &peer.SignedProposal{ProposalBytes: proposalBytes, Signature: signature}, nil
+}
+
+// CreateSignedTx assembles the transaction of proposal endorsed by responses and signs it into the envelope
+// to be sent to orderers. identity must be the creator of the proposal, all responses must be successful
+// and endorse the same result. The transient map of the proposal is not included in the transaction.
+func CreateSignedTx(identity manager.CartridgeSigningIdentity, proposal *peer.Proposal, responses ...*peer.ProposalResponse) (*common.Envelope, error) {
+	if len(responses) == 0 {
+		return nil, errors.New("at least one proposal response is required")
+	}
+
+	header := &common.Header{}
+	if err := proto.Unmarshal(proposal.Header, header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proposal header: %w", err)
+	}
+	signatureHeader := &common.SignatureHeader{}
+	if err := proto.Unmarshal(header.SignatureHeader, signatureHeader); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal signature header: %w", err)
+	}
+	creator, err := identity.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize identity: %w", err)
+	}
+	if !bytes.Equal(creator, signatureHeader.Creator) {
+		return nil, errors.New("identity is not the creator of the proposal")
+	}
+
+	proposalPayload := &peer.ChaincodeProposalPayload{}
+	if err = proto.Unmarshal(proposal.Payload, proposalPayload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proposal payload: %w", err)
+	}
+
+	endorsements, err := endorsementsOf(responses)
+	if err != nil {
+		return nil, err
+	}
+
+	// the transient map holds private data that must not reach the ledger
+	proposalPayloadBytes, err := proto.Marshal(&peer.ChaincodeProposalPayload{Input: proposalPayload.Input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal proposal payload: %w", err)
+	}
+	actionPayloadBytes, err := proto.Marshal(&peer.ChaincodeActionPayload{
+		ChaincodeProposalPayload: proposalPayloadBytes,
+		Action: &peer.ChaincodeEndorsedAction{
+			ProposalResponsePayload: responses[0].Payload,
+			Endorsements:            endorsements,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal chaincode action payload: %w", err)
+	}
+
+	txBytes, err := proto.Marshal(&peer.Transaction{
+		Actions: []*peer.TransactionAction{{Header: header.SignatureHeader, Payload: actionPayloadBytes}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transaction: %w", err)
+	}
+
+	return SignPayload(identity, &common.Payload{Header: header, Data: txBytes})
+}
+
+// endorsementsOf returns endorsements of responses, one per endorser, checking that all responses
+// are successful and endorse the same result
+func endorsementsOf(responses []*peer.ProposalResponse) ([]*peer.Endorsement, error) {
+	endorsements := make([]*peer.Endorsement, 0, len(responses))
+	endorsers := make(map[string]struct{}, len(responses))
+	for n, response := range responses {
+		if response == nil || response.Response == nil || response.Endorsement == nil {
+			return nil, fmt.Errorf("proposal response %d is not endorsed", n)
+		}
+		if response.Response.Status < 200 || response.Response.Status >= 400 {
+			return nil, fmt.Errorf("proposal response %d was not successful, status %d: %s", n, response.Response.Status, response.Response.Message)
+		}
+		if !bytes.Equal(response.Payload, responses[0].Payload) {
+			return nil, fmt.Errorf("proposal response %d does not match the first one", n)
+		}
+
+		endorser := string(response.Endorsement.Endorser)
+		if _, ok := endorsers[endorser]; ok {
+			continue
+		}
+		endorsers[endorser] = struct{}{}
+		endorsements = append(endorsements, response.Endorsement)
+	}
+	return endorsements, nil
+}
+
+// SignPayload signs payload into an envelope, e.g. a transaction or a config update
+// to be sent to orderers
+func SignPayload(identity manager.CartridgeSigningIdentity, payload *common.Payload) (*common.Envelope, error) {
+	payloadBytes, err := proto.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	signature, err := identity.Sign(payloadBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign payload: %w", err)
+	}
+
+	return &common.Envelope{Payload: payloadBytes, Signature: signature}, nil
+}
+
+// SignConfigUpdate signs configUpdate, the ConfigUpdate field of common.ConfigUpdateEnvelope, as an admin
+// of an org. Signatures of required admins are collected into the Signatures of the envelope.
+func SignConfigUpdate(identity manager.CartridgeSigningIdentity, configUpdate []byte) (*common.ConfigSignature, error) {
+	signatureHeader, err := NewSignatureHeader(identity)
+	if err != nil {
+		return nil, err
+	}
+	signatureHeaderBytes, err := proto.Marshal(signatureHeader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signature header: %w", err)
+	}
+
+	signed := make([]byte, 0, len(signatureHeaderBytes)+len(configUpdate))
+	signed = append(append(signed, signatureHeaderBytes...), configUpdate...)
+	signature, err := identity.Sign(signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign config update: %w", err)
+	}
+
+	return &common.ConfigSignature{SignatureHeader: signatureHeaderBytes, Signature: signature}, nil
+}
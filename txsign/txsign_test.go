@@ -0,0 +1,273 @@
+/*
+Copyright Idea LCC. All Rights Reserved.
+
+SPDX-License-Identifier: [Default license](LICENSE)
+*/
+
+package txsign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/atomyze-foundation/cartridge/manager"
+	"github.com/golang/protobuf/proto" //nolint:staticcheck // fabric-protos-go v0.2.0 messages implement the v1 API only
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+const (
+	testChannel = "mychannel"
+	testCert    = "User1@org1.example.com-cert.pem"
+	otherCert   = "User2@org1.example.com-cert.pem"
+)
+
+// testNonce replaces the random nonce, so that proposals and transactions are reproducible
+var testNonce = []byte("0123456789abcdef01234567")
+
+// testIdentity returns the signing identity of certName of the MSP in testdata
+func testIdentity(t *testing.T, certName string) manager.CartridgeSigningIdentity {
+	t.Helper()
+
+	m, err := manager.NewFileManager("Org1MSP", testCert, filepath.Join("testdata", "msp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	if certName == testCert {
+		return m.SigningIdentity()
+	}
+
+	registry := manager.NewIdentityRegistry(m)
+	t.Cleanup(registry.Close)
+	identity, err := registry.Identity(certName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+// testProposal returns the proposal of an invoke of chaincode basic by identity with transient data
+func testProposal(t *testing.T, identity manager.CartridgeSigningIdentity) *peer.Proposal {
+	t.Helper()
+
+	creator, err := identity.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureHeader := mustMarshal(t, &common.SignatureHeader{Creator: creator, Nonce: testNonce})
+	channelHeader := mustMarshal(t, &common.ChannelHeader{
+		Type:      int32(common.HeaderType_ENDORSER_TRANSACTION),
+		ChannelId: testChannel,
+		TxId:      TxID(testNonce, creator),
+		Extension: mustMarshal(t, &peer.ChaincodeHeaderExtension{ChaincodeId: &peer.ChaincodeID{Name: "basic"}}),
+	})
+	input := mustMarshal(t, &peer.ChaincodeInvocationSpec{ChaincodeSpec: &peer.ChaincodeSpec{
+		Type:        peer.ChaincodeSpec_GOLANG,
+		ChaincodeId: &peer.ChaincodeID{Name: "basic"},
+		Input:       &peer.ChaincodeInput{Args: [][]byte{[]byte("transfer"), []byte("asset1"), []byte("User2")}},
+	}})
+
+	return &peer.Proposal{
+		Header: mustMarshal(t, &common.Header{ChannelHeader: channelHeader, SignatureHeader: signatureHeader}),
+		Payload: mustMarshal(t, &peer.ChaincodeProposalPayload{
+			Input:        input,
+			TransientMap: map[string][]byte{"price": []byte("transient secret")},
+		}),
+	}
+}
+
+// testResponse returns the successful response of endorser to the test proposal
+func testResponse(endorser string) *peer.ProposalResponse {
+	return &peer.ProposalResponse{
+		Version:     1,
+		Response:    &peer.Response{Status: 200, Payload: []byte("ok")},
+		Payload:     []byte("proposal response payload"),
+		Endorsement: &peer.Endorsement{Endorser: []byte(endorser), Signature: []byte("signature of " + endorser)},
+	}
+}
+
+func mustMarshal(t *testing.T, msg proto.Message) []byte {
+	t.Helper()
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkGolden compares data with the golden file name in testdata, the file is rewritten with -update
+func checkGolden(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	goldenPath := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(goldenPath, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, golden) {
+		t.Errorf("%s differs from golden file %s", name, goldenPath)
+	}
+}
+
+// checkSignature verifies signature of msg by the identity serialized as creator
+func checkSignature(t *testing.T, creator, msg, signature []byte) {
+	t.Helper()
+
+	identity, err := manager.DeserializeIdentity(creator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Identifier().MSPID != "Org1MSP" {
+		t.Errorf("signer MSP ID = %s", identity.Identifier().MSPID)
+	}
+	if err = identity.Verify(msg, signature); err != nil {
+		t.Errorf("signature does not verify: %s", err)
+	}
+}
+
+func TestSignProposal(t *testing.T) {
+	identity := testIdentity(t, testCert)
+	proposal := testProposal(t, identity)
+
+	signed, err := SignProposal(identity, proposal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "proposal.golden", signed.ProposalBytes)
+
+	creator, _ := identity.Serialize()
+	checkSignature(t, creator, signed.ProposalBytes, signed.Signature)
+}
+
+func TestCreateSignedTx(t *testing.T) {
+	identity := testIdentity(t, testCert)
+	proposal := testProposal(t, identity)
+
+	// the second response of peer0 adds no endorsement
+	envelope, err := CreateSignedTx(identity, proposal, testResponse("peer0"), testResponse("peer1"), testResponse("peer0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "tx.golden", envelope.Payload)
+
+	payload := &common.Payload{}
+	if err = proto.Unmarshal(envelope.Payload, payload); err != nil {
+		t.Fatal(err)
+	}
+	signatureHeader := &common.SignatureHeader{}
+	if err = proto.Unmarshal(payload.Header.SignatureHeader, signatureHeader); err != nil {
+		t.Fatal(err)
+	}
+	checkSignature(t, signatureHeader.Creator, envelope.Payload, envelope.Signature)
+
+	tx := &peer.Transaction{}
+	if err = proto.Unmarshal(payload.Data, tx); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Actions) != 1 {
+		t.Fatalf("%d transaction actions, want 1", len(tx.Actions))
+	}
+	actionPayload := &peer.ChaincodeActionPayload{}
+	if err = proto.Unmarshal(tx.Actions[0].Payload, actionPayload); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(actionPayload.Action.Endorsements); n != 2 {
+		t.Errorf("%d endorsements, want 2", n)
+	}
+	proposalPayload := &peer.ChaincodeProposalPayload{}
+	if err = proto.Unmarshal(actionPayload.ChaincodeProposalPayload, proposalPayload); err != nil {
+		t.Fatal(err)
+	}
+	if len(proposalPayload.TransientMap) != 0 || bytes.Contains(envelope.Payload, []byte("transient secret")) {
+		t.Error("transient map is included in the transaction")
+	}
+	if len(proposalPayload.Input) == 0 {
+		t.Error("chaincode input is not included in the transaction")
+	}
+}
+
+func TestCreateSignedTxRejects(t *testing.T) {
+	identity := testIdentity(t, testCert)
+	proposal := testProposal(t, identity)
+
+	withStatus := func(status int32) *peer.ProposalResponse {
+		response := testResponse("peer1")
+		response.Response.Status = status
+		return response
+	}
+	mismatched := testResponse("peer1")
+	mismatched.Payload = []byte("another result")
+
+	tests := []struct {
+		name      string
+		identity  manager.CartridgeSigningIdentity
+		responses []*peer.ProposalResponse
+		err       string
+	}{
+		{name: "foreign creator", identity: testIdentity(t, otherCert), responses: []*peer.ProposalResponse{testResponse("peer0")}, err: "not the creator"},
+		{name: "no responses", identity: identity, err: "at least one proposal response"},
+		{name: "mismatched payloads", identity: identity, responses: []*peer.ProposalResponse{testResponse("peer0"), mismatched}, err: "does not match"},
+		{name: "informational status", identity: identity, responses: []*peer.ProposalResponse{testResponse("peer0"), withStatus(100)}, err: "status 100"},
+		{name: "error status", identity: identity, responses: []*peer.ProposalResponse{testResponse("peer0"), withStatus(500)}, err: "status 500"},
+		{name: "not endorsed", identity: identity, responses: []*peer.ProposalResponse{{Response: &peer.Response{Status: 200}}}, err: "not endorsed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := CreateSignedTx(test.identity, proposal, test.responses...)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("err = %v, want %q", err, test.err)
+			}
+		})
+	}
+
+	// redirects are successful responses
+	if _, err := CreateSignedTx(identity, proposal, testResponse("peer0"), withStatus(302)); err != nil {
+		t.Errorf("status 302: %s", err)
+	}
+}
+
+func TestSignConfigUpdate(t *testing.T) {
+	identity := testIdentity(t, testCert)
+	configUpdate := mustMarshal(t, &common.ConfigUpdate{ChannelId: testChannel})
+
+	nonceReader = bytes.NewReader(testNonce)
+	defer func() { nonceReader = rand.Reader }()
+	signature, err := SignConfigUpdate(identity, configUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ECDSA signatures are randomized, the envelope is compared without them
+	checkGolden(t, "config_update.golden", mustMarshal(t, &common.ConfigUpdateEnvelope{
+		ConfigUpdate: configUpdate,
+		Signatures:   []*common.ConfigSignature{{SignatureHeader: signature.SignatureHeader}},
+	}))
+
+	signatureHeader := &common.SignatureHeader{}
+	if err = proto.Unmarshal(signature.SignatureHeader, signatureHeader); err != nil {
+		t.Fatal(err)
+	}
+	creator, _ := identity.Serialize()
+	if !bytes.Equal(signatureHeader.Creator, creator) {
+		t.Error("creator is not the signing identity")
+	}
+
+	// orderers verify the signature over the signature header followed by the config update
+	signed := append(append([]byte{}, signature.SignatureHeader...), configUpdate...)
+	checkSignature(t, signatureHeader.Creator, signed, signature.Signature)
+}